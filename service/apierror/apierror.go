@@ -0,0 +1,230 @@
+// Package apierror is the error taxonomy of the service plugin. Every failure answered by the API is an
+// *Error with the HTTP status, a machine-readable code and, for validation failures, the invalid fields:
+//
+//	{"status": 400, "code": "validation_failed", "message": "...", "fields": [{"field": "spec.ports", "message": "..."}]}
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/go-sql-driver/mysql"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	CodeValidation   = "validation_failed"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeNotFound     = "not_found"
+	CodeConflict     = "conflict"
+	CodeRateLimited  = "rate_limited"
+	CodeInternal     = "internal"
+	// CodeUpstream is a failure of the API server of a cluster, or of reaching it.
+	CodeUpstream = "upstream_cluster_error"
+)
+
+// internalMessage is answered for internal errors instead of their detail.
+const internalMessage = "Internal server error."
+
+// mysqlDuplicateEntry is the MySQL error number of unique key violations.
+const mysqlDuplicateEntry = 1062
+
+// FieldError is the failure of one field of the request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error answered by the API.
+type Error struct {
+	Status  int          `json:"status"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	// Cluster is set for the failures of a cluster.
+	Cluster   string `json:"cluster,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+
+	err error
+}
+
+func (e *Error) Error() string {
+	if e.Cluster != "" {
+		return fmt.Sprintf("cluster %s: %s", e.Cluster, e.Message)
+	}
+	return e.Message
+}
+
+// Unwrap returns the error e was made of, e.g. orm.ErrNoRows.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func newError(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func Validation(message string, fields ...FieldError) *Error {
+	e := newError(http.StatusBadRequest, CodeValidation, message)
+	e.Fields = fields
+	return e
+}
+
+// InvalidParam is the validation failure of a param or body which can not be parsed.
+func InvalidParam(param string) *Error {
+	return Validation(fmt.Sprintf("Invalid param %s.", param), FieldError{Field: param, Message: "invalid format"})
+}
+
+func Unauthorized(message string) *Error {
+	return newError(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return newError(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return newError(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string, fields ...FieldError) *Error {
+	e := newError(http.StatusConflict, CodeConflict, message)
+	e.Fields = fields
+	return e
+}
+
+func RateLimited(message string) *Error {
+	return newError(http.StatusTooManyRequests, CodeRateLimited, message)
+}
+
+func Internal(message string) *Error {
+	return newError(http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Upstream is the failure of the API server of cluster, or of reaching it.
+func Upstream(cluster string, err error) *Error {
+	e := newError(http.StatusBadGateway, CodeUpstream, err.Error())
+	e.Cluster = cluster
+	e.err = err
+	return e
+}
+
+// Query returns the error of a database query looking up what, e.g. "service 12": orm.ErrNoRows
+// becomes a not found and unique key violations a conflict. Other errors are returned as is.
+func Query(err error, what string) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if errors.Is(err, orm.ErrNoRows) {
+		e = NotFound(fmt.Sprintf("%s not found.", what))
+		e.err = err
+		return e
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		e = Conflict(fmt.Sprintf("%s already exists.", what))
+		e.err = err
+		return e
+	}
+	return err
+}
+
+// FromCluster returns the errors of requests to the API server of cluster as an *Error naming the
+// cluster. The API server rejecting an invalid or conflicting object is a validation failure or a
+// conflict, other failures are upstream errors. Errors not coming from the API server are returned as is.
+func FromCluster(cluster string, err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	var status kubeerrors.APIStatus
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &status):
+		e = fromStatus(err, status)
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		e = Upstream(cluster, err)
+	default:
+		return err
+	}
+	e.Cluster = cluster
+	return e
+}
+
+func fromStatus(err error, status kubeerrors.APIStatus) *Error {
+	var e *Error
+	switch {
+	case kubeerrors.IsInvalid(err):
+		e = Validation(status.Status().Message)
+		if details := status.Status().Details; details != nil {
+			for _, cause := range details.Causes {
+				e.Fields = append(e.Fields, FieldError{Field: cause.Field, Message: cause.Message})
+			}
+		}
+	case kubeerrors.IsConflict(err), kubeerrors.IsAlreadyExists(err):
+		e = Conflict(status.Status().Message)
+	default:
+		e = newError(http.StatusBadGateway, CodeUpstream, status.Status().Message)
+	}
+	e.err = err
+	return e
+}
+
+// From returns err as an *Error, classifying the errors of the database and of the API servers
+// which were not yet. Unknown errors are internal errors with a generic message, their detail
+// (e.g. SQL or driver errors) is only kept in the unwrapped error for the logs.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, orm.ErrNoRows) {
+		e = NotFound("Resource not found.")
+		e.err = err
+		return e
+	}
+	var status kubeerrors.APIStatus
+	if errors.As(err, &status) {
+		return fromStatus(err, status)
+	}
+	e = Internal(internalMessage)
+	e.err = err
+	return e
+}
+
+// IsNotFound reports whether err is a not found error, including a raw orm.ErrNoRows.
+func IsNotFound(err error) bool {
+	return err != nil && From(err).Code == CodeNotFound
+}
+
+// CodeOf returns the code of a failed response status.
+func CodeOf(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return CodeUpstream
+	}
+	if status < http.StatusInternalServerError {
+		return CodeValidation
+	}
+	return CodeInternal
+}
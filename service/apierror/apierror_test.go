@@ -0,0 +1,209 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/go-sql-driver/mysql"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var (
+	errDuplicate = &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'web' for key 'name'"}
+	errInvalid   = kubeerrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "web", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "ports"), 0, "must be between 1 and 65535"),
+	})
+	errRefused = &url.Error{Op: "Get", URL: "https://10.0.0.1:6443/api/v1/namespaces/default/services/web",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}}
+)
+
+func checkError(t *testing.T, err error, status int, code string, cluster string, fields []FieldError) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %T %v, want an *Error", err, err)
+	}
+	if e.Status != status || e.Code != code {
+		t.Errorf("got %d %s, want %d %s", e.Status, e.Code, status, code)
+	}
+	if e.Cluster != cluster {
+		t.Errorf("cluster %q, want %q", e.Cluster, cluster)
+	}
+	if !reflect.DeepEqual(e.Fields, fields) {
+		t.Errorf("fields %+v, want %+v", e.Fields, fields)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{name: "no rows", err: orm.ErrNoRows, status: http.StatusNotFound, code: CodeNotFound, message: "service 12 not found."},
+		{name: "wrapped no rows", err: fmt.Errorf("get service: %w", orm.ErrNoRows), status: http.StatusNotFound, code: CodeNotFound, message: "service 12 not found."},
+		{name: "duplicate entry", err: errDuplicate, status: http.StatusConflict, code: CodeConflict, message: "service 12 already exists."},
+		{name: "classified", err: Forbidden("no."), status: http.StatusForbidden, code: CodeForbidden, message: "no."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Query(test.err, "service 12")
+			checkError(t, err, test.status, test.code, "", nil)
+			if err.Error() != test.message {
+				t.Errorf("message %q, want %q", err.Error(), test.message)
+			}
+		})
+	}
+
+	if err := Query(nil, "service 12"); err != nil {
+		t.Errorf("got %v for no error", err)
+	}
+	other := errors.New("bad connection")
+	if err := Query(other, "service 12"); err != other {
+		t.Errorf("got %v, want other errors as is", err)
+	}
+}
+
+func TestFromCluster(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		fields []FieldError
+	}{
+		{
+			name:   "invalid",
+			err:    errInvalid,
+			status: http.StatusBadRequest,
+			code:   CodeValidation,
+			fields: []FieldError{{Field: "spec.ports", Message: "Invalid value: 0: must be between 1 and 65535"}},
+		},
+		{
+			name:   "conflict",
+			err:    kubeerrors.NewConflict(schema.GroupResource{Resource: "services"}, "web", errors.New("the object has been modified")),
+			status: http.StatusConflict,
+			code:   CodeConflict,
+		},
+		{
+			name:   "already exists",
+			err:    kubeerrors.NewAlreadyExists(schema.GroupResource{Resource: "services"}, "web"),
+			status: http.StatusConflict,
+			code:   CodeConflict,
+		},
+		{
+			name:   "api server forbidden",
+			err:    kubeerrors.NewForbidden(schema.GroupResource{Resource: "services"}, "web", errors.New("RBAC")),
+			status: http.StatusBadGateway,
+			code:   CodeUpstream,
+		},
+		{
+			name:   "connection refused",
+			err:    errRefused,
+			status: http.StatusBadGateway,
+			code:   CodeUpstream,
+		},
+		{
+			name:   "network error",
+			err:    &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")},
+			status: http.StatusBadGateway,
+			code:   CodeUpstream,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := FromCluster("bj", test.err)
+			checkError(t, err, test.status, test.code, "bj", test.fields)
+			if !errors.Is(err, test.err) {
+				t.Errorf("%v does not wrap %v", err, test.err)
+			}
+		})
+	}
+
+	if err := FromCluster("bj", nil); err != nil {
+		t.Errorf("got %v for no error", err)
+	}
+	other := errors.New("template format error")
+	if err := FromCluster("bj", other); err != other {
+		t.Errorf("got %v, want errors not coming from the API server as is", err)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+		fields  []FieldError
+	}{
+		{name: "no rows", err: orm.ErrNoRows, status: http.StatusNotFound, code: CodeNotFound, message: "Resource not found."},
+		{name: "duplicate entry", err: Query(errDuplicate, "service web"), status: http.StatusConflict, code: CodeConflict, message: "service web already exists."},
+		{
+			name:    "invalid",
+			err:     errInvalid,
+			status:  http.StatusBadRequest,
+			code:    CodeValidation,
+			message: errInvalid.Error(),
+			fields:  []FieldError{{Field: "spec.ports", Message: "Invalid value: 0: must be between 1 and 65535"}},
+		},
+		{name: "upstream", err: FromCluster("bj", errRefused), status: http.StatusBadGateway, code: CodeUpstream, message: "cluster bj: " + errRefused.Error()},
+		{
+			name:    "sql error",
+			err:     &mysql.MySQLError{Number: 1054, Message: "Unknown column 'service.foo' in 'field list'"},
+			status:  http.StatusInternalServerError,
+			code:    CodeInternal,
+			message: internalMessage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := From(test.err)
+			cluster := ""
+			if test.code == CodeUpstream {
+				cluster = "bj"
+			}
+			checkError(t, e, test.status, test.code, cluster, test.fields)
+			if e.Error() != test.message {
+				t.Errorf("message %q, want %q", e.Error(), test.message)
+			}
+			if !errors.Is(e, test.err) {
+				t.Errorf("%v does not wrap %v", e, test.err)
+			}
+		})
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusBadRequest, CodeValidation},
+		{http.StatusUnprocessableEntity, CodeValidation},
+		{http.StatusUnauthorized, CodeUnauthorized},
+		{http.StatusForbidden, CodeForbidden},
+		{http.StatusNotFound, CodeNotFound},
+		{http.StatusConflict, CodeConflict},
+		{http.StatusTooManyRequests, CodeRateLimited},
+		{http.StatusInternalServerError, CodeInternal},
+		{http.StatusBadGateway, CodeUpstream},
+		{http.StatusGatewayTimeout, CodeUpstream},
+	}
+	for _, test := range tests {
+		if code := CodeOf(test.status); code != test.code {
+			t.Errorf("CodeOf(%d) = %s, want %s", test.status, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/astaxie/beego/context"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+)
+
+// permissionPublish is the action of handlers publishing to clusters, it requires
+// the kubernetes service permission of the app rather than the service one.
+const permissionPublish = "PUBLISH"
+
+// permissionProbe is the action of handlers connecting to the live Services of the app, it requires
+// the kubernetes service read permission and is never allowed for tokens.
+const permissionProbe = "PROBE"
+
+// permission action -> service token scope
+var tokenScopes = map[string]string{
+	models.PermissionRead:   svcmodel.ServiceScopeRead,
+	models.PermissionCreate: svcmodel.ServiceScopeCreate,
+	models.PermissionUpdate: svcmodel.ServiceScopeUpdate,
+	models.PermissionDelete: svcmodel.ServiceScopeDelete,
+	permissionPublish:       svcmodel.ServiceScopePublish,
+}
+
+// serviceTokenFromHeader returns the plaintext service token if the request carries one.
+func serviceTokenFromHeader(c *base.APIController) (string, bool) {
+	auth := c.Ctx.Input.Header("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	if !strings.HasPrefix(token, svcmodel.ServiceTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
+// prepareServiceAccess authenticates the request either with a service token or with
+// the login session, then checks perAction against the token scopes or the user's permissions.
+func prepareServiceAccess(c *base.APIController, perAction string) {
+	if token, ok := serviceTokenFromHeader(c); ok {
+		prepareServiceToken(c, token, tokenScopes[perAction])
+		logUser(c)
+		return
+	}
+
+	// Check administration
+	c.Prepare()
+	logUser(c)
+	// Check permission
+	if perAction != "" {
+		checkServicePermission(c, perAction)
+	}
+}
+
+// checkServicePermission aborts unless the logged in user holds perAction in the app.
+func checkServicePermission(c *base.APIController, perAction string) {
+	switch perAction {
+	case permissionPublish:
+		c.CheckPermission(models.PermissionTypeKubeService, models.PermissionCreate)
+		return
+	case permissionProbe:
+		c.CheckPermission(models.PermissionTypeKubeService, models.PermissionRead)
+		return
+	}
+	c.CheckPermission(models.PermissionTypeService, perAction)
+}
+
+// prepareServiceToken validates token for the app in the url and the required scope.
+// Actions without a scope are never allowed for tokens.
+func prepareServiceToken(c *base.APIController, plain string, scope string) {
+	appId, err := strconv.ParseInt(c.Ctx.Input.Param(":appid"), 10, 64)
+	if err != nil {
+		abortError(c, apierror.InvalidParam("AppId"))
+	}
+
+	token, err := svcmodel.ServiceTokenModel.GetByToken(plain)
+	if err != nil {
+		requestLog(c.Ctx).Info("get service token error. %v", err)
+		abortError(c, apierror.Unauthorized("Invalid service token."))
+	}
+	if token.Revoked || token.Expired() {
+		abortError(c, apierror.Unauthorized("Service token is revoked or expired."))
+	}
+	if token.AppId != appId {
+		abortError(c, apierror.Forbidden("Service token is not allowed to access this app."))
+	}
+	if scope == "" || !token.HasScope(scope) {
+		abortError(c, apierror.Forbidden("Service token is missing the required scope."))
+	}
+
+	if err := svcmodel.ServiceTokenModel.Touch(token); err != nil {
+		requestLog(c.Ctx).Warning("update service token (%d) last used time error. %v", token.Id, err)
+	}
+
+	c.AppId = appId
+	c.User = &models.User{
+		Name:    "token:" + token.Name,
+		Display: token.Name,
+	}
+}
+
+// appService returns the service id, which must belong to the app of the url. Tokens and users are
+// only checked against the app of the url, every handler reading a service by id goes through it.
+func appService(c *base.APIController, id int64) *models.Service {
+	service, err := svcmodel.ServiceModel.GetById(id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get service (%d) error.%v", id, err)
+		abortError(c, err)
+	}
+	if service.AppId != c.AppId {
+		abortError(c, apierror.Forbidden("Service does not belong to this app."))
+	}
+	return service
+}
+
+// appTemplate returns the template id and its service, which must belong to the app of the url.
+func appTemplate(c *base.APIController, id int64) (*models.ServiceTemplate, *models.Service) {
+	tpl, err := svcmodel.ServiceTplModel.GetById(id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get template (%d) error.%v", id, err)
+		abortError(c, err)
+	}
+	service, err := svcmodel.ServiceModel.GetById(tpl.ServiceId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get service (%d) error.%v", tpl.ServiceId, err)
+		abortError(c, err)
+	}
+	if service.AppId != c.AppId {
+		abortError(c, apierror.Forbidden("Template does not belong to this app."))
+	}
+	return tpl, service
+}
+
+// PermissionsChanged is a filter on the wayne APIs managing app memberships, groups and permissions.
+// It drops the cached readable apps once a change succeeded, so revoked access ends right away.
+func PermissionsChanged(ctx *context.Context) {
+	switch ctx.Input.Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if ctx.ResponseWriter.Status >= http.StatusBadRequest {
+		return
+	}
+	svcmodel.PermittedAppCache.InvalidateAll()
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+)
+
+// cursorPaging reports whether the list request asks for cursor pagination
+// instead of the default pageNo/pageSize pagination.
+func cursorPaging(c *base.APIController) bool {
+	return c.Input().Get("cursor") != "" || c.Input().Get("paging") == "cursor"
+}
+
+// cursorQueryFromInput parses the sort, cursor, limit and skipTotal parameters.
+// sort is validated against keys, def is used when it is absent.
+func cursorQueryFromInput(c *base.APIController, def svcmodel.Sort, keys ...svcmodel.SortKey) *svcmodel.CursorQuery {
+	sort, err := svcmodel.ParseSort(c.Input().Get("sort"), def, keys...)
+	if err != nil {
+		abortError(c, apierror.Validation(err.Error()))
+	}
+	cursor, err := svcmodel.DecodeCursor(c.Input().Get("cursor"))
+	if err != nil {
+		abortError(c, apierror.InvalidParam("cursor"))
+	}
+	if err := sort.CheckCursor(cursor); err != nil {
+		abortError(c, err)
+	}
+	skipTotal, _ := c.GetBool("skipTotal", false)
+
+	return &svcmodel.CursorQuery{
+		Sort:      sort,
+		Cursor:    cursor,
+		Limit:     svcmodel.ParseCursorLimit(c.Input().Get("limit")),
+		SkipTotal: skipTotal,
+	}
+}
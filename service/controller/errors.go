@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+// abortError responds to err with its status and a JSON error body, see apierror.
+// Errors outside of the taxonomy are internal errors, answered without their detail which is logged instead.
+func abortError(c *base.APIController, err error) {
+	e := *apiError(err)
+	log := requestLog(c.Ctx)
+	if e.Code == apierror.CodeInternal {
+		log.Error("internal error.%v", err)
+	}
+	e.RequestId = log.RequestId()
+	body, marshalErr := json.Marshal(&e)
+	if marshalErr != nil {
+		body = []byte(fmt.Sprintf(`{"status":%d,"code":%q}`, e.Status, e.Code))
+	}
+	c.Ctx.Output.Header("Content-Type", "application/json; charset=utf-8")
+	c.CustomAbort(e.Status, hack.String(body))
+}
+
+// errorMessage returns the message of err answered in the results of a cluster, without the
+// detail of internal errors.
+func errorMessage(err error) string {
+	return apiError(err).Error()
+}
+
+// apiError maps the errors of the plugin packages to the taxonomy, also when wrapped.
+func apiError(err error) *apierror.Error {
+	var (
+		lintResult     *lint.Result
+		denied         *policy.Denied
+		formatErr      *templateFormatError
+		recreateErr    *resources.RecreateRequiredError
+		applyConflicts *resources.ApplyConflictError
+	)
+	switch {
+	case errors.As(err, &lintResult):
+		fields := []apierror.FieldError{}
+		for _, v := range lintResult.Blocking() {
+			fields = append(fields, apierror.FieldError{Field: v.Field, Message: v.String()})
+		}
+		return apierror.Validation(lintResult.Error(), fields...)
+	case errors.As(err, &denied):
+		return apierror.Forbidden(denied.Error())
+	case errors.As(err, &formatErr):
+		return apierror.InvalidParam("KubeService")
+	case errors.As(err, &recreateErr):
+		fields := make([]apierror.FieldError, 0, len(recreateErr.Changes))
+		for _, change := range recreateErr.Changes {
+			fields = append(fields, apierror.FieldError{Field: change.Field, Message: change.String()})
+		}
+		validation := apierror.Validation(recreateErr.Error(), fields...)
+		validation.Cluster = recreateErr.Cluster
+		return validation
+	case errors.As(err, &applyConflicts):
+		fields := make([]apierror.FieldError, 0, len(applyConflicts.Conflicts))
+		for _, conflict := range applyConflicts.Conflicts {
+			fields = append(fields, apierror.FieldError{Field: conflict.Field, Message: "owned by " + conflict.Manager})
+		}
+		return apierror.Conflict(applyConflicts.Error(), fields...)
+	}
+	return apierror.From(err)
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/orm"
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+func TestAPIError(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "https://10.0.0.1:6443/api/v1/namespaces/default/services/web",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}}
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		cluster string
+		fields  []apierror.FieldError
+	}{
+		{
+			name:   "no rows",
+			err:    orm.ErrNoRows,
+			status: http.StatusNotFound,
+			code:   apierror.CodeNotFound,
+		},
+		{
+			name:   "duplicate entry",
+			err:    apierror.Query(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'web' for key 'name'"}, "service web"),
+			status: http.StatusConflict,
+			code:   apierror.CodeConflict,
+		},
+		{
+			name: "lint blocked",
+			err: &lint.Result{Blocked: true, Violations: []lint.Violation{
+				{Rule: "port-range", Severity: lint.SeverityBlock, Field: "spec.ports[0].port", Message: "port out of range"},
+				{Rule: "selector", Severity: lint.SeverityWarn, Field: "spec.selector", Message: "no selector"},
+			}},
+			status: http.StatusBadRequest,
+			code:   apierror.CodeValidation,
+			fields: []apierror.FieldError{{Field: "spec.ports[0].port", Message: "[port-range] spec.ports[0].port: port out of range"}},
+		},
+		{
+			name:   "template format",
+			err:    &templateFormatError{err: errors.New("unexpected end of JSON input")},
+			status: http.StatusBadRequest,
+			code:   apierror.CodeValidation,
+			fields: []apierror.FieldError{{Field: "KubeService", Message: "invalid format"}},
+		},
+		{
+			name: "wrapped lint blocked",
+			err: fmt.Errorf("validate template: %w", &lint.Result{Blocked: true, Violations: []lint.Violation{
+				{Rule: "port-range", Severity: lint.SeverityBlock, Field: "spec.ports[0].port", Message: "port out of range"},
+			}}),
+			status: http.StatusBadRequest,
+			code:   apierror.CodeValidation,
+			fields: []apierror.FieldError{{Field: "spec.ports[0].port", Message: "[port-range] spec.ports[0].port: port out of range"}},
+		},
+		{
+			name:   "policy denied",
+			err:    &policy.Denied{Messages: []string{"LoadBalancer services need approval"}},
+			status: http.StatusForbidden,
+			code:   apierror.CodeForbidden,
+		},
+		{
+			name:   "wrapped policy denied",
+			err:    fmt.Errorf("validate template: %w", &policy.Denied{Messages: []string{"LoadBalancer services need approval"}}),
+			status: http.StatusForbidden,
+			code:   apierror.CodeForbidden,
+		},
+		{
+			name: "recreate required",
+			err: &resources.RecreateRequiredError{Cluster: "bj", Changes: []resources.ImmutableChange{
+				{Field: "spec.clusterIP", Live: "10.0.0.1", Desired: "10.0.0.2"},
+			}},
+			status:  http.StatusBadRequest,
+			code:    apierror.CodeValidation,
+			cluster: "bj",
+			fields:  []apierror.FieldError{{Field: "spec.clusterIP", Message: resources.ImmutableChange{Field: "spec.clusterIP", Live: "10.0.0.1", Desired: "10.0.0.2"}.String()}},
+		},
+		{
+			name: "apply conflict",
+			err: &resources.ApplyConflictError{Conflicts: []resources.ApplyConflict{
+				{Manager: "cloud-controller-manager", Field: ".metadata.annotations.x"},
+			}},
+			status: http.StatusConflict,
+			code:   apierror.CodeConflict,
+			fields: []apierror.FieldError{{Field: ".metadata.annotations.x", Message: "owned by cloud-controller-manager"}},
+		},
+		{
+			name:    "network error",
+			err:     apierror.FromCluster("bj", refused),
+			status:  http.StatusBadGateway,
+			code:    apierror.CodeUpstream,
+			cluster: "bj",
+		},
+		{
+			name:   "unknown",
+			err:    errors.New("dial tcp 127.0.0.1:3306: connect: connection refused"),
+			status: http.StatusInternalServerError,
+			code:   apierror.CodeInternal,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := apiError(test.err)
+			if e.Status != test.status || e.Code != test.code {
+				t.Errorf("got %d %s, want %d %s", e.Status, e.Code, test.status, test.code)
+			}
+			if e.Cluster != test.cluster {
+				t.Errorf("cluster %q, want %q", e.Cluster, test.cluster)
+			}
+			if !reflect.DeepEqual(e.Fields, test.fields) {
+				t.Errorf("fields %+v, want %+v", e.Fields, test.fields)
+			}
+		})
+	}
+}
+
+func TestErrorMessageHidesInternalErrors(t *testing.T) {
+	err := &mysql.MySQLError{Number: 1054, Message: "Unknown column 'service.foo' in 'field list'"}
+	if message := errorMessage(err); message != "Internal server error." {
+		t.Errorf("answered %q for a SQL error", message)
+	}
+	denied := &policy.Denied{Messages: []string{"LoadBalancer services need approval"}}
+	if message := errorMessage(denied); message != denied.Error() {
+		t.Errorf("answered %q, want %q", message, denied.Error())
+	}
+}
+
+// serve runs handler on a request with body for the controller c, recovering its abort like the
+// router, and returns the response.
+func serve(c *base.APIController, body string, handler func()) (rec *httptest.ResponseRecorder) {
+	rec = httptest.NewRecorder()
+	c.Ctx = context.NewContext()
+	c.Ctx.Reset(rec, httptest.NewRequest(http.MethodPost, "/api/v1/apps/1/services/12/clone", strings.NewReader(body)))
+	c.Ctx.Input.RequestBody = []byte(body)
+	c.AppId = 1
+	setRequestLogger(c.Ctx, logging.New(logging.FieldRequestId, "abc"))
+	defer func() {
+		if r := recover(); r != nil && r != beego.ErrAbort {
+			panic(r)
+		}
+	}()
+	handler()
+	return rec
+}
+
+func TestHandlerFailures(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "https://10.0.0.1:6443/api/v1/namespaces/default/services/web",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}}
+	aborting := func(err error) func(c *ServiceController) func() {
+		return func(c *ServiceController) func() {
+			return func() { abortError(&c.APIController, err) }
+		}
+	}
+	tests := []struct {
+		name    string
+		body    string
+		handler func(c *ServiceController) func()
+		want    apierror.Error
+	}{
+		{
+			name:    "bad body",
+			body:    `{"name":`,
+			handler: func(c *ServiceController) func() { return c.Clone },
+			want: apierror.Error{Status: http.StatusBadRequest, Code: apierror.CodeValidation, Message: "Invalid param CloneService.",
+				Fields: []apierror.FieldError{{Field: "CloneService", Message: "invalid format"}}},
+		},
+		{
+			name:    "invalid name",
+			body:    `{"name":"Web_1"}`,
+			handler: func(c *ServiceController) func() { return c.Clone },
+			want:    apierror.Error{Status: http.StatusBadRequest, Code: apierror.CodeValidation},
+		},
+		{
+			name:    "not found",
+			handler: aborting(logging.WithFields(apierror.Query(orm.ErrNoRows, "service 12"), logging.FieldQuery, "serviceModel.GetById")),
+			want:    apierror.Error{Status: http.StatusNotFound, Code: apierror.CodeNotFound, Message: "service 12 not found."},
+		},
+		{
+			name:    "forbidden app",
+			handler: aborting(apierror.Forbidden("Service does not belong to this app.")),
+			want:    apierror.Error{Status: http.StatusForbidden, Code: apierror.CodeForbidden, Message: "Service does not belong to this app."},
+		},
+		{
+			name:    "cluster failure",
+			handler: aborting(fmt.Errorf("publish service (12): %w", apierror.FromCluster("bj", refused))),
+			want:    apierror.Error{Status: http.StatusBadGateway, Code: apierror.CodeUpstream, Message: refused.Error(), Cluster: "bj"},
+		},
+		{
+			name: "internal",
+			handler: aborting(logging.WithFields(&mysql.MySQLError{Number: 1054, Message: "Unknown column 'service.foo' in 'field list'"},
+				logging.FieldQuery, "serviceModel.GetById")),
+			want: apierror.Error{Status: http.StatusInternalServerError, Code: apierror.CodeInternal, Message: "Internal server error."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &ServiceController{}
+			rec := serve(&c.APIController, test.body, test.handler(c))
+			if rec.Code != test.want.Status {
+				t.Fatalf("answered %d %s, want %d", rec.Code, rec.Body.String(), test.want.Status)
+			}
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+				t.Errorf("content type %q", contentType)
+			}
+			var got apierror.Error
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %s: %v", rec.Body.String(), err)
+			}
+			if got.Status != test.want.Status || got.Code != test.want.Code || got.Cluster != test.want.Cluster || got.RequestId != "abc" {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			if test.want.Message != "" && got.Message != test.want.Message {
+				t.Errorf("message %q, want %q", got.Message, test.want.Message)
+			}
+			if test.want.Fields != nil && !reflect.DeepEqual(got.Fields, test.want.Fields) {
+				t.Errorf("fields %+v, want %+v", got.Fields, test.want.Fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	gocontext "context"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/context"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+// prepareLogger starts the logger of the request with the request id, given by the client in
+// X-Request-Id or generated, the action and the ids of the url. idField names the field of the
+// :id of the url. The request is logged with its status and latency once answered.
+func prepareLogger(c *base.APIController, idField string) {
+	controller, method := c.GetControllerAndAction()
+	requestId := c.Ctx.Input.Header(logging.HeaderRequestId)
+	if requestId == "" || len(requestId) > 128 {
+		requestId = logging.NewRequestId()
+	}
+	c.Ctx.Output.Header(logging.HeaderRequestId, requestId)
+
+	l := logging.New(
+		logging.FieldRequestId, requestId,
+		logging.FieldAction, strings.TrimSuffix(controller, "Controller")+"."+method)
+	if appId := c.Ctx.Input.Param(":appid"); appId != "" {
+		l = l.With(logging.FieldAppId, appId)
+	}
+	if serviceId := c.Ctx.Input.Param(":serviceid"); serviceId != "" {
+		l = l.With(logging.FieldServiceId, serviceId)
+	}
+	if id := c.Ctx.Input.Param(":id"); id != "" && idField != "" {
+		l = l.With(idField, id)
+	}
+	setRequestLogger(c.Ctx, l)
+
+	c.Ctx.ResponseWriter.ResponseWriter = &loggedWriter{
+		ResponseWriter: c.Ctx.ResponseWriter.ResponseWriter,
+		ctx:            c.Ctx,
+		start:          time.Now(),
+	}
+}
+
+// addLoggerField adds a field to the logger of the request, e.g. the user once authenticated.
+func addLoggerField(ctx *context.Context, key string, value interface{}) {
+	setRequestLogger(ctx, requestLog(ctx).With(key, value))
+}
+
+// logUser adds the authenticated user to the logger of the request.
+func logUser(c *base.APIController) {
+	if c.User != nil {
+		addLoggerField(c.Ctx, logging.FieldUser, c.User.Name)
+	}
+}
+
+func setRequestLogger(ctx *context.Context, l *logging.Logger) {
+	ctx.Request = ctx.Request.WithContext(logging.NewContext(ctx.Request.Context(), l))
+}
+
+// requestLog returns the logger of the request.
+func requestLog(ctx *context.Context) *logging.Logger {
+	return logging.FromContext(ctx.Request.Context())
+}
+
+// writeContext returns the context of the changes of the request, e.g. publishes. They are not canceled
+// when the client disconnects, which would leave a Service applied but its publish status not recorded.
+// Reads keep the request context.
+func writeContext(ctx *context.Context) gocontext.Context {
+	return logging.Detach(ctx.Request.Context())
+}
+
+// recoverBackground recovers the panics of background work started by a request, which would
+// otherwise bring wayne down. It must be deferred; the panic is logged and passed to onPanic if set.
+func recoverBackground(log *logging.Logger, what string, onPanic func(r interface{})) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Error("%s panic: %v\n%s", what, r, debug.Stack())
+	if onPanic != nil {
+		onPanic(r)
+	}
+}
+
+// loggedWriter logs the request once the response header is written, which also covers
+// the requests aborted by the controller.
+type loggedWriter struct {
+	http.ResponseWriter
+	ctx    *context.Context
+	start  time.Time
+	logged bool
+}
+
+func (w *loggedWriter) log(status int) {
+	if w.logged {
+		return
+	}
+	w.logged = true
+	l := requestLog(w.ctx).
+		With(logging.FieldStatus, status).
+		With(logging.FieldLatency, time.Since(w.start).Nanoseconds()/int64(time.Millisecond))
+	if class := logging.StatusClass(status); class != "" {
+		l = l.With(logging.FieldErrorClass, class)
+	}
+	switch {
+	case status >= http.StatusInternalServerError:
+		l.Error("%s %s", w.ctx.Input.Method(), w.ctx.Input.URL())
+	case status >= http.StatusBadRequest:
+		l.Warning("%s %s", w.ctx.Input.Method(), w.ctx.Input.URL())
+	default:
+		l.Info("%s %s", w.ctx.Input.Method(), w.ctx.Input.URL())
+	}
+}
+
+func (w *loggedWriter) WriteHeader(status int) {
+	w.log(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggedWriter) Write(data []byte) (int, error) {
+	w.log(http.StatusOK)
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps server-sent event streams working.
+func (w *loggedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
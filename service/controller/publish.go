@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+// publishOptions controls how a template is published.
+type publishOptions struct {
+	// Strategy is resources.StrategyUpdate or resources.StrategyRecreate, default update.
+	Strategy string `json:"strategy,omitempty"`
+	// Force takes over the fields owned by other field managers instead of failing with a conflict.
+	Force bool `json:"force,omitempty"`
+}
+
+// publishServiceTemplate applies tpl of service to cluster and records the publish status.
+// Changes of immutable fields fail with a *resources.RecreateRequiredError unless the strategy
+// is resources.StrategyRecreate, fields owned by other managers with a *resources.ApplyConflictError.
+// Failures of the API server are returned as *apierror.Error naming the cluster.
+func publishServiceTemplate(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, cluster string, options publishOptions) (err error) {
+	// the publish of a LoadBalancer Service is observed by trackLoadBalancer
+	tracked := false
+	defer func() {
+		err = apierror.FromCluster(cluster, err)
+		if !tracked {
+			metrics.ObservePublish(cluster, err)
+		}
+	}()
+	log := logging.FromContext(ctx).With(logging.FieldCluster, cluster)
+
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return err
+	}
+	kubeService, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return err
+	}
+	if _, err := checkClusterIPFamilies(kubeService, cluster); err != nil {
+		return err
+	}
+
+	// a Service without selector is published together with its hand-managed endpoints,
+	// they are validated before anything is applied
+	var endpoints *resources.EndpointsSpec
+	if len(kubeService.Spec.Selector) == 0 && kubeService.Spec.Type != v1.ServiceTypeExternalName {
+		endpointsTpl, err := svcmodel.ServiceEndpointsTplModel.GetLatest(service.Id)
+		switch {
+		case apierror.IsNotFound(err):
+		case err != nil:
+			return err
+		default:
+			if endpoints, err = endpointsFromTemplate(kubeService, endpointsTpl); err != nil {
+				return err
+			}
+		}
+	}
+
+	publisher, err := resources.ClusterPublisher(cluster)
+	if err != nil {
+		return err
+	}
+	live, err := publisher.Get(ctx, namespace.KubeNamespace, kubeService.Name)
+	if err != nil {
+		return err
+	}
+	changes := resources.ImmutableChanges(live, kubeService)
+	switch {
+	case len(changes) == 0:
+		_, err = publisher.Apply(ctx, kubeService, options.Force)
+	case options.Strategy == resources.StrategyRecreate:
+		log.Warning("recreate service %s in cluster (%s): %v", kubeService.Name, cluster, changes)
+		_, err = resources.RecreateService(ctx, publisher, kubeService, options.Force)
+	default:
+		err = &resources.RecreateRequiredError{Cluster: cluster, Changes: changes}
+	}
+	if err != nil {
+		return err
+	}
+
+	// the Service is applied, its publish status is recorded even if the endpoints fail
+	if endpoints != nil {
+		_, err = applyEndpoints(ctx, kubeService, endpoints, cluster)
+	}
+	statusErr := models.PublishStatusModel.Publish(&models.PublishStatus{
+		ResourceId: service.Id,
+		TemplateId: tpl.Id,
+		Type:       models.PublishTypeService,
+		Cluster:    cluster,
+	})
+	if statusErr != nil {
+		if err != nil {
+			log.Error("record publish status of service (%d) in cluster (%s) error.%v", service.Id, cluster, statusErr)
+			return err
+		}
+		return statusErr
+	}
+	if kubeService.Spec.Type == v1.ServiceTypeLoadBalancer {
+		// a publish whose endpoints failed is already observed as failed
+		tracked = err == nil
+		go trackLoadBalancer(logging.Detach(ctx), service, tpl, cluster, namespace.KubeNamespace, kubeService.Name, tracked)
+	}
+	return err
+}
+
+// publishEndpoints publishes the endpoints template of service to cluster, the Service must be live there.
+// It returns resources.PublishActionCreate if the endpoints did not exist yet.
+func publishEndpoints(ctx context.Context, service *models.Service, tpl *svcmodel.ServiceEndpointsTemplate, cluster string) (string, error) {
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return "", err
+	}
+	serviceTpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(service.Id)
+	if err != nil {
+		return "", err
+	}
+	desired, err := resources.ServiceFromTemplate(serviceTpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return "", err
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return "", err
+	}
+	live, err := resources.GetService(ctx, cli, namespace.KubeNamespace, desired.Name)
+	if err != nil {
+		return "", err
+	}
+	if live == nil {
+		return "", fmt.Errorf("service %s is not published to cluster %s", desired.Name, cluster)
+	}
+	spec, err := endpointsFromTemplate(live, tpl)
+	if err != nil {
+		return "", err
+	}
+	return applyEndpoints(ctx, live, spec, cluster)
+}
+
+// endpointsFromTemplate parses tpl and validates it as the endpoints of kubeService.
+func endpointsFromTemplate(kubeService *v1.Service, tpl *svcmodel.ServiceEndpointsTemplate) (*resources.EndpointsSpec, error) {
+	spec, err := resources.EndpointsSpecFromTemplate(tpl.Template)
+	if err != nil {
+		return nil, err
+	}
+	if errs := resources.ValidateEndpoints(spec, kubeService); len(errs) > 0 {
+		return nil, fmt.Errorf("endpoints template %d is invalid: %s", tpl.Id, strings.Join(errs, "; "))
+	}
+	return spec, nil
+}
+
+// applyEndpoints applies the endpoints of kubeService to cluster.
+func applyEndpoints(ctx context.Context, kubeService *v1.Service, spec *resources.EndpointsSpec, cluster string) (string, error) {
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return "", err
+	}
+	return resources.ApplyEndpoints(ctx, cli, spec, kubeService.Namespace, kubeService.Name)
+}
+
+// clusterWarnings are the problems of a template in one cluster the service is live in.
+type clusterWarnings struct {
+	// Recreate are the immutable fields publishing the template would change.
+	Recreate []resources.ImmutableChange `json:"recreate,omitempty"`
+	// IPFamilies are the IP families the cluster can not serve.
+	IPFamilies []string `json:"ipFamilies,omitempty"`
+}
+
+// clusterWarningsTimeout bounds the requests to one cluster when computing warnings on save.
+var clusterWarningsTimeout = time.Duration(beego.AppConfig.DefaultInt("ServiceClusterWarningsTimeout", 3)) * time.Second
+
+// liveClusterWarnings returns the problems of template per cluster the service is published to.
+// The clusters are checked concurrently, each within clusterWarningsTimeout; clusters which can
+// not be reached in time are skipped.
+func liveClusterWarnings(ctx context.Context, service *models.Service, template string) (map[string]*clusterWarnings, error) {
+	status, err := models.PublishStatusModel.GetAll(models.PublishTypeService, service.Id)
+	if err != nil || len(status) == 0 {
+		return nil, err
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := resources.ServiceFromTemplate(template, namespace.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	warnings := make(map[string]*clusterWarnings)
+	for _, s := range status {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			clusterCtx, cancel := context.WithTimeout(ctx, clusterWarningsTimeout)
+			defer cancel()
+			if w := clusterWarningsOf(clusterCtx, desired, cluster); w != nil {
+				mu.Lock()
+				warnings[cluster] = w
+				mu.Unlock()
+			}
+		}(s.Cluster)
+	}
+	wg.Wait()
+	return warnings, nil
+}
+
+// clusterWarningsOf returns the problems of desired in cluster, nil if there are none or the
+// cluster can not be reached.
+func clusterWarningsOf(ctx context.Context, desired *v1.Service, cluster string) *clusterWarnings {
+	log := logging.FromContext(ctx).With(logging.FieldCluster, cluster)
+	w := &clusterWarnings{}
+	ipWarnings, err := checkClusterIPFamilies(desired, cluster)
+	if err != nil {
+		log.Warning("check IP families of cluster (%s) error.%v", cluster, err)
+		w.IPFamilies = append(w.IPFamilies, errorMessage(err))
+	}
+	w.IPFamilies = append(w.IPFamilies, ipWarnings...)
+
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		log.Warning("get client of cluster (%s) error.%v", cluster, err)
+		return nil
+	}
+	live, err := resources.GetService(ctx, cli, desired.Namespace, desired.Name)
+	if err != nil {
+		log.Warning("get service %s in cluster (%s) error.%v", desired.Name, cluster, err)
+		return nil
+	}
+	w.Recreate = resources.ImmutableChanges(live, desired)
+	if len(w.Recreate) == 0 && len(w.IPFamilies) == 0 {
+		return nil
+	}
+	return w
+}
+
+// checkClusterIPFamilies checks the IP families of kubeService against the service network of
+// the cluster, stored in its metadata. It fails with a validation error naming the cluster if
+// the cluster can not serve them.
+func checkClusterIPFamilies(kubeService *v1.Service, cluster string) ([]string, error) {
+	c, err := models.ClusterModel.GetByName(cluster)
+	if err != nil {
+		return nil, err
+	}
+	families, err := resources.ClusterIPFamilies(c.MetaData)
+	if err != nil {
+		return nil, err
+	}
+	errs, warnings := resources.CheckIPFamilies(kubeService, families)
+	if len(errs) > 0 {
+		metrics.ValidationFailed(metrics.ValidationReasonIPFamily)
+		e := apierror.Validation(fmt.Sprintf("can not serve the IP families: %s", strings.Join(errs, "; ")),
+			apierror.FieldError{Field: "spec.ipFamilies", Message: strings.Join(errs, "; ")})
+		e.Cluster = cluster
+		return warnings, e
+	}
+	return warnings, nil
+}
+
+// previewServiceTemplate computes what publishing tpl of service to cluster with options would change.
+// An update is previewed with a server-side dry-run apply, so the fields kept by Server-Side Apply,
+// e.g. the annotations of other field managers, are not reported as removed.
+func previewServiceTemplate(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, cluster string, options publishOptions) (*resources.PublishPreview, error) {
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+	publisher, err := resources.ClusterPublisher(cluster)
+	if err != nil {
+		return nil, err
+	}
+	live, err := publisher.Get(ctx, namespace.KubeNamespace, desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	var applied *v1.Service
+	var conflicts []resources.ApplyConflict
+	if live != nil && len(resources.ImmutableChanges(live, desired)) == 0 {
+		applied, err = publisher.DryRunApply(ctx, desired, options.Force)
+		if conflictErr, ok := err.(*resources.ApplyConflictError); ok {
+			// preview what publishing with force would do, and tell which fields it takes over
+			conflicts = conflictErr.Conflicts
+			applied, err = publisher.DryRunApply(ctx, desired, true)
+		}
+		if err != nil {
+			return nil, apierror.FromCluster(cluster, err)
+		}
+	}
+	preview, err := resources.Preview(cluster, live, desired, applied)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		preview.Conflicts = conflicts
+		preview.Warnings = append(preview.Warnings, (&resources.ApplyConflictError{Conflicts: conflicts}).Error()+
+			", publishing fails unless forced")
+	}
+	warnings, err := checkClusterIPFamilies(desired, cluster)
+	if err != nil {
+		logging.FromContext(ctx).Warning("check IP families of cluster (%s) error.%v", cluster, err)
+		preview.Error = errorMessage(err)
+	}
+	preview.Warnings = append(preview.Warnings, warnings...)
+	return preview, nil
+}
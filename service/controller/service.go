@@ -5,8 +5,10 @@ import (
 
 	"github.com/Qihoo360/wayne/src/backend/controllers/base"
 	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
 	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
-	"github.com/Qihoo360/wayne/src/backend/util/logs"
 )
 
 type ServiceController struct {
@@ -20,27 +22,45 @@ func (c *ServiceController) URLMapping() {
 	c.Mapping("Get", c.Get)
 	c.Mapping("Update", c.Update)
 	c.Mapping("Delete", c.Delete)
+	c.Mapping("Dependencies", c.Dependencies)
+	c.Mapping("Dependents", c.Dependents)
+	c.Mapping("Clone", c.Clone)
+	c.Mapping("Switch", c.Switch)
+	c.Mapping("Status", c.Status)
+	c.Mapping("CreateExternal", c.CreateExternal)
+	c.Mapping("CreateHeadless", c.CreateHeadless)
+	c.Mapping("Canaries", c.Canaries)
+	c.Mapping("StartCanary", c.StartCanary)
+	c.Mapping("CanaryWeight", c.CanaryWeight)
+	c.Mapping("PromoteCanary", c.PromoteCanary)
+	c.Mapping("AbortCanary", c.AbortCanary)
+	c.Mapping("SuggestNodePorts", c.SuggestNodePorts)
+	c.Mapping("Events", c.Events)
+	c.Mapping("Health", c.Health)
+	c.Mapping("Probe", c.Probe)
 }
 
 func (c *ServiceController) Prepare() {
-	// Check administration
-	c.APIController.Prepare()
-	// Check permission
+	prepareLogger(&c.APIController, logging.FieldServiceId)
 	perAction := ""
 	_, method := c.GetControllerAndAction()
 	switch method {
-	case "Get", "List":
+	case "Get", "List", "Dependencies", "Dependents", "Canaries", "Status",
+		"SuggestNodePorts", "Events", "Health":
 		perAction = models.PermissionRead
-	case "Create":
+	case "Create", "Clone", "CreateExternal", "CreateHeadless":
 		perAction = models.PermissionCreate
-	case "Update":
+	case "Update", "UpdateOrders":
 		perAction = models.PermissionUpdate
 	case "Delete":
 		perAction = models.PermissionDelete
+	case "Probe":
+		perAction = permissionProbe
+	case "Switch", "StartCanary", "CanaryWeight", "PromoteCanary", "AbortCanary":
+		perAction = permissionPublish
 	}
-	if perAction != "" {
-		c.CheckPermission(models.PermissionTypeService, perAction)
-	}
+	metrics.InstrumentRequest(c.Ctx, "ServiceController", method)
+	prepareServiceAccess(&c.APIController, perAction)
 }
 
 // @Title List/
@@ -60,8 +80,8 @@ func (c *ServiceController) GetNames() {
 
 	services, err := svcmodel.ServiceModel.GetNames(filters)
 	if err != nil {
-		logs.Error("get names error. %v, delete-status %v", err, deleted)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("get names error. %v, delete-status %v", err, deleted)
+		abortError(&c.APIController, err)
 		return
 	}
 
@@ -74,6 +94,11 @@ func (c *ServiceController) GetNames() {
 // @Param	pageSize		query 	int	false		"the page size"
 // @Param	name		query 	string	false		"name filter"
 // @Param	deleted		query 	bool	false		"is deleted, default list all"
+// @Param	paging		query 	string	false		"cursor for cursor pagination, default pageNo/pageSize"
+// @Param	cursor		query 	string	false		"cursor returned by the previous page"
+// @Param	limit		query 	int	false		"cursor page size, default 20, max 100"
+// @Param	sort		query 	string	false		"order, id, createTime or updateTime, prefix - for descending, default order"
+// @Param	skipTotal		query 	bool	false		"skip counting the total, cursor pagination only"
 // @Success 200 {object} []models.Service success
 // @router / [get]
 func (c *ServiceController) List() {
@@ -87,23 +112,48 @@ func (c *ServiceController) List() {
 	if c.AppId != 0 {
 		param.Query["App__Id"] = c.AppId
 	} else if !c.User.Admin {
-		param.Query["App__AppUsers__User__Id__exact"] = c.User.Id
-		perName := models.PermissionModel.MergeName(models.PermissionTypeService, models.PermissionRead)
-		param.Query["App__AppUsers__Group__Permissions__Permission__Name__contains"] = perName
-		param.Groupby = []string{"Id"}
+		// resolve the readable apps once instead of joining app users and permissions per row
+		appIds, err := svcmodel.PermittedAppCache.Get(c.User.Id, models.PermissionRead)
+		if err != nil {
+			requestLog(c.Ctx).Error("get readable apps of user (%d) error. %v", c.User.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if len(appIds) == 0 {
+			if cursorPaging(&c.APIController) {
+				c.Success(&svcmodel.CursorPage{List: service})
+				return
+			}
+			c.Success(param.NewPage(0, service))
+			return
+		}
+		param.Query["App__Id__in"] = appIds
+	}
+
+	if cursorPaging(&c.APIController) {
+		cursorQuery := cursorQueryFromInput(&c.APIController,
+			svcmodel.Sort{Key: svcmodel.SortKeyOrder}, svcmodel.ServiceListSortKeys...)
+		page, err := svcmodel.ServiceModel.ListByCursor(param.Query, cursorQuery)
+		if err != nil {
+			requestLog(c.Ctx).Error("list by param (%s) and cursor error. %v", param, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		c.Success(page)
+		return
 	}
 
 	total, err := models.GetTotal(new(models.Service), param)
 	if err != nil {
-		logs.Error("get total count by param (%s) error. %v", param, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("get total count by param (%s) error. %v", param, err)
+		abortError(&c.APIController, err)
 		return
 	}
 
 	err = models.GetAll(new(models.Service), &service, param)
 	if err != nil {
-		logs.Error("list by param (%s) error. %v", param, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("list by param (%s) error. %v", param, err)
+		abortError(&c.APIController, err)
 		return
 	}
 	for key, one := range service {
@@ -122,16 +172,18 @@ func (c *ServiceController) Create() {
 	var service models.Service
 	err := json.Unmarshal(c.Ctx.Input.RequestBody, &service)
 	if err != nil {
-		logs.Error("get body error. %v", err)
-		c.AbortBadRequestFormat("Service")
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Service"))
 	}
 
+	// the app of the url, never the one of the body
+	service.AppId = c.AppId
 	service.User = c.User.Name
 	_, err = svcmodel.ServiceModel.Add(&service)
 
 	if err != nil {
-		logs.Error("create error.%v", err.Error())
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
 		return
 	}
 	c.Success(service)
@@ -143,17 +195,7 @@ func (c *ServiceController) Create() {
 // @Success 200 {object} models.Service success
 // @router /:id([0-9]+) [get]
 func (c *ServiceController) Get() {
-	id := c.GetIDFromURL()
-
-	service, err := svcmodel.ServiceModel.GetById(int64(id))
-	if err != nil {
-		logs.Error("get by id (%d) error.%v", id, err)
-		c.HandleError(err)
-		return
-	}
-
-	c.Success(service)
-	return
+	c.Success(c.serviceOfApp())
 }
 
 // @Title Update
@@ -163,19 +205,20 @@ func (c *ServiceController) Get() {
 // @Success 200 models.Service success
 // @router /:id([0-9]+) [put]
 func (c *ServiceController) Update() {
-	id := c.GetIDFromURL()
+	current := c.serviceOfApp()
 	var service models.Service
 	err := json.Unmarshal(c.Ctx.Input.RequestBody, &service)
 	if err != nil {
-		logs.Error("Invalid param body.%v", err)
-		c.AbortBadRequestFormat("Service")
+		requestLog(c.Ctx).Error("Invalid param body.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Service"))
 	}
 
-	service.Id = int64(id)
+	service.Id = current.Id
+	service.AppId = current.AppId
 	err = svcmodel.ServiceModel.UpdateById(&service)
 	if err != nil {
-		logs.Error("update error.%v", err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
 		return
 	}
 	c.Success(service)
@@ -190,14 +233,17 @@ func (c *ServiceController) UpdateOrders() {
 	var services []*models.Service
 	err := json.Unmarshal(c.Ctx.Input.RequestBody, &services)
 	if err != nil {
-		logs.Error("Invalid param body.%v", err)
-		c.AbortBadRequestFormat("services")
+		requestLog(c.Ctx).Error("Invalid param body.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("services"))
+	}
+	for _, service := range services {
+		appService(&c.APIController, service.Id)
 	}
 
 	err = svcmodel.ServiceModel.UpdateOrders(services)
 	if err != nil {
-		logs.Error("update orders (%v) error.%v", services, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("update orders (%v) error.%v", services, err)
+		abortError(&c.APIController, err)
 		return
 	}
 	c.Success("ok!")
@@ -210,15 +256,71 @@ func (c *ServiceController) UpdateOrders() {
 // @Success 200 {string} delete success!
 // @router /:id([0-9]+) [delete]
 func (c *ServiceController) Delete() {
-	id := c.GetIDFromURL()
+	id := c.serviceOfApp().Id
 
 	logical := c.GetLogicalFromQuery()
 
-	err := svcmodel.ServiceModel.DeleteById(int64(id), logical)
+	err := svcmodel.ServiceModel.DeleteById(id, logical)
 	if err != nil {
-		logs.Error("delete %d error.%v", id, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("delete %d error.%v", id, err)
+		abortError(&c.APIController, err)
 		return
 	}
+	if err := svcmodel.ServiceNodePortModel.Release(id); err != nil {
+		requestLog(c.Ctx).Error("release node ports of service (%d) error.%v", id, err)
+	}
 	c.Success(nil)
 }
+
+// @Title Dependencies
+// @Description get the dependency graph of the Service: the workloads it selects and the configs and ingresses referencing it
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} models.DependencyGraph success
+// @router /:id([0-9]+)/dependencies [get]
+func (c *ServiceController) Dependencies() {
+	id := c.serviceOfApp().Id
+
+	graph, err := svcmodel.ServiceModel.GetDependencies(id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get dependencies of service (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(c.readableGraph(graph))
+}
+
+// @Title Dependents
+// @Description get the configs and ingresses of all apps which depend on the Service
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} models.DependencyGraph success
+// @router /:id([0-9]+)/dependents [get]
+func (c *ServiceController) Dependents() {
+	id := c.serviceOfApp().Id
+
+	graph, err := svcmodel.ServiceModel.GetDependents(id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get dependents of service (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(c.readableGraph(graph))
+}
+
+// readableGraph leaves out of graph the resources of apps the user can not read.
+func (c *ServiceController) readableGraph(graph *svcmodel.DependencyGraph) *svcmodel.DependencyGraph {
+	if c.User.Admin {
+		return graph
+	}
+	if c.User.Id == 0 {
+		// service tokens read their own app only
+		return graph.Readable([]int64{c.AppId})
+	}
+	appIds, err := svcmodel.PermittedAppCache.Get(c.User.Id, models.PermissionRead)
+	if err != nil {
+		requestLog(c.Ctx).Error("get readable apps of user (%d) error.%v", c.User.Id, err)
+		abortError(&c.APIController, err)
+	}
+	return graph.Readable(appIds)
+}
@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+type canaryParam struct {
+	// Selector are the labels of the canary pods, e.g. track=canary.
+	Selector map[string]string `json:"selector,omitempty"`
+	// Weight is the percentage of the ingress traffic routed to the canary.
+	Weight int `json:"weight"`
+	// Clusters default to the clusters the current template is live in.
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+type canaryClusterResult struct {
+	Cluster  string   `json:"cluster"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type canaryResult struct {
+	Canary   *svcmodel.ServiceCanary `json:"canary"`
+	Template *models.ServiceTemplate `json:"template,omitempty"`
+	Clusters []canaryClusterResult   `json:"clusters"`
+}
+
+func (c *ServiceController) canaryParamFromBody() canaryParam {
+	var param canaryParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Canary"))
+	}
+	if param.Weight < 0 || param.Weight > 100 {
+		abortError(&c.APIController, apierror.Validation("Canary weight must be between 0 and 100."))
+	}
+	return param
+}
+
+// serviceOfApp returns the service of the url, which must belong to the app of the url.
+func (c *ServiceController) serviceOfApp() *models.Service {
+	return appService(&c.APIController, int64(c.GetIDFromURL()))
+}
+
+// runningCanary returns the running canary of the service and the template it was generated from.
+func (c *ServiceController) runningCanary(service *models.Service) (*svcmodel.ServiceCanary, *models.ServiceTemplate) {
+	canary, err := svcmodel.ServiceCanaryModel.GetRunning(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Info("get running canary of service (%d) error.%v", service.Id, err)
+		if !apierror.IsNotFound(err) {
+			abortError(&c.APIController, err)
+		}
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Service %s has no running canary.", service.Name)))
+	}
+	tpl, err := svcmodel.ServiceTplModel.GetById(canary.TemplateId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get template (%d) error.%v", canary.TemplateId, err)
+		abortError(&c.APIController, err)
+	}
+	return canary, tpl
+}
+
+// applyCanary publishes the companion Service of the canary and its ingresses in cluster.
+func applyCanary(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, canary *svcmodel.ServiceCanary, cluster string) ([]string, error) {
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, err
+	}
+	base, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+	publisher, err := resources.ClusterPublisher(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := publisher.Apply(ctx, resources.CanaryService(base, canary.SelectorMap), false); err != nil {
+		return nil, err
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return resources.ApplyCanaryIngresses(ctx, cli, namespace.KubeNamespace, base.Name, canary.Weight)
+}
+
+// deleteCanary deletes the companion Service of the canary and its ingresses in cluster.
+func deleteCanary(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, cluster string) error {
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return err
+	}
+	base, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return err
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return err
+	}
+	return resources.DeleteCanary(ctx, cli, namespace.KubeNamespace, base.Name)
+}
+
+// @Title Canaries
+// @Description get the canaries of the Service, newest first
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} []models.ServiceCanary success
+// @router /:id([0-9]+)/canary [get]
+func (c *ServiceController) Canaries() {
+	service := c.serviceOfApp()
+
+	canaries, err := svcmodel.ServiceCanaryModel.GetAll(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get canaries of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(canaries)
+}
+
+// @Title StartCanary
+// @Description start a canary of the Service, publishing a companion Service selecting the canary pods
+// @Param	id		path 	int	true		"the service id"
+// @Param	body		body 	controller.canaryParam	true		"the canary pods, weight and clusters"
+// @Success 200 {object} controller.canaryResult success
+// @router /:id([0-9]+)/canary [post]
+func (c *ServiceController) StartCanary() {
+	param := c.canaryParamFromBody()
+	if len(param.Selector) == 0 {
+		abortError(&c.APIController, apierror.Validation("The selector of the canary pods is required."))
+	}
+	service := c.serviceOfApp()
+	// fails early, Add checks again with the service locked
+	if _, err := svcmodel.ServiceCanaryModel.GetRunning(service.Id); err == nil {
+		abortError(&c.APIController, apierror.Conflict(fmt.Sprintf("Service %s already has a running canary.", service.Name)))
+	}
+
+	tpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if len(param.Clusters) == 0 {
+		param.Clusters, err = liveClusters(service.Id, tpl.Id)
+		if err != nil {
+			requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", service.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if len(param.Clusters) == 0 {
+			abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Template %d is not live in any cluster.", tpl.Id)))
+		}
+	}
+
+	canary := &svcmodel.ServiceCanary{
+		ServiceId:   service.Id,
+		TemplateId:  tpl.Id,
+		SelectorMap: param.Selector,
+		Weight:      param.Weight,
+		ClusterList: param.Clusters,
+		User:        c.User.Name,
+	}
+	if canary.Id, err = svcmodel.ServiceCanaryModel.Add(canary); err != nil {
+		requestLog(c.Ctx).Error("create canary of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	results := make([]canaryClusterResult, 0, len(canary.ClusterList))
+	for _, cluster := range canary.ClusterList {
+		result := canaryClusterResult{Cluster: cluster}
+		result.Warnings, err = applyCanary(writeContext(c.Ctx), service, tpl, canary, cluster)
+		if err != nil {
+			requestLog(c.Ctx).Error("start canary of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	c.Success(canaryResult{Canary: canary, Clusters: results})
+}
+
+// @Title CanaryWeight
+// @Description adjust the percentage of the traffic routed to the running canary
+// @Param	id		path 	int	true		"the service id"
+// @Param	body		body 	controller.canaryParam	true		"the weight"
+// @Success 200 {object} controller.canaryResult success
+// @router /:id([0-9]+)/canary/weight [put]
+func (c *ServiceController) CanaryWeight() {
+	param := c.canaryParamFromBody()
+	service := c.serviceOfApp()
+	canary, tpl := c.runningCanary(service)
+
+	canary.Weight = param.Weight
+	if err := svcmodel.ServiceCanaryModel.UpdateWeight(canary); err != nil {
+		requestLog(c.Ctx).Error("update weight of canary (%d) error.%v", canary.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	results := make([]canaryClusterResult, 0, len(canary.ClusterList))
+	for _, cluster := range canary.ClusterList {
+		var err error
+		result := canaryClusterResult{Cluster: cluster}
+		result.Warnings, err = applyCanary(writeContext(c.Ctx), service, tpl, canary, cluster)
+		if err != nil {
+			requestLog(c.Ctx).Error("update canary of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	c.Success(canaryResult{Canary: canary, Clusters: results})
+}
+
+// @Title PromoteCanary
+// @Description promote the running canary, the Service selects the canary pods and the companion Service is deleted. The canary keeps running unless all clusters succeeded, answering 207 when some clusters failed and the status of the failures when all did.
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} controller.canaryResult success
+// @router /:id([0-9]+)/canary/promote [post]
+func (c *ServiceController) PromoteCanary() {
+	service := c.serviceOfApp()
+	canary, tpl := c.runningCanary(service)
+
+	template, err := resources.SetServiceSelector(tpl.Template, canary.SelectorMap)
+	if err != nil {
+		requestLog(c.Ctx).Error("patch template selector error.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+		return
+	}
+	for _, cluster := range canary.ClusterList {
+		_, err = validServiceTemplate(templateContext{
+			AppId:   service.AppId,
+			User:    c.User,
+			Action:  policy.ActionPublish,
+			Cluster: cluster,
+		}, template)
+		if err != nil {
+			abortInvalidServiceTemplate(&c.APIController, err)
+		}
+	}
+
+	promoted := &models.ServiceTemplate{
+		Name:        tpl.Name,
+		Template:    template,
+		ServiceId:   service.Id,
+		Description: fmt.Sprintf("promote canary %s", labels.Set(canary.SelectorMap).String()),
+		User:        c.User.Name,
+	}
+	nodePorts := checkNodePorts(&c.APIController, service.Id, template)
+	if err = svcmodel.ServiceTplModel.AddClaimingNodePorts(promoted, nodePorts); err != nil {
+		requestLog(c.Ctx).Error("create promoted template error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	// the canary is only removed where the Service took over its pods
+	results := make([]canaryClusterResult, 0, len(canary.ClusterList))
+	failures := []*apierror.Error{}
+	for _, cluster := range canary.ClusterList {
+		result := canaryClusterResult{Cluster: cluster}
+		err := publishServiceTemplate(writeContext(c.Ctx), service, promoted, cluster, publishOptions{})
+		if err == nil {
+			err = deleteCanary(writeContext(c.Ctx), service, tpl, cluster)
+		}
+		if err != nil {
+			requestLog(c.Ctx).Error("promote canary of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+			result.Error = errorMessage(err)
+			failures = append(failures, apiError(err))
+		}
+		results = append(results, result)
+	}
+	if len(failures) > 0 {
+		// the canary keeps running, so that it can be promoted again or aborted
+		c.Ctx.Output.SetStatus(publishStatus(failures, len(results)))
+		c.Success(canaryResult{Canary: canary, Template: promoted, Clusters: results})
+		return
+	}
+	if err := svcmodel.ServiceCanaryModel.Finish(canary, svcmodel.CanaryStatusPromoted); err != nil {
+		requestLog(c.Ctx).Error("finish canary (%d) error.%v", canary.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(canaryResult{Canary: canary, Template: promoted, Clusters: results})
+}
+
+// @Title AbortCanary
+// @Description abort the running canary, the companion Service and canary ingresses are deleted
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} controller.canaryResult success
+// @router /:id([0-9]+)/canary/abort [post]
+func (c *ServiceController) AbortCanary() {
+	service := c.serviceOfApp()
+	canary, tpl := c.runningCanary(service)
+
+	results := make([]canaryClusterResult, 0, len(canary.ClusterList))
+	for _, cluster := range canary.ClusterList {
+		result := canaryClusterResult{Cluster: cluster}
+		if err := deleteCanary(writeContext(c.Ctx), service, tpl, cluster); err != nil {
+			requestLog(c.Ctx).Error("abort canary of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	if err := svcmodel.ServiceCanaryModel.Finish(canary, svcmodel.CanaryStatusAborted); err != nil {
+		requestLog(c.Ctx).Error("finish canary (%d) error.%v", canary.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(canaryResult{Canary: canary, Clusters: results})
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+type cloneServiceParam struct {
+	// AppId is the target app, defaults to the app of the source service.
+	AppId int64  `json:"appId,omitempty"`
+	Name  string `json:"name"`
+	// TemplateIds are the templates to copy, defaults to the latest template.
+	TemplateIds []int64 `json:"templateIds,omitempty"`
+}
+
+// renameServiceTemplate rewrites metadata.name and the labels carrying the old name.
+// The selector is kept, so the copy selects the same pods until it is edited.
+func renameServiceTemplate(tpl string, oldName string, newName string) (string, error) {
+	return resources.PatchServiceTemplate(tpl, func(obj *unstructured.Unstructured) error {
+		obj.SetName(newName)
+		labels := obj.GetLabels()
+		for key, value := range labels {
+			if value == oldName {
+				labels[key] = newName
+			}
+		}
+		if labels != nil {
+			obj.SetLabels(labels)
+		}
+		return nil
+	})
+}
+
+// @Title Clone
+// @Description clone the Service and its templates into another app or under a new name
+// @Param	id		path 	int	true		"the service id"
+// @Param	body		body 	controller.cloneServiceParam	true		"The target app, name and templates"
+// @Success 200 {object} models.Service success
+// @router /:id([0-9]+)/clone [post]
+func (c *ServiceController) Clone() {
+	var param cloneServiceParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("CloneService"))
+	}
+	if errs := validation.IsDNS1035Label(param.Name); len(errs) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid service name %s: %s", param.Name, strings.Join(errs, ","))))
+	}
+
+	source := c.serviceOfApp()
+
+	if param.AppId == 0 {
+		param.AppId = c.AppId
+	}
+	if param.AppId != c.AppId && !c.User.Admin {
+		appIds, err := svcmodel.PermittedAppCache.Get(c.User.Id, models.PermissionCreate)
+		if err != nil {
+			requestLog(c.Ctx).Error("get apps of user (%d) error. %v", c.User.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if !containsId(appIds, param.AppId) {
+			abortError(&c.APIController, apierror.Forbidden("Permission denied on the target app."))
+		}
+	}
+
+	var tpls []*models.ServiceTemplate
+	if len(param.TemplateIds) == 0 {
+		tpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(source.Id)
+		if err != nil {
+			requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", source.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		tpls = append(tpls, tpl)
+	}
+	for _, tplId := range param.TemplateIds {
+		tpl, err := svcmodel.ServiceTplModel.GetById(tplId)
+		if err != nil {
+			requestLog(c.Ctx).Error("get template (%d) error.%v", tplId, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if tpl.ServiceId != source.Id {
+			abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Template %d does not belong to service %s.", tplId, source.Name)))
+		}
+		tpls = append(tpls, tpl)
+	}
+
+	for _, tpl := range tpls {
+		tpl.Template, err = renameServiceTemplate(tpl.Template, source.Name, param.Name)
+		if err != nil {
+			requestLog(c.Ctx).Error("rewrite template (%d) err %v", tpl.Id, err)
+			abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+		}
+		// the node ports stay with the source, the cluster allocates others to the clone
+		tpl.Template, err = resources.ClearNodePorts(tpl.Template)
+		if err != nil {
+			requestLog(c.Ctx).Error("clear node ports of template (%d) err %v", tpl.Id, err)
+			abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+		}
+		_, err = validServiceTemplate(templateContext{
+			AppId:  param.AppId,
+			User:   c.User,
+			Action: policy.ActionCreate,
+		}, tpl.Template)
+		if err != nil {
+			abortInvalidServiceTemplate(&c.APIController, err)
+		}
+		tpl.User = c.User.Name
+		tpl.Description = fmt.Sprintf("cloned from %s template %d. %s", source.Name, tpl.Id, tpl.Description)
+	}
+
+	target := &models.Service{
+		Name:        param.Name,
+		MetaData:    source.MetaData,
+		Description: source.Description,
+		User:        c.User.Name,
+		AppId:       param.AppId,
+	}
+	err = svcmodel.ServiceModel.AddWithTemplates(target, tpls)
+	if err != nil {
+		requestLog(c.Ctx).Error("clone service (%d) to app (%d) as %s error.%v", source.Id, param.AppId, param.Name, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(target)
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+// 无 selector 服务的手动 Endpoints 模版
+type ServiceEndpointsController struct {
+	base.APIController
+
+	service *models.Service
+}
+
+func (c *ServiceEndpointsController) URLMapping() {
+	c.Mapping("List", c.List)
+	c.Mapping("Create", c.Create)
+	c.Mapping("Get", c.Get)
+	c.Mapping("Update", c.Update)
+	c.Mapping("Delete", c.Delete)
+	c.Mapping("Publish", c.Publish)
+}
+
+func (c *ServiceEndpointsController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	perAction := ""
+	_, method := c.GetControllerAndAction()
+	switch method {
+	case "Get", "List":
+		perAction = models.PermissionRead
+	case "Create":
+		perAction = models.PermissionCreate
+	case "Update":
+		perAction = models.PermissionUpdate
+	case "Delete":
+		perAction = models.PermissionDelete
+	case "Publish":
+		perAction = permissionPublish
+	}
+	prepareServiceAccess(&c.APIController, perAction)
+
+	serviceId, err := strconv.ParseInt(c.Ctx.Input.Param(":serviceid"), 10, 64)
+	if err != nil {
+		abortError(&c.APIController, apierror.Validation("Invalid service id in URL"))
+	}
+	c.service, err = svcmodel.ServiceModel.GetById(serviceId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get service (%d) error.%v", serviceId, err)
+		abortError(&c.APIController, err)
+	}
+	if c.service.AppId != c.AppId {
+		abortError(&c.APIController, apierror.Forbidden("Service does not belong to this app."))
+	}
+}
+
+// endpointsTplFromBody parses the body and validates the endpoints against the latest template of the service.
+func (c *ServiceEndpointsController) endpointsTplFromBody() svcmodel.ServiceEndpointsTemplate {
+	var tpl svcmodel.ServiceEndpointsTemplate
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &tpl)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceEndpointsTemplate"))
+	}
+	spec, err := resources.EndpointsSpecFromTemplate(tpl.Template)
+	if err != nil {
+		requestLog(c.Ctx).Error("valid template err %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("EndpointsSpec"))
+	}
+
+	serviceTpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(c.service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", c.service.Id, err)
+		abortError(&c.APIController, err)
+	}
+	kubeService, err := resources.ServiceFromTemplate(serviceTpl.Template, "")
+	if err != nil {
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+	}
+	if errs := resources.ValidateEndpoints(spec, kubeService); len(errs) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid endpoints: %s", strings.Join(errs, "; "))))
+	}
+
+	tpl.ServiceId = c.service.Id
+	tpl.User = c.User.Name
+	return tpl
+}
+
+// @Title GetAll
+// @Description get the endpoints templates of the Service, newest first
+// @Success 200 {object} []models.ServiceEndpointsTemplate success
+// @router / [get]
+func (c *ServiceEndpointsController) List() {
+	tpls, err := svcmodel.ServiceEndpointsTplModel.GetAll(c.service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("list endpoints templates of service (%d) error. %v", c.service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(tpls)
+}
+
+// @Title Create
+// @Description create an endpoints template
+// @Param	body		body 	models.ServiceEndpointsTemplate	true		"The ServiceEndpointsTemplate content"
+// @Success 200 return models.ServiceEndpointsTemplate success
+// @router / [post]
+func (c *ServiceEndpointsController) Create() {
+	tpl := c.endpointsTplFromBody()
+
+	_, err := svcmodel.ServiceEndpointsTplModel.Add(&tpl)
+	if err != nil {
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(tpl)
+}
+
+// @Title Get
+// @Description find the endpoints template by id
+// @Param	id		path 	int	true		"the id you want to get"
+// @Success 200 {object} models.ServiceEndpointsTemplate success
+// @router /:id([0-9]+) [get]
+func (c *ServiceEndpointsController) Get() {
+	tpl := c.endpointsTplOfService()
+
+	c.Success(tpl)
+}
+
+// @Title Update
+// @Description update the endpoints template
+// @Param	id		path 	int	true		"The id you want to update"
+// @Param	body		body 	models.ServiceEndpointsTemplate	true		"The body"
+// @Success 200 models.ServiceEndpointsTemplate success
+// @router /:id([0-9]+) [put]
+func (c *ServiceEndpointsController) Update() {
+	current := c.endpointsTplOfService()
+	tpl := c.endpointsTplFromBody()
+
+	tpl.Id = current.Id
+	err := svcmodel.ServiceEndpointsTplModel.UpdateById(&tpl)
+	if err != nil {
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(tpl)
+}
+
+// @Title Delete
+// @Description delete the endpoints template, the published endpoints are kept
+// @Param	id		path 	int	true		"The id you want to delete"
+// @Success 200 {string} delete success!
+// @router /:id([0-9]+) [delete]
+func (c *ServiceEndpointsController) Delete() {
+	tpl := c.endpointsTplOfService()
+
+	err := svcmodel.ServiceEndpointsTplModel.DeleteById(tpl.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("delete %d error.%v", tpl.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(nil)
+}
+
+// @Title Publish
+// @Description publish the endpoints template to clusters the Service is live in
+// @Param	id		path 	int	true		"the endpoints template id"
+// @Param	body		body 	controller.publishParam	true		"the clusters to publish to"
+// @Success 200 {object} []resources.PublishPreview success
+// @router /:id([0-9]+)/publish [post]
+func (c *ServiceEndpointsController) Publish() {
+	tpl := c.endpointsTplOfService()
+	var param publishParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil || len(param.Clusters) == 0 {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Publish"))
+	}
+
+	results := make([]*resources.PublishPreview, 0, len(param.Clusters))
+	for _, cluster := range param.Clusters {
+		result := &resources.PublishPreview{Cluster: cluster}
+		action, err := publishEndpoints(writeContext(c.Ctx), c.service, tpl, cluster)
+		result.Action = action
+		if err != nil {
+			requestLog(c.Ctx).Error("publish endpoints template (%d) to cluster (%s) error.%v", tpl.Id, cluster, err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	c.Success(results)
+}
+
+func (c *ServiceEndpointsController) endpointsTplOfService() *svcmodel.ServiceEndpointsTemplate {
+	id := c.GetIDFromURL()
+	tpl, err := svcmodel.ServiceEndpointsTplModel.GetById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get endpoints template (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+	}
+	if tpl.ServiceId != c.service.Id {
+		abortError(&c.APIController, apierror.Forbidden("Endpoints template does not belong to this service."))
+	}
+	return tpl
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+// 服务模版晋级流水线的环境配置，仅管理员可操作
+type ServiceEnvironmentController struct {
+	base.APIController
+}
+
+func (c *ServiceEnvironmentController) URLMapping() {
+	c.Mapping("List", c.List)
+	c.Mapping("Create", c.Create)
+	c.Mapping("Update", c.Update)
+	c.Mapping("Delete", c.Delete)
+}
+
+func (c *ServiceEnvironmentController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+
+	if !c.User.Admin {
+		abortError(&c.APIController, apierror.Forbidden("operation need admin permission."))
+	}
+}
+
+func validServiceEnvironment(env *svcmodel.ServiceEnvironment) bool {
+	if env.Pipeline == "" || env.Name == "" || env.Cluster == "" || env.AppId == 0 {
+		return false
+	}
+	if env.Overrides != "" {
+		// the overrides must be a valid merge patch
+		if _, err := jsonpatch.MergePatch([]byte("{}"), hack.Slice(env.Overrides)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// @Title GetAll
+// @Description get all promotion environments
+// @Success 200 {object} []models.ServiceEnvironment success
+// @router / [get]
+func (c *ServiceEnvironmentController) List() {
+	envs, err := svcmodel.ServiceEnvironmentModel.GetAll()
+	if err != nil {
+		requestLog(c.Ctx).Error("list service environments error. %v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(envs)
+}
+
+// @Title Create
+// @Description create a promotion environment
+// @Param	body		body 	models.ServiceEnvironment	true		"The ServiceEnvironment content"
+// @Success 200 return models.ServiceEnvironment success
+// @router / [post]
+func (c *ServiceEnvironmentController) Create() {
+	var env svcmodel.ServiceEnvironment
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &env)
+	if err != nil || !validServiceEnvironment(&env) {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceEnvironment"))
+	}
+
+	env.User = c.User.Name
+	_, err = svcmodel.ServiceEnvironmentModel.Add(&env)
+	if err != nil {
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(env)
+}
+
+// @Title Update
+// @Description update the promotion environment
+// @Param	id		path 	int	true		"The id you want to update"
+// @Param	body		body 	models.ServiceEnvironment	true		"The body"
+// @Success 200 models.ServiceEnvironment success
+// @router /:id([0-9]+) [put]
+func (c *ServiceEnvironmentController) Update() {
+	id := c.GetIDFromURL()
+	var env svcmodel.ServiceEnvironment
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &env)
+	if err != nil || !validServiceEnvironment(&env) {
+		requestLog(c.Ctx).Error("Invalid param body.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceEnvironment"))
+	}
+
+	env.Id = int64(id)
+	env.User = c.User.Name
+	err = svcmodel.ServiceEnvironmentModel.UpdateById(&env)
+	if err != nil {
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(env)
+}
+
+// @Title Delete
+// @Description delete the promotion environment
+// @Param	id		path 	int	true		"The id you want to delete"
+// @Success 200 {string} delete success!
+// @router /:id([0-9]+) [delete]
+func (c *ServiceEnvironmentController) Delete() {
+	id := c.GetIDFromURL()
+
+	err := svcmodel.ServiceEnvironmentModel.DeleteById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("delete %d error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(nil)
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+// eventStreamHeartbeat keeps idle event streams open through proxies.
+const eventStreamHeartbeat = 30 * time.Second
+
+// serviceWatchers returns the watchers of the clusters the service is published to, skipping the
+// clusters without client.
+func serviceWatchers(ctx context.Context, service *models.Service) (map[string]resources.Watcher, error) {
+	status, err := models.PublishStatusModel.GetAll(models.PublishTypeService, service.Id)
+	if err != nil {
+		return nil, err
+	}
+	watchers := make(map[string]resources.Watcher, len(status))
+	for _, s := range status {
+		cli, err := resources.Client(s.Cluster)
+		if err != nil {
+			logging.FromContext(ctx).Warning("get client of cluster (%s) error.%v", s.Cluster, err)
+			continue
+		}
+		watchers[s.Cluster] = resources.NewWatcher(cli)
+	}
+	return watchers, nil
+}
+
+// @Title Events
+// @Description stream the Kubernetes Events and Endpoints changes of the Service in all clusters it is published to as server-sent events, reconnecting with Last-Event-ID resumes the stream
+// @Param	id		path 	int	true		"the service id"
+// @Param	lastEventId		query 	string	false		"resume after this event, for clients which can not set the Last-Event-ID header"
+// @Success 200 {object} resources.ServiceEvent success
+// @router /:id([0-9]+)/events [get]
+func (c *ServiceController) Events() {
+	service := c.serviceOfApp()
+
+	tpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get namespace of app (%d) error.%v", service.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	kubeService, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+	}
+	watchers, err := serviceWatchers(c.Ctx.Request.Context(), service)
+	if err != nil {
+		requestLog(c.Ctx).Error("get clusters of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	flusher, ok := c.Ctx.ResponseWriter.ResponseWriter.(http.Flusher)
+	if !ok {
+		abortError(&c.APIController, apierror.Internal("Streaming is not supported."))
+	}
+	lastEventId := c.Ctx.Input.Header("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Input().Get("lastEventId")
+	}
+
+	header := c.Ctx.ResponseWriter.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Ctx.ResponseWriter.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	c.EnableRender = false
+
+	ctx, cancel := context.WithCancel(c.Ctx.Request.Context())
+	defer cancel()
+	stream := resources.NewServiceEventStream(namespace.KubeNamespace, kubeService.Name, watchers,
+		resources.ParseStreamCursor(lastEventId))
+	events := stream.Run(ctx)
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = writeServerSentEvent(c.Ctx.ResponseWriter, event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(c.Ctx.ResponseWriter, ": heartbeat\n\n")
+		}
+		if err != nil {
+			requestLog(c.Ctx).Info("stream events of service (%d) closed.%v", service.Id, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event *resources.ServiceEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor, event.Kind, data)
+	return err
+}
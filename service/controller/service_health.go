@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+const (
+	defaultHealthWindow       = 24 * time.Hour
+	defaultZeroReadyThreshold = 60
+)
+
+// healthHistory is the endpoint health of a Service in a cluster over a time window.
+type healthHistory struct {
+	Cluster string                          `json:"cluster"`
+	Samples []*svcmodel.ServiceHealthSample `json:"samples"`
+	// LongestZeroReadySeconds is the longest stretch of the window without ready endpoints.
+	LongestZeroReadySeconds int64 `json:"longestZeroReadySeconds"`
+	// ZeroReady is set when LongestZeroReadySeconds exceeds the zeroReadyFor threshold.
+	ZeroReady bool `json:"zeroReady"`
+}
+
+func healthSampleInterval() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("ServiceHealthSampleInterval", 60)) * time.Second
+}
+
+// healthSampleTimeout bounds each call sampling the endpoints of a Service.
+func healthSampleTimeout() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("ServiceHealthSampleTimeout", 10)) * time.Second
+}
+
+func healthRetention() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("ServiceHealthRetentionHours", 168)) * time.Hour
+}
+
+// StartHealthSampler samples the endpoints of all published Services every ServiceHealthSampleInterval
+// seconds. It is an app start hook, the database is not ready before. Samples of several replicas
+// would interleave, sampling is opt-in and only the replica with ServiceHealthSampler enabled samples.
+func StartHealthSampler() error {
+	if !beego.AppConfig.DefaultBool("ServiceHealthSampler", false) {
+		return nil
+	}
+	go wait.Forever(sampleServiceHealth, healthSampleInterval())
+	return nil
+}
+
+// sampleServiceHealth samples all Services, each round logs and calls the API servers with a request id of its own.
+// Clusters are sampled concurrently and each within the interval, so that a slow cluster does not leave
+// gaps in the samples of the others.
+func sampleServiceHealth() {
+	log := logging.New(logging.FieldRequestId, logging.NewRequestId(), logging.FieldAction, "HealthSampler")
+	defer recoverBackground(log, "sample service health", nil)
+	ctx := logging.NewContext(context.Background(), log)
+	status, err := svcmodel.ServiceHealthModel.GetPublished()
+	if err != nil {
+		log.Error("get published services error.%v", err)
+		return
+	}
+	now := time.Now().Truncate(time.Second)
+	interval := healthSampleInterval()
+	// a sample missed in between breaks the stretch of equal counts
+	maxGap := 2 * interval
+	byCluster := make(map[string][]models.PublishStatus)
+	for _, s := range status {
+		byCluster[s.Cluster] = append(byCluster[s.Cluster], s)
+	}
+	var wg sync.WaitGroup
+	for cluster, status := range byCluster {
+		wg.Add(1)
+		go func(cluster string, status []models.PublishStatus) {
+			defer wg.Done()
+			clusterLog := log.With(logging.FieldCluster, cluster)
+			defer recoverBackground(clusterLog, "sample service health", nil)
+			clusterCtx, cancel := context.WithTimeout(logging.NewContext(ctx, clusterLog), interval)
+			defer cancel()
+			sampleCluster(clusterCtx, status, now, maxGap)
+		}(cluster, status)
+	}
+	wg.Wait()
+
+	if _, err := svcmodel.ServiceHealthModel.DeleteBefore(now.Add(-healthRetention())); err != nil {
+		log.Error("delete expired health samples error.%v", err)
+	}
+}
+
+// sampleCluster samples the Services published to a cluster until ctx is done.
+func sampleCluster(ctx context.Context, status []models.PublishStatus, at time.Time, maxGap time.Duration) {
+	log := logging.FromContext(ctx)
+	namespaces := newAppNamespaces()
+	for i, s := range status {
+		if ctx.Err() != nil {
+			log.Warning("sampling cluster (%s) did not finish within the interval, %d services left", s.Cluster, len(status)-i)
+			return
+		}
+		if err := sampleEndpoints(ctx, s, namespaces, at, maxGap); err != nil {
+			log.Warning("sample endpoints of service (%d) in cluster (%s) error.%v", s.ResourceId, s.Cluster, err)
+		}
+	}
+}
+
+func sampleEndpoints(ctx context.Context, s models.PublishStatus, namespaces *appNamespaces, at time.Time, maxGap time.Duration) error {
+	service, err := svcmodel.ServiceModel.GetById(s.ResourceId)
+	if err != nil {
+		return err
+	}
+	if service.Deleted {
+		return nil
+	}
+	namespace, err := namespaces.get(service.AppId)
+	if err != nil {
+		return err
+	}
+	tpl, err := svcmodel.ServiceTplModel.GetById(s.TemplateId)
+	if err != nil {
+		return err
+	}
+	kubeService, err := resources.ServiceFromTemplate(tpl.Template, namespace)
+	if err != nil {
+		return err
+	}
+	cli, err := resources.Client(s.Cluster)
+	if err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, healthSampleTimeout())
+	defer cancel()
+	ready, notReady, err := resources.EndpointCounts(callCtx, cli, namespace, kubeService.Name)
+	if err != nil {
+		return err
+	}
+	return svcmodel.ServiceHealthModel.Record(service.Id, s.Cluster, ready, notReady, at, maxGap)
+}
+
+// longestZeroReady returns the longest stretch of [from, to] covered by samples without ready endpoints,
+// consecutive samples closer than maxGap form one stretch. samples are ordered by time.
+func longestZeroReady(samples []*svcmodel.ServiceHealthSample, from time.Time, to time.Time, maxGap time.Duration) time.Duration {
+	var longest time.Duration
+	var start, end *time.Time
+	for _, sample := range samples {
+		if sample.Ready > 0 {
+			start, end = nil, nil
+			continue
+		}
+		sampleStart, sampleEnd := *sample.StartTime, *sample.EndTime
+		if sampleStart.Before(from) {
+			sampleStart = from
+		}
+		if sampleEnd.After(to) {
+			sampleEnd = to
+		}
+		if end == nil || sampleStart.Sub(*end) > maxGap {
+			start = &sampleStart
+		}
+		end = &sampleEnd
+		if d := end.Sub(*start); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// @Title Health
+// @Description get the sampled ready and not ready endpoint counts of the Service per cluster over a time window, flagging the clusters which had no ready endpoints for longer than zeroReadyFor
+// @Param	id		path 	int	true		"the service id"
+// @Param	cluster		query 	string	false		"cluster filter"
+// @Param	from		query 	string	false		"RFC3339 start of the window, default 24h before to"
+// @Param	to		query 	string	false		"RFC3339 end of the window, default now"
+// @Param	zeroReadyFor		query 	int	false		"seconds without ready endpoints flagging a cluster, default 60"
+// @Success 200 {object} []controller.healthHistory success
+// @router /:id([0-9]+)/health [get]
+func (c *ServiceController) Health() {
+	service := c.serviceOfApp()
+
+	to := time.Now()
+	if t := timeFromQuery(&c.APIController, "to"); t != nil {
+		to = *t
+	}
+	from := to.Add(-defaultHealthWindow)
+	if t := timeFromQuery(&c.APIController, "from"); t != nil {
+		from = *t
+	}
+	if !from.Before(to) {
+		abortError(&c.APIController, apierror.Validation("from must be before to."))
+	}
+	zeroReadyFor, err := c.GetInt64("zeroReadyFor", defaultZeroReadyThreshold)
+	if err != nil || zeroReadyFor < 0 {
+		abortError(&c.APIController, apierror.InvalidParam("zeroReadyFor"))
+	}
+
+	samples, err := svcmodel.ServiceHealthModel.GetRange(service.Id, c.Input().Get("cluster"), from, to)
+	if err != nil {
+		requestLog(c.Ctx).Error("get health samples of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	maxGap := 2 * healthSampleInterval()
+	result := []*healthHistory{}
+	var history *healthHistory
+	for _, sample := range samples {
+		if history == nil || history.Cluster != sample.Cluster {
+			history = &healthHistory{Cluster: sample.Cluster}
+			result = append(result, history)
+		}
+		history.Samples = append(history.Samples, sample)
+	}
+	for _, history := range result {
+		longest := longestZeroReady(history.Samples, from, to, maxGap)
+		history.LongestZeroReadySeconds = int64(longest.Seconds())
+		history.ZeroReady = history.LongestZeroReadySeconds > zeroReadyFor
+	}
+	c.Success(result)
+}
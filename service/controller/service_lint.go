@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+)
+
+// 服务模版校验规则集，仅管理员可操作
+type ServiceLintRuleSetController struct {
+	base.APIController
+}
+
+func (c *ServiceLintRuleSetController) URLMapping() {
+	c.Mapping("Rules", c.Rules)
+	c.Mapping("List", c.List)
+	c.Mapping("Create", c.Create)
+	c.Mapping("Update", c.Update)
+	c.Mapping("Delete", c.Delete)
+}
+
+func (c *ServiceLintRuleSetController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+
+	if !c.User.Admin {
+		abortError(&c.APIController, apierror.Forbidden("operation need admin permission."))
+	}
+}
+
+func (c *ServiceLintRuleSetController) ruleSetFromBody() svcmodel.ServiceLintRuleSet {
+	var set svcmodel.ServiceLintRuleSet
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &set)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceLintRuleSet"))
+	}
+	if set.AppId != 0 && set.NamespaceId != 0 {
+		abortError(&c.APIController, apierror.Validation("A rule set applies either to an app or to a namespace."))
+	}
+	for _, rule := range set.RuleList {
+		if err := rule.Validate(); err != nil {
+			abortError(&c.APIController, apierror.Validation(err.Error()))
+		}
+	}
+	return set
+}
+
+// @Title Rules
+// @Description get the names of the available lint rules
+// @Success 200 {object} []string success
+// @router /rules [get]
+func (c *ServiceLintRuleSetController) Rules() {
+	c.Success(lint.RuleNames())
+}
+
+// @Title GetAll
+// @Description get all lint rule sets
+// @Success 200 {object} []models.ServiceLintRuleSet success
+// @router / [get]
+func (c *ServiceLintRuleSetController) List() {
+	sets, err := svcmodel.ServiceLintRuleSetModel.GetAll()
+	if err != nil {
+		requestLog(c.Ctx).Error("list lint rule sets error. %v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(sets)
+}
+
+// @Title Create
+// @Description create a lint rule set
+// @Param	body		body 	models.ServiceLintRuleSet	true		"The ServiceLintRuleSet content"
+// @Success 200 return models.ServiceLintRuleSet success
+// @router / [post]
+func (c *ServiceLintRuleSetController) Create() {
+	set := c.ruleSetFromBody()
+
+	set.User = c.User.Name
+	_, err := svcmodel.ServiceLintRuleSetModel.Add(&set)
+	if err != nil {
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(set)
+}
+
+// @Title Update
+// @Description update the lint rule set
+// @Param	id		path 	int	true		"The id you want to update"
+// @Param	body		body 	models.ServiceLintRuleSet	true		"The body"
+// @Success 200 models.ServiceLintRuleSet success
+// @router /:id([0-9]+) [put]
+func (c *ServiceLintRuleSetController) Update() {
+	id := c.GetIDFromURL()
+	set := c.ruleSetFromBody()
+
+	set.Id = int64(id)
+	set.User = c.User.Name
+	err := svcmodel.ServiceLintRuleSetModel.UpdateById(&set)
+	if err != nil {
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(set)
+}
+
+// @Title Delete
+// @Description delete the lint rule set
+// @Param	id		path 	int	true		"The id you want to delete"
+// @Success 200 {string} delete success!
+// @router /:id([0-9]+) [delete]
+func (c *ServiceLintRuleSetController) Delete() {
+	id := c.GetIDFromURL()
+
+	err := svcmodel.ServiceLintRuleSetModel.DeleteById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("delete %d error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(nil)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+// loadBalancerTimeout is how long the cloud provider has to provision the load balancer of a
+// published Service before the publish is marked failed.
+func loadBalancerTimeout() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("ServiceLoadBalancerTimeout", 600)) * time.Second
+}
+
+// trackLoadBalancer records the provisioning of the load balancer of the Service published from tpl
+// to cluster, failing it when no ingress shows up within loadBalancerTimeout. With observe, the outcome
+// of the publish is observed once the load balancer is provisioned or failed.
+func trackLoadBalancer(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, cluster string, namespace string, name string, observe bool) {
+	log := logging.FromContext(ctx).With(logging.FieldCluster, cluster)
+	observePublish := func(err error) {
+		if observe {
+			metrics.ObservePublish(cluster, err)
+		}
+	}
+	var lb *svcmodel.ServiceLoadBalancer
+	defer recoverBackground(log, "track load balancer", func(r interface{}) {
+		message := fmt.Sprintf("tracking failed: %v", r)
+		observePublish(errors.New(message))
+		if lb == nil {
+			return
+		}
+		if err := svcmodel.ServiceLoadBalancerModel.Fail(lb, message); err != nil {
+			log.Error("fail load balancer of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+		}
+	})
+
+	lb, err := svcmodel.ServiceLoadBalancerModel.Start(service.Id, tpl.Id, cluster)
+	if err != nil {
+		log.Error("start tracking load balancer of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+		observePublish(nil)
+		return
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		log.Error("get client of cluster (%s) error.%v", cluster, err)
+		observePublish(nil)
+		return
+	}
+
+	timeout := loadBalancerTimeout()
+	ingress := resources.WatchLoadBalancer(ctx, cli, namespace, name, timeout, func(message string) {
+		if err := svcmodel.ServiceLoadBalancerModel.UpdateMessage(lb, message); err != nil {
+			log.Error("update load balancer of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+		}
+	})
+	if len(ingress) > 0 {
+		observePublish(nil)
+		err = svcmodel.ServiceLoadBalancerModel.Provisioned(lb, ingress)
+	} else {
+		message := fmt.Sprintf("load balancer not provisioned within %s", timeout)
+		if lb.Message != "" {
+			message = fmt.Sprintf("%s, last warning %s", message, lb.Message)
+		}
+		log.Warning("service %s in cluster (%s): %s", name, cluster, message)
+		observePublish(errors.New(message))
+		err = svcmodel.ServiceLoadBalancerModel.Fail(lb, message)
+	}
+	if err != nil {
+		log.Error("finish tracking load balancer of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+	}
+}
+
+// StartLoadBalancerSweep fails, every minute, the load balancers pending for longer than
+// loadBalancerTimeout, whose tracking was interrupted by a restart. It is an app start hook.
+func StartLoadBalancerSweep() error {
+	go wait.Forever(sweepLoadBalancers, time.Minute)
+	return nil
+}
+
+func sweepLoadBalancers() {
+	log := logging.New(logging.FieldRequestId, logging.NewRequestId(), logging.FieldAction, "LoadBalancerSweep")
+	defer recoverBackground(log, "sweep load balancers", nil)
+	timeout := loadBalancerTimeout()
+	// trackers fail their own load balancer at the timeout, the margin leaves them the time to do so
+	deadline := time.Now().Add(-timeout - time.Minute)
+	failed, err := svcmodel.ServiceLoadBalancerModel.FailStale(deadline, fmt.Sprintf("load balancer not provisioned within %s, tracking was interrupted", timeout))
+	if err != nil {
+		log.Error("fail stale load balancers error.%v", err)
+		return
+	}
+	if failed > 0 {
+		log.Warning("failed %d load balancers whose tracking was interrupted", failed)
+	}
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+)
+
+// 服务插件的 Prometheus 指标，仅管理员可查看
+type ServiceMetricsController struct {
+	base.APIController
+}
+
+func (c *ServiceMetricsController) URLMapping() {
+	c.Mapping("Get", c.Get)
+}
+
+func (c *ServiceMetricsController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+
+	if !c.User.Admin {
+		abortError(&c.APIController, apierror.Forbidden("operation need admin permission."))
+	}
+}
+
+// @Title Get
+// @Description get the Prometheus metrics of the service plugin in the text exposition format
+// @Success 200 {string} metrics
+// @router / [get]
+func (c *ServiceMetricsController) Get() {
+	c.EnableRender = false
+	metrics.Handler().ServeHTTP(c.Ctx.ResponseWriter, c.Ctx.Request)
+}
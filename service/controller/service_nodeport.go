@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+const maxSuggestNodePorts = 20
+
+// 服务 NodePort 登记，仅管理员可查看和对账
+type ServiceNodePortController struct {
+	base.APIController
+}
+
+type nodePortReconcileResult struct {
+	// Cluster is empty for the claims of templates saved before the ports were registered.
+	Cluster string `json:"cluster"`
+	// Recorded is the number of live node ports not claimed by the template of their Service,
+	// or of the ports claimed by the backfill.
+	Recorded  int      `json:"recorded"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func (c *ServiceNodePortController) URLMapping() {
+	c.Mapping("List", c.List)
+	c.Mapping("Reconcile", c.Reconcile)
+}
+
+func (c *ServiceNodePortController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+
+	if !c.User.Admin {
+		abortError(&c.APIController, apierror.Forbidden("operation need admin permission."))
+	}
+}
+
+// @Title GetAll
+// @Description get the registered node ports
+// @Param	cluster		query 	string	false		"only the ports held in this cluster, template claims are always listed"
+// @Param	port		query 	int	false		"port filter"
+// @Success 200 {object} []models.ServiceNodePort success
+// @router / [get]
+func (c *ServiceNodePortController) List() {
+	filters := map[string]interface{}{}
+	if port := c.Input().Get("port"); port != "" {
+		filters["Port"] = port
+	}
+	if cluster := c.Input().Get("cluster"); cluster != "" {
+		filters["Cluster__in"] = []string{"", cluster}
+	}
+
+	ports, err := svcmodel.ServiceNodePortModel.GetAll(filters)
+	if err != nil {
+		requestLog(c.Ctx).Error("list node ports by filters (%v) error. %v", filters, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(ports)
+}
+
+// @Title Reconcile
+// @Description claim the node ports of templates saved before the registry, record the node ports allocated in the clusters and report the ones held by another Service than the template claiming them
+// @Param	cluster		query 	string	false		"only reconcile this cluster, default all"
+// @Success 200 {object} []controller.nodePortReconcileResult success
+// @router /reconcile [post]
+func (c *ServiceNodePortController) Reconcile() {
+	clusters := []string{}
+	if cluster := c.Input().Get("cluster"); cluster != "" {
+		clusters = append(clusters, cluster)
+	} else {
+		all, err := models.ClusterModel.GetNames(false)
+		if err != nil {
+			requestLog(c.Ctx).Error("get clusters error.%v", err)
+			abortError(&c.APIController, err)
+			return
+		}
+		for _, cluster := range all {
+			clusters = append(clusters, cluster.Name)
+		}
+	}
+
+	// templates saved before the ports were registered claim them first
+	backfill, err := backfillNodePortClaims(writeContext(c.Ctx))
+	if err != nil {
+		requestLog(c.Ctx).Error("backfill node port claims error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	claims, err := svcmodel.ServiceNodePortModel.GetAll(map[string]interface{}{"Cluster": ""})
+	if err != nil {
+		requestLog(c.Ctx).Error("list node port claims error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	claimsByPort := make(map[int32][]*svcmodel.ServiceNodePort)
+	for _, claim := range claims {
+		claimsByPort[claim.Port] = append(claimsByPort[claim.Port], claim)
+	}
+	namespaces := newAppNamespaces()
+
+	results := make([]*nodePortReconcileResult, 0, len(clusters)+1)
+	results = append(results, backfill)
+	for _, cluster := range clusters {
+		result, err := reconcileNodePorts(writeContext(c.Ctx), cluster, claimsByPort, namespaces)
+		if err != nil {
+			requestLog(c.Ctx).Error("reconcile node ports of cluster (%s) error.%v", cluster, err)
+			result.Error = errorMessage(err)
+		}
+		results = append(results, result)
+	}
+	c.Success(results)
+}
+
+// reconcileNodePorts replaces the live node ports recorded for cluster with the ones allocated there,
+// leaving out the ones of Services whose template claims them.
+func reconcileNodePorts(ctx context.Context, cluster string, claimsByPort map[int32][]*svcmodel.ServiceNodePort,
+	namespaces *appNamespaces) (*nodePortReconcileResult, error) {
+	result := &nodePortReconcileResult{Cluster: cluster}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return result, err
+	}
+	live, err := resources.ListNodePorts(ctx, cli)
+	if err != nil {
+		return result, err
+	}
+
+	recorded := []*svcmodel.ServiceNodePort{}
+	for _, port := range live {
+		claimed := false
+		for _, claim := range claimsByPort[port.Port] {
+			namespace, err := namespaces.get(claim.Service.App.Id)
+			if err != nil {
+				return result, err
+			}
+			if namespace == port.Namespace && claim.Service.Name == port.Name {
+				claimed = true
+				break
+			}
+		}
+		if claimed {
+			continue
+		}
+		for _, claim := range claimsByPort[port.Port] {
+			result.Conflicts = append(result.Conflicts, fmt.Sprintf("%d claimed by %s is held by service %s/%s",
+				port.Port, claim.Owner(), port.Namespace, port.Name))
+		}
+		recorded = append(recorded, &svcmodel.ServiceNodePort{
+			Port:      port.Port,
+			Namespace: port.Namespace,
+			Name:      port.Name,
+		})
+	}
+	if err := svcmodel.ServiceNodePortModel.ReplaceLive(cluster, recorded); err != nil {
+		return result, err
+	}
+	result.Recorded = len(recorded)
+	return result, nil
+}
+
+// appNamespaces caches the kubernetes namespace of apps.
+type appNamespaces struct {
+	namespaces map[int64]string
+}
+
+func newAppNamespaces() *appNamespaces {
+	return &appNamespaces{namespaces: make(map[int64]string)}
+}
+
+func (n *appNamespaces) get(appId int64) (string, error) {
+	if namespace, ok := n.namespaces[appId]; ok {
+		return namespace, nil
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(appId)
+	if err != nil {
+		return "", err
+	}
+	n.namespaces[appId] = namespace.KubeNamespace
+	return namespace.KubeNamespace, nil
+}
+
+// checkNodePorts aborts with 409 when node ports set in the template are claimed by the template of
+// another service or held in a cluster the service is published to, and returns them otherwise.
+func checkNodePorts(c *base.APIController, serviceId int64, template string) []int32 {
+	kubeService, err := resources.ServiceFromTemplate(template, "")
+	if err != nil {
+		abortError(c, apierror.InvalidParam("KubeService"))
+	}
+	ports := resources.NodePorts(kubeService)
+	if len(ports) == 0 {
+		return ports
+	}
+
+	conflicts, err := nodePortConflicts(serviceId, kubeService.Name, ports)
+	if err != nil {
+		requestLog(c.Ctx).Error("check node ports of service (%d) error.%v", serviceId, err)
+		abortError(c, err)
+	}
+	if len(conflicts) > 0 {
+		metrics.ValidationFailed(metrics.ValidationReasonNodePortConflict)
+		fields := make([]apierror.FieldError, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			fields = append(fields, apierror.FieldError{Field: "spec.ports.nodePort", Message: conflict})
+		}
+		abortError(c, apierror.Conflict(fmt.Sprintf("nodePort conflict: %s", strings.Join(conflicts, "; ")), fields...))
+	}
+	return ports
+}
+
+func nodePortConflicts(serviceId int64, name string, ports []int32) ([]string, error) {
+	service, err := svcmodel.ServiceModel.GetById(serviceId)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = service.Name
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, err
+	}
+	status, err := models.PublishStatusModel.GetAll(models.PublishTypeService, serviceId)
+	if err != nil {
+		return nil, err
+	}
+	clusters := make([]string, 0, len(status))
+	for _, s := range status {
+		clusters = append(clusters, s.Cluster)
+	}
+
+	holders, err := svcmodel.ServiceNodePortModel.GetConflicts(serviceId, ports, clusters)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := []string{}
+	for _, holder := range holders {
+		if holder.Service == nil && holder.Namespace == namespace.KubeNamespace && holder.Name == name {
+			// allocated to the service itself by an earlier publish
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%d is claimed by %s", holder.Port, holder.Owner()))
+	}
+	return conflicts, nil
+}
+
+// reclaimNodePorts moves the node port claims of the service to its latest template once the template
+// claiming them is deleted. They are released if there is none left, or if another service claimed
+// one of its ports meanwhile, for the next backfill to report the conflict.
+func reclaimNodePorts(ctx context.Context, serviceId int64) error {
+	var templateId int64
+	ports := []int32{}
+	tpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(serviceId)
+	switch {
+	case apierror.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		kubeService, err := resources.ServiceFromTemplate(tpl.Template, "")
+		if err != nil {
+			return err
+		}
+		templateId, ports = tpl.Id, resources.NodePorts(kubeService)
+	}
+	err = svcmodel.ServiceNodePortModel.Claim(serviceId, templateId, ports)
+	if err != nil && apierror.From(err).Code == apierror.CodeConflict {
+		logging.FromContext(ctx).Warning("reclaim node ports of template (%d) error.%v", templateId, err)
+		return svcmodel.ServiceNodePortModel.Claim(serviceId, 0, nil)
+	}
+	return err
+}
+
+// backfillNodePortClaims claims the node ports of the latest template of the services claiming none,
+// e.g. saved before the ports were registered. Ports claimed by another service are returned as conflicts.
+func backfillNodePortClaims(ctx context.Context) (*nodePortReconcileResult, error) {
+	result := &nodePortReconcileResult{}
+	claiming, err := svcmodel.ServiceNodePortModel.GetClaimingServiceIds()
+	if err != nil {
+		return result, err
+	}
+	services, err := svcmodel.ServiceModel.GetNames(map[string]interface{}{"Deleted": false})
+	if err != nil {
+		return result, err
+	}
+	log := logging.FromContext(ctx)
+	for _, service := range services {
+		if claiming[service.Id] {
+			continue
+		}
+		tpl, err := svcmodel.ServiceTplModel.GetLatestTemplate(service.Id)
+		if apierror.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		kubeService, err := resources.ServiceFromTemplate(tpl.Template, "")
+		if err != nil {
+			log.Warning("parse template (%d) of service (%d) error.%v", tpl.Id, service.Id, err)
+			continue
+		}
+		ports := resources.NodePorts(kubeService)
+		if len(ports) == 0 {
+			continue
+		}
+		err = svcmodel.ServiceNodePortModel.Claim(service.Id, tpl.Id, ports)
+		switch {
+		case err == nil:
+			result.Recorded += len(ports)
+		case apierror.From(err).Code == apierror.CodeConflict:
+			result.Conflicts = append(result.Conflicts, fmt.Sprintf("template %d of service %s: %v", tpl.Id, service.Name, err))
+		default:
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// nodePortRange returns the node port range of the nodeport-range rule of the app, the default range without one.
+func nodePortRange(appId int64) (int32, int32, error) {
+	rules, err := svcmodel.ServiceLintRuleSetModel.GetRules(appId)
+	if err != nil {
+		return 0, 0, err
+	}
+	var params map[string]string
+	for _, rule := range rules {
+		if rule.Name == lint.RuleNodePortRange {
+			params = rule.Params
+		}
+	}
+	return lint.NodePortRange(params)
+}
+
+// @Title SuggestNodePorts
+// @Description suggest node ports of the range allowed to the app which no template claims and no cluster holds
+// @Param	cluster		query 	string	false		"only avoid the ports held in this cluster, default all clusters"
+// @Param	count		query 	int	false		"number of ports, default 1, max 20"
+// @Success 200 {object} []int32 success
+// @router /nodeports/suggest [get]
+func (c *ServiceController) SuggestNodePorts() {
+	count, err := c.GetInt("count", 1)
+	if err != nil || count < 1 || count > maxSuggestNodePorts {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid count, must be between 1 and %d", maxSuggestNodePorts)))
+	}
+	min, max, err := nodePortRange(c.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get node port range of app (%d) error.%v", c.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	used, err := svcmodel.ServiceNodePortModel.GetUsed(c.Input().Get("cluster"))
+	if err != nil {
+		requestLog(c.Ctx).Error("get used node ports error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(resources.FreeNodePorts(min, max, used, count))
+}
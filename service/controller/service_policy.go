@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+// 服务准入 Rego 策略，仅管理员可操作
+type ServicePolicyController struct {
+	base.APIController
+}
+
+// servicePolicyParam is the body of Create and Update, a policy is enabled unless "enabled" is false.
+type servicePolicyParam struct {
+	svcmodel.ServicePolicy
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type evaluatePolicyParam struct {
+	// Module is a draft policy to evaluate instead of the enabled ones.
+	Module   string `json:"module,omitempty"`
+	Template string `json:"template"`
+	AppId    int64  `json:"appId"`
+	Action   string `json:"action"`
+	Cluster  string `json:"cluster,omitempty"`
+}
+
+type evaluatePolicyResult struct {
+	Allowed  bool     `json:"allowed"`
+	Messages []string `json:"messages"`
+}
+
+func (c *ServicePolicyController) URLMapping() {
+	c.Mapping("List", c.List)
+	c.Mapping("Create", c.Create)
+	c.Mapping("Update", c.Update)
+	c.Mapping("Versions", c.Versions)
+	c.Mapping("Delete", c.Delete)
+	c.Mapping("Evaluate", c.Evaluate)
+}
+
+func (c *ServicePolicyController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+
+	if !c.User.Admin {
+		abortError(&c.APIController, apierror.Forbidden("operation need admin permission."))
+	}
+}
+
+func (c *ServicePolicyController) policyFromBody() svcmodel.ServicePolicy {
+	var param servicePolicyParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil || param.Name == "" {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServicePolicy"))
+	}
+	p := param.ServicePolicy
+	p.Enabled = param.Enabled == nil || *param.Enabled
+
+	// an enabled version is evaluated along with the other active policies, it must compile with them
+	var others map[string]string
+	if p.Enabled {
+		others, err = svcmodel.ServicePolicyModel.GetOtherActiveModules(p.Name)
+		if err != nil {
+			requestLog(c.Ctx).Error("get active policies error. %v", err)
+			abortError(&c.APIController, err)
+		}
+	}
+	if err := policy.Compile(p.Name, p.Module, others); err != nil {
+		abortError(&c.APIController, apierror.Validation(err.Error()))
+	}
+	return p
+}
+
+// @Title GetAll
+// @Description get the latest version of all policies
+// @Success 200 {object} []models.ServicePolicy success
+// @router / [get]
+func (c *ServicePolicyController) List() {
+	policies, err := svcmodel.ServicePolicyModel.GetLatest()
+	if err != nil {
+		requestLog(c.Ctx).Error("list service policies error. %v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(policies)
+}
+
+// @Title Create
+// @Description create a policy
+// @Param	body		body 	models.ServicePolicy	true		"The ServicePolicy content"
+// @Success 200 return models.ServicePolicy success
+// @router / [post]
+func (c *ServicePolicyController) Create() {
+	p := c.policyFromBody()
+	versions, err := svcmodel.ServicePolicyModel.GetVersions(p.Name)
+	if err != nil {
+		requestLog(c.Ctx).Error("get versions of policy %s error. %v", p.Name, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if len(versions) > 0 {
+		abortError(&c.APIController, apierror.Conflict("Policy "+p.Name+" already exists."))
+	}
+
+	p.User = c.User.Name
+	_, err = svcmodel.ServicePolicyModel.AddVersion(&p)
+	if err != nil {
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(p)
+}
+
+// @Title Update
+// @Description save a new version of the policy
+// @Param	id		path 	int	true		"The id of any version of the policy"
+// @Param	body		body 	models.ServicePolicy	true		"The body"
+// @Success 200 models.ServicePolicy success
+// @router /:id([0-9]+) [put]
+func (c *ServicePolicyController) Update() {
+	id := c.GetIDFromURL()
+	current, err := svcmodel.ServicePolicyModel.GetById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get policy (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	p := c.policyFromBody()
+
+	// versions belong to the policy of the url, the name can not be changed
+	p.Name = current.Name
+	p.User = c.User.Name
+	_, err = svcmodel.ServicePolicyModel.AddVersion(&p)
+	if err != nil {
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(p)
+}
+
+// @Title Versions
+// @Description get all versions of the policy
+// @Param	id		path 	int	true		"The id of any version of the policy"
+// @Success 200 {object} []models.ServicePolicy success
+// @router /:id([0-9]+)/versions [get]
+func (c *ServicePolicyController) Versions() {
+	id := c.GetIDFromURL()
+	current, err := svcmodel.ServicePolicyModel.GetById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get policy (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	versions, err := svcmodel.ServicePolicyModel.GetVersions(current.Name)
+	if err != nil {
+		requestLog(c.Ctx).Error("get versions of policy %s error. %v", current.Name, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(versions)
+}
+
+// @Title Delete
+// @Description delete all versions of the policy
+// @Param	id		path 	int	true		"The id of any version of the policy"
+// @Success 200 {string} delete success!
+// @router /:id([0-9]+) [delete]
+func (c *ServicePolicyController) Delete() {
+	id := c.GetIDFromURL()
+	current, err := svcmodel.ServicePolicyModel.GetById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get policy (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	err = svcmodel.ServicePolicyModel.DeleteByName(current.Name)
+	if err != nil {
+		requestLog(c.Ctx).Error("delete policy %s error.%v", current.Name, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(nil)
+}
+
+// @Title Evaluate
+// @Description test-evaluate a draft policy or the enabled policies against a template
+// @Param	body		body 	controller.evaluatePolicyParam	true		"The policy, template and context"
+// @Success 200 {object} controller.evaluatePolicyResult success
+// @router /evaluate [post]
+func (c *ServicePolicyController) Evaluate() {
+	var param evaluatePolicyParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("EvaluatePolicy"))
+	}
+	service := v1.Service{}
+	if err = json.Unmarshal(hack.Slice(param.Template), &service); err != nil {
+		requestLog(c.Ctx).Error("valid template err %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+	}
+
+	var modules map[string]string
+	evaluate := policy.EvaluateCached
+	if param.Module != "" {
+		evaluate = policy.Evaluate
+		if err := policy.Compile("draft", param.Module, nil); err != nil {
+			abortError(&c.APIController, apierror.Validation(err.Error()))
+		}
+		modules = map[string]string{"draft.rego": param.Module}
+	} else {
+		modules, err = svcmodel.ServicePolicyModel.GetActiveModules()
+		if err != nil {
+			requestLog(c.Ctx).Error("get active policies error. %v", err)
+			abortError(&c.APIController, err)
+			return
+		}
+	}
+
+	input, err := policyInput(templateContext{
+		AppId:   param.AppId,
+		User:    c.User,
+		Action:  param.Action,
+		Cluster: param.Cluster,
+	}, &service)
+	if err != nil {
+		requestLog(c.Ctx).Error("build policy input of app (%d) error. %v", param.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	messages, err := evaluate(c.Ctx.Request.Context(), modules, input)
+	if err != nil {
+		requestLog(c.Ctx).Error("evaluate policies error. %v", err)
+		abortError(&c.APIController, apierror.Validation(err.Error()))
+	}
+	c.Success(evaluatePolicyResult{Allowed: len(messages) == 0, Messages: messages})
+}
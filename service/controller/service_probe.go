@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+const (
+	defaultProbeTimeout = 5
+	maxProbeTimeout     = 30
+)
+
+// probeLimiters rate-limits the probes of each user, probes reach into the clusters.
+var probeLimiters = &userRateLimiters{limiters: make(map[string]flowcontrol.RateLimiter)}
+
+type userRateLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]flowcontrol.RateLimiter
+}
+
+// tryAccept reports whether the user may probe now.
+func (l *userRateLimiters) tryAccept(user string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[user]
+	if !ok {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(
+			float32(beego.AppConfig.DefaultFloat("ServiceProbeQPS", 0.2)),
+			beego.AppConfig.DefaultInt("ServiceProbeBurst", 5))
+		l.limiters[user] = limiter
+	}
+	return limiter.TryAccept()
+}
+
+// hasServicePort reports whether service has a port of the name or number.
+func hasServicePort(service *v1.Service, port string) bool {
+	for _, p := range service.Spec.Ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// @Title Probe
+// @Description probe a port of the Service in a cluster through the service proxy of the API server and return the latency and status, rate-limited per user
+// @Param	id		path 	int	true		"the service id"
+// @Param	cluster		query 	string	true		"the cluster"
+// @Param	port		query 	string	true		"the port name or number"
+// @Param	mode		query 	string	false		"http or tcp, default tcp"
+// @Param	path		query 	string	false		"path of the http probe, default /"
+// @Param	timeout		query 	int	false		"seconds, default 5, max 30"
+// @Success 200 {object} resources.ProbeResult success
+// @router /:id([0-9]+)/probe [post]
+func (c *ServiceController) Probe() {
+	service := c.serviceOfApp()
+
+	cluster := c.Input().Get("cluster")
+	port := c.Input().Get("port")
+	if cluster == "" || port == "" {
+		abortError(&c.APIController, apierror.Validation("cluster and port are required."))
+	}
+	mode := c.Input().Get("mode")
+	if mode == "" {
+		mode = resources.ProbeModeTCP
+	}
+	if mode != resources.ProbeModeTCP && mode != resources.ProbeModeHTTP {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid mode %s, must be %s or %s.", mode, resources.ProbeModeTCP, resources.ProbeModeHTTP)))
+	}
+	path := c.Input().Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	timeout, err := c.GetInt("timeout", defaultProbeTimeout)
+	if err != nil || timeout < 1 || timeout > maxProbeTimeout {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid timeout, must be between 1 and %d seconds.", maxProbeTimeout)))
+	}
+	if !probeLimiters.tryAccept(c.User.Name) {
+		abortError(&c.APIController, apierror.RateLimited("Too many probes, try again later."))
+	}
+
+	status, err := models.PublishStatusModel.GetAll(models.PublishTypeService, service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	templateId := int64(0)
+	for _, s := range status {
+		if s.Cluster == cluster {
+			templateId = s.TemplateId
+		}
+	}
+	if templateId == 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Service %s is not published to cluster %s.", service.Name, cluster)))
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get namespace of app (%d) error.%v", service.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	live, err := liveService(c.Ctx.Request.Context(), cluster, templateId, namespace.KubeNamespace)
+	if err != nil {
+		requestLog(c.Ctx).Error("get service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if live == nil {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Service %s no longer exists in cluster %s.", service.Name, cluster)))
+	}
+	if !hasServicePort(live, port) {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Service %s has no port %s.", service.Name, port)))
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		requestLog(c.Ctx).Error("get client of cluster (%s) error.%v", cluster, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	result := resources.Probe(c.Ctx.Request.Context(), resources.NewServiceProxy(cli), namespace.KubeNamespace, live.Name,
+		port, mode, path, time.Duration(timeout)*time.Second)
+	result.Cluster = cluster
+	requestLog(c.Ctx).Info("user %s probed port %s of service (%d) in cluster (%s): reachable %v, %dms",
+		c.User.Name, port, service.Id, cluster, result.Reachable, result.LatencyMs)
+	c.Success(result)
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+type promoteParam struct {
+	// Cluster is where the template is live, required when it is live in more than one cluster.
+	Cluster string `json:"cluster,omitempty"`
+	// publishOptions are used to publish to the next environment.
+	publishOptions
+}
+
+type promoteResult struct {
+	Template *models.ServiceTemplate          `json:"template"`
+	Lineage  *svcmodel.ServiceTemplateLineage `json:"lineage"`
+}
+
+// liveClusters returns the clusters the template is currently published to.
+func liveClusters(serviceId int64, tplId int64) ([]string, error) {
+	status, err := models.PublishStatusModel.GetAll(models.PublishTypeService, serviceId)
+	if err != nil {
+		return nil, err
+	}
+	clusters := []string{}
+	for _, s := range status {
+		if s.TemplateId == tplId {
+			clusters = append(clusters, s.Cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// checkDrift returns the fields of the template drifted from the live Service in cluster.
+func checkDrift(ctx context.Context, service *models.Service, tpl *models.ServiceTemplate, cluster string) ([]string, error) {
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := resources.ServiceFromTemplate(tpl.Template, namespace.KubeNamespace)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return nil, err
+	}
+	live, err := resources.GetService(ctx, cli, namespace.KubeNamespace, desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	drifted := resources.Drift(live, desired)
+	metrics.ObserveDrift(cluster, drifted)
+	return drifted, nil
+}
+
+// @Title Promote
+// @Description promote the ServiceTemplate live in one environment to the next environment of its pipeline
+// @Param	id		path 	int	true		"the template id"
+// @Param	body		body 	controller.promoteParam	false		"the source cluster"
+// @Success 200 {object} controller.promoteResult success
+// @router /:id([0-9]+)/promote [post]
+func (c *ServiceTplController) Promote() {
+	id := c.GetIDFromURL()
+	var param promoteParam
+	if len(c.Ctx.Input.RequestBody) > 0 {
+		if err := json.Unmarshal(c.Ctx.Input.RequestBody, &param); err != nil {
+			requestLog(c.Ctx).Error("get body error. %v", err)
+			abortError(&c.APIController, apierror.InvalidParam("Promote"))
+		}
+	}
+	if !validStrategy(&param.Strategy) {
+		abortError(&c.APIController, apierror.Validation("Unknown publish strategy "+param.Strategy+"."))
+	}
+
+	tpl, service := appTemplate(&c.APIController, int64(id))
+
+	clusters, err := liveClusters(service.Id, tpl.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	switch {
+	case param.Cluster == "" && len(clusters) == 1:
+		param.Cluster = clusters[0]
+	case param.Cluster == "" || !containsString(clusters, param.Cluster):
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Template %d is not live in cluster %s, live in %v.", tpl.Id, param.Cluster, clusters)))
+	}
+
+	from, err := svcmodel.ServiceEnvironmentModel.GetByAppAndCluster(c.AppId, param.Cluster)
+	if err != nil {
+		requestLog(c.Ctx).Info("get environment of app (%d) cluster (%s) error.%v", c.AppId, param.Cluster, err)
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Cluster %s of this app is not part of a promotion pipeline.", param.Cluster)))
+	}
+	to, err := svcmodel.ServiceEnvironmentModel.GetNext(from)
+	if err != nil {
+		requestLog(c.Ctx).Info("get next environment of (%d) error.%v", from.Id, err)
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Environment %s is the last stage of pipeline %s.", from.Name, from.Pipeline)))
+	}
+	if to.AppId != c.AppId && !c.User.Admin {
+		c.checkPromoteTarget(to.AppId)
+	}
+
+	// refuse templates which are invalid or no longer match what runs in the source cluster
+	_, err = validServiceTemplate(templateContext{
+		AppId:   service.AppId,
+		User:    c.User,
+		Action:  policy.ActionPublish,
+		Cluster: param.Cluster,
+	}, tpl.Template)
+	if err != nil {
+		abortInvalidServiceTemplate(&c.APIController, err)
+	}
+	drifted, err := checkDrift(c.Ctx.Request.Context(), service, tpl, param.Cluster)
+	if err != nil {
+		requestLog(c.Ctx).Error("check drift of template (%d) in cluster (%s) error.%v", tpl.Id, param.Cluster, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if len(drifted) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Template %d has drifted in cluster %s: %s.", tpl.Id, param.Cluster, strings.Join(drifted, ", "))))
+	}
+
+	promoted := tpl.Template
+	if to.Overrides != "" {
+		data, err := jsonpatch.MergePatch(hack.Slice(tpl.Template), hack.Slice(to.Overrides))
+		if err != nil {
+			requestLog(c.Ctx).Error("apply overrides of environment (%d) error.%v", to.Id, err)
+			abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Overrides of environment %s can not be applied: %v", to.Name, err)))
+		}
+		promoted = string(data)
+	}
+	_, err = validServiceTemplate(templateContext{
+		AppId:   to.AppId,
+		User:    c.User,
+		Action:  policy.ActionPublish,
+		Cluster: to.Cluster,
+	}, promoted)
+	if err != nil {
+		abortInvalidServiceTemplate(&c.APIController, err)
+	}
+
+	target := service
+	createdTarget := false
+	if to.AppId != service.AppId {
+		target, err = svcmodel.ServiceModel.GetByName(to.AppId, service.Name)
+		if apierror.IsNotFound(err) {
+			target = &models.Service{
+				Name:        service.Name,
+				MetaData:    service.MetaData,
+				Description: service.Description,
+				User:        c.User.Name,
+				AppId:       to.AppId,
+			}
+			createdTarget, err = true, nil
+		}
+		if err != nil {
+			requestLog(c.Ctx).Error("get service %s in app (%d) error.%v", service.Name, to.AppId, err)
+			abortError(&c.APIController, err)
+			return
+		}
+	}
+
+	newTpl := &models.ServiceTemplate{
+		Name:        tpl.Name,
+		Template:    promoted,
+		Description: fmt.Sprintf("promoted from %s template %d", from.Name, tpl.Id),
+		User:        c.User.Name,
+	}
+	lineage := &svcmodel.ServiceTemplateLineage{
+		SourceTemplateId: tpl.Id,
+		SourceCluster:    param.Cluster,
+		TargetCluster:    to.Cluster,
+		Pipeline:         from.Pipeline,
+		FromEnvironment:  from.Name,
+		ToEnvironment:    to.Name,
+		User:             c.User.Name,
+	}
+	if err = svcmodel.ServiceEnvironmentModel.AddPromotion(target, newTpl, lineage); err != nil {
+		requestLog(c.Ctx).Error("create promoted template of service %s in app (%d) error.%v", service.Name, to.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	if err := publishServiceTemplate(writeContext(c.Ctx), target, newTpl, to.Cluster, param.publishOptions); err != nil {
+		requestLog(c.Ctx).Error("publish promoted template (%d) to cluster (%s) error.%v", newTpl.Id, to.Cluster, err)
+		c.rollbackPromotion(target, createdTarget, newTpl, lineage, to.Cluster)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(promoteResult{Template: newTpl, Lineage: lineage})
+}
+
+// checkPromoteTarget aborts unless the user may create Services in the app of the next
+// environment and publish them to its cluster.
+func (c *ServiceTplController) checkPromoteTarget(appId int64) {
+	for _, perType := range []string{models.PermissionTypeService, models.PermissionTypeKubeService} {
+		ok, err := svcmodel.HasAppPermission(c.User.Id, appId, perType, models.PermissionCreate)
+		if err != nil {
+			requestLog(c.Ctx).Error("get permissions of user (%d) in app (%d) error. %v", c.User.Id, appId, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if !ok {
+			abortError(&c.APIController, apierror.Forbidden("Permission denied on the app of the next environment."))
+		}
+	}
+}
+
+// rollbackPromotion removes the rows of a promotion whose publish failed, unless the Service
+// was applied in the cluster anyway and its publish status refers to the promoted template.
+func (c *ServiceTplController) rollbackPromotion(target *models.Service, createdTarget bool, tpl *models.ServiceTemplate,
+	lineage *svcmodel.ServiceTemplateLineage, cluster string) {
+	clusters, err := liveClusters(target.Id, tpl.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", target.Id, err)
+		return
+	}
+	if containsString(clusters, cluster) {
+		return
+	}
+	if err := svcmodel.ServiceEnvironmentModel.RemovePromotion(target, createdTarget, tpl, lineage); err != nil {
+		requestLog(c.Ctx).Error("remove promoted template (%d) error.%v", tpl.Id, err)
+	}
+}
+
+// @Title Lineage
+// @Description get the promotions the ServiceTemplate was source or target of
+// @Param	id		path 	int	true		"the template id"
+// @Success 200 {object} []models.ServiceTemplateLineage success
+// @router /:id([0-9]+)/lineage [get]
+func (c *ServiceTplController) Lineage() {
+	id := c.GetIDFromURL()
+	appTemplate(&c.APIController, int64(id))
+
+	lineages, err := svcmodel.ServiceEnvironmentModel.GetLineage(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get lineage of template (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(lineages)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
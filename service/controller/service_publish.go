@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+type publishParam struct {
+	Clusters []string `json:"clusters"`
+	publishOptions
+}
+
+// @Title Publish
+// @Description publish the ServiceTemplate to clusters, or with dryRun only preview the changes per cluster. Answers 207 when some clusters failed, and the status of the failures when all did.
+// @Param	id		path 	int	true		"the template id"
+// @Param	dryRun		query 	bool	false		"only preview the changes, default false"
+// @Param	body		body 	controller.publishParam	true		"the clusters to publish to"
+// @Success 200 {object} []resources.PublishPreview success
+// @router /:id([0-9]+)/publish [post]
+func (c *ServiceTplController) Publish() {
+	id := c.GetIDFromURL()
+	dryRun, _ := c.GetBool("dryRun", false)
+	var param publishParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil || len(param.Clusters) == 0 {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Publish"))
+	}
+	if !validStrategy(&param.Strategy) {
+		abortError(&c.APIController, apierror.Validation("Unknown publish strategy "+param.Strategy+"."))
+	}
+
+	tpl, service := appTemplate(&c.APIController, int64(id))
+
+	// every cluster is handled on its own, a failing cluster does not stop the others
+	previews := make([]*resources.PublishPreview, 0, len(param.Clusters))
+	failures := []*apierror.Error{}
+	for _, cluster := range param.Clusters {
+		preview, err := c.publishToCluster(service, tpl, cluster, param.publishOptions, dryRun)
+		if err != nil {
+			requestLog(c.Ctx).Error("publish template (%d) to cluster (%s) error.%v", tpl.Id, cluster, err)
+			if preview == nil {
+				preview = &resources.PublishPreview{Cluster: cluster}
+			}
+			preview.Failure = apiError(err)
+			preview.Error = preview.Failure.Error()
+			if preview.Failure.Cluster == "" {
+				preview.Failure.Cluster = cluster
+			}
+			if conflictErr, ok := err.(*resources.ApplyConflictError); ok {
+				preview.Conflicts = conflictErr.Conflicts
+			}
+			failures = append(failures, preview.Failure)
+		}
+		previews = append(previews, preview)
+	}
+	if len(failures) > 0 {
+		c.Ctx.Output.SetStatus(publishStatus(failures, len(previews)))
+	}
+	c.Success(previews)
+}
+
+// publishStatus is the status of a publish to clusters of which some failed: 207 when others
+// succeeded, otherwise the status the failures agree on, or 502.
+func publishStatus(failures []*apierror.Error, clusters int) int {
+	if len(failures) < clusters {
+		return http.StatusMultiStatus
+	}
+	status := failures[0].Status
+	for _, failure := range failures[1:] {
+		if failure.Status != status {
+			return http.StatusBadGateway
+		}
+	}
+	return status
+}
+
+func (c *ServiceTplController) publishToCluster(service *models.Service, tpl *models.ServiceTemplate, cluster string, options publishOptions, dryRun bool) (*resources.PublishPreview, error) {
+	warnings, err := validServiceTemplate(templateContext{
+		AppId:   service.AppId,
+		User:    c.User,
+		Action:  policy.ActionPublish,
+		Cluster: cluster,
+	}, tpl.Template)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := previewServiceTemplate(c.Ctx.Request.Context(), service, tpl, cluster, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		preview.Warnings = append(preview.Warnings, warning.String())
+	}
+	if dryRun {
+		return preview, nil
+	}
+	if err := publishServiceTemplate(writeContext(c.Ctx), service, tpl, cluster, options); err != nil {
+		return preview, err
+	}
+	preview.State = publishStatePublished
+	if kubeService, err := resources.ServiceFromTemplate(tpl.Template, ""); err == nil && kubeService.Spec.Type == v1.ServiceTypeLoadBalancer {
+		preview.State = publishStatePending
+	}
+	return preview, nil
+}
+
+// validStrategy defaults an empty strategy to resources.StrategyUpdate and reports whether it is known.
+func validStrategy(strategy *string) bool {
+	switch *strategy {
+	case "":
+		*strategy = resources.StrategyUpdate
+		return true
+	case resources.StrategyUpdate, resources.StrategyRecreate:
+		return true
+	}
+	return false
+}
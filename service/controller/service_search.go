@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+)
+
+// 跨项目搜索服务
+type ServiceSearchController struct {
+	base.APIController
+}
+
+func (c *ServiceSearchController) URLMapping() {
+	c.Mapping("Search", c.Search)
+}
+
+func (c *ServiceSearchController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Check administration, results are filtered by the apps the user can read
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+}
+
+// timeFromQuery parses an RFC3339 time query parameter.
+func timeFromQuery(c *base.APIController, key string) *time.Time {
+	value := c.Input().Get(key)
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		abortError(c, apierror.InvalidParam(key))
+	}
+	return &t
+}
+
+// @Title Search
+// @Description search Services across all apps the user can read, the template filters match their latest template
+// @Param	appId		query 	int	false		"limit to the app"
+// @Param	name		query 	string	false		"service name filter"
+// @Param	label		query 	string	false		"selector label of the latest template, key or key=value"
+// @Param	port		query 	int	false		"port, targetPort or nodePort"
+// @Param	type		query 	string	false		"service type"
+// @Param	annotation		query 	string	false		"annotation, key or key=value"
+// @Param	creator		query 	string	false		"service creator"
+// @Param	description		query 	string	false		"description text of the service or of its latest template"
+// @Param	createdAfter		query 	string	false		"RFC3339 time"
+// @Param	createdBefore		query 	string	false		"RFC3339 time"
+// @Param	updatedAfter		query 	string	false		"RFC3339 time"
+// @Param	updatedBefore		query 	string	false		"RFC3339 time"
+// @Param	sort		query 	string	false		"id, createTime or updateTime, prefix - for descending, default -id"
+// @Param	cursor		query 	string	false		"cursor returned by the previous page"
+// @Param	limit		query 	int	false		"page size, default 20, max 100"
+// @Success 200 {object} models.CursorPage success
+// @router / [get]
+func (c *ServiceSearchController) Search() {
+	var err error
+	query := &svcmodel.ServiceSearchQuery{
+		Name:          c.Input().Get("name"),
+		Label:         c.Input().Get("label"),
+		Type:          v1.ServiceType(c.Input().Get("type")),
+		Annotation:    c.Input().Get("annotation"),
+		Creator:       c.Input().Get("creator"),
+		Description:   c.Input().Get("description"),
+		CreatedAfter:  timeFromQuery(&c.APIController, "createdAfter"),
+		CreatedBefore: timeFromQuery(&c.APIController, "createdBefore"),
+		UpdatedAfter:  timeFromQuery(&c.APIController, "updatedAfter"),
+		UpdatedBefore: timeFromQuery(&c.APIController, "updatedBefore"),
+	}
+	if port := c.Input().Get("port"); port != "" {
+		p, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			abortError(&c.APIController, apierror.InvalidParam("port"))
+		}
+		query.Port = int32(p)
+	}
+
+	cursorQuery := cursorQueryFromInput(&c.APIController,
+		svcmodel.Sort{Key: svcmodel.SortKeyId, Desc: true}, svcmodel.ServiceSearchSortKeys...)
+	query.Sort = cursorQuery.Sort
+	query.Cursor = cursorQuery.Cursor
+	query.Limit = cursorQuery.Limit
+
+	if !c.User.Admin {
+		query.AppIds, err = svcmodel.PermittedAppCache.Get(c.User.Id, models.PermissionRead)
+		if err != nil {
+			requestLog(c.Ctx).Error("get readable apps of user (%d) error. %v", c.User.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+	}
+	if appId := c.Input().Get("appId"); appId != "" {
+		id, err := strconv.ParseInt(appId, 10, 64)
+		if err != nil {
+			abortError(&c.APIController, apierror.InvalidParam("appId"))
+		}
+		if query.AppIds != nil && !containsId(query.AppIds, id) {
+			abortError(&c.APIController, apierror.Forbidden("Permission denied."))
+		}
+		query.AppIds = []int64{id}
+	}
+
+	page, err := svcmodel.ServiceModel.Search(query)
+	if err != nil {
+		requestLog(c.Ctx).Error("search services by query (%+v) error. %v", query, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(page)
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
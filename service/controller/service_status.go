@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+)
+
+// liveService returns the Service published from the template to cluster, nil if it no longer exists there.
+func liveService(ctx context.Context, cluster string, templateId int64, namespace string) (*v1.Service, error) {
+	tpl, err := svcmodel.ServiceTplModel.GetById(templateId)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := resources.ServiceFromTemplate(tpl.Template, namespace)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := resources.Client(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return resources.GetService(ctx, cli, namespace, desired.Name)
+}
+
+const (
+	publishStatePublished = "published"
+	// publishStatePending is a publish waiting for the load balancer of the Service.
+	publishStatePending = "pending"
+	// publishStateFailed is a publish whose load balancer was not provisioned in time.
+	publishStateFailed = "failed"
+)
+
+// serviceStatusResult is the state of the Service in a cluster along with the provisioning of its load balancer.
+type serviceStatusResult struct {
+	*resources.ServiceStatus
+	// PublishState is the outcome of the last publish, which waits for the load balancer.
+	PublishState string                        `json:"publishState"`
+	LoadBalancer *svcmodel.ServiceLoadBalancer `json:"loadBalancer,omitempty"`
+}
+
+// loadBalancersByCluster returns the load balancers tracked for the service.
+func loadBalancersByCluster(serviceId int64) (map[string]*svcmodel.ServiceLoadBalancer, error) {
+	lbs, err := svcmodel.ServiceLoadBalancerModel.GetAll(serviceId)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*svcmodel.ServiceLoadBalancer, len(lbs))
+	for _, lb := range lbs {
+		result[lb.Cluster] = lb
+	}
+	return result, nil
+}
+
+// publishState is the outcome of publishing templateId, given the load balancer tracked in its cluster.
+func publishState(templateId int64, lb *svcmodel.ServiceLoadBalancer) string {
+	if lb == nil || lb.TemplateId != templateId {
+		return publishStatePublished
+	}
+	switch lb.State {
+	case svcmodel.LoadBalancerStatePending:
+		return publishStatePending
+	case svcmodel.LoadBalancerStateFailed:
+		return publishStateFailed
+	}
+	return publishStatePublished
+}
+
+// @Title Status
+// @Description get the state of the Service in the clusters it is published to, e.g. its assigned IPv4/IPv6 cluster IPs and the external IPs and provisioning of its load balancer
+// @Param	id		path 	int	true		"the service id"
+// @Success 200 {object} []controller.serviceStatusResult success
+// @router /:id([0-9]+)/status [get]
+func (c *ServiceController) Status() {
+	service := c.serviceOfApp()
+
+	publishStatus, err := models.PublishStatusModel.GetAll(models.PublishTypeService, service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get namespace of app (%d) error.%v", service.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	loadBalancers, err := loadBalancersByCluster(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get load balancers of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	result := make([]*serviceStatusResult, 0, len(publishStatus))
+	for _, s := range publishStatus {
+		live, err := liveService(c.Ctx.Request.Context(), s.Cluster, s.TemplateId, namespace.KubeNamespace)
+		status := resources.NewServiceStatus(s.Cluster, s.TemplateId, live)
+		if err != nil {
+			requestLog(c.Ctx).Warning("get status of service (%d) in cluster (%s) error.%v", service.Id, s.Cluster, err)
+			status.Error = errorMessage(err)
+		}
+		result = append(result, &serviceStatusResult{
+			ServiceStatus: status,
+			PublishState:  publishState(s.TemplateId, loadBalancers[s.Cluster]),
+			LoadBalancer:  loadBalancers[s.Cluster],
+		})
+	}
+	c.Success(result)
+}
@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/resources"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const maxSwitchGracePeriod = 30 * 60
+
+type switchParam struct {
+	// Selector are the labels of the target, merged into the selector of the current template, e.g. version=green.
+	Selector map[string]string `json:"selector"`
+	// Clusters default to the clusters the current template is live in.
+	Clusters []string `json:"clusters,omitempty"`
+	// GracePeriod in seconds during which the switch is reverted if the ready endpoints drop to zero, 0 disables it.
+	GracePeriod int `json:"gracePeriod,omitempty"`
+}
+
+type switchClusterResult struct {
+	Cluster   string `json:"cluster"`
+	ReadyPods int    `json:"readyPods"`
+	Error     string `json:"error,omitempty"`
+}
+
+type switchResult struct {
+	Template *models.ServiceTemplate `json:"template"`
+	Clusters []switchClusterResult   `json:"clusters"`
+}
+
+// @Title Switch
+// @Description switch the selector of the Service to a blue/green target and publish it
+// @Param	id		path 	int	true		"the service id"
+// @Param	body		body 	controller.switchParam	true		"the target selector"
+// @Success 200 {object} controller.switchResult success
+// @router /:id([0-9]+)/switch [post]
+func (c *ServiceController) Switch() {
+	var param switchParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil || len(param.Selector) == 0 {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("Switch"))
+	}
+	if param.GracePeriod < 0 || param.GracePeriod > maxSwitchGracePeriod {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Grace period must be between 0 and %d seconds.", maxSwitchGracePeriod)))
+	}
+
+	service := c.serviceOfApp()
+	current, err := svcmodel.ServiceTplModel.GetLatestTemplate(service.Id)
+	if err != nil {
+		requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", service.Id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if len(param.Clusters) == 0 {
+		param.Clusters, err = liveClusters(service.Id, current.Id)
+		if err != nil {
+			requestLog(c.Ctx).Error("get publish status of service (%d) error.%v", service.Id, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if len(param.Clusters) == 0 {
+			abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Template %d is not live in any cluster.", current.Id)))
+		}
+	}
+
+	kubeService := v1.Service{}
+	if err := json.Unmarshal(hack.Slice(current.Template), &kubeService); err != nil {
+		requestLog(c.Ctx).Error("valid template err %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+	}
+	if kubeService.Spec.Selector == nil {
+		kubeService.Spec.Selector = make(map[string]string)
+	}
+	for key, value := range param.Selector {
+		kubeService.Spec.Selector[key] = value
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("get namespace of app (%d) error.%v", service.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	// the target must serve traffic in every cluster before anything is switched
+	results := make([]switchClusterResult, 0, len(param.Clusters))
+	notReady := []string{}
+	for _, cluster := range param.Clusters {
+		result := switchClusterResult{Cluster: cluster}
+		cli, err := resources.Client(cluster)
+		if err == nil {
+			result.ReadyPods, err = resources.ReadyPods(c.Ctx.Request.Context(), cli, namespace.KubeNamespace, kubeService.Spec.Selector)
+		}
+		if err != nil {
+			requestLog(c.Ctx).Error("get ready pods in cluster (%s) error.%v", cluster, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		if result.ReadyPods == 0 {
+			notReady = append(notReady, cluster)
+		}
+		results = append(results, result)
+	}
+	if len(notReady) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Target %s has no ready pods in clusters %s.",
+			labels.Set(param.Selector).String(), strings.Join(notReady, ", "))))
+	}
+
+	template, err := resources.SetServiceSelector(current.Template, param.Selector)
+	if err != nil {
+		requestLog(c.Ctx).Error("patch template selector error.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+		return
+	}
+	for _, cluster := range param.Clusters {
+		_, err = validServiceTemplate(templateContext{
+			AppId:   service.AppId,
+			User:    c.User,
+			Action:  policy.ActionPublish,
+			Cluster: cluster,
+		}, template)
+		if err != nil {
+			abortInvalidServiceTemplate(&c.APIController, err)
+		}
+	}
+
+	switched := &models.ServiceTemplate{
+		Name:        current.Name,
+		Template:    template,
+		ServiceId:   service.Id,
+		Description: fmt.Sprintf("switch selector to %s", labels.Set(param.Selector).String()),
+		User:        c.User.Name,
+	}
+	nodePorts := checkNodePorts(&c.APIController, service.Id, template)
+	if err = svcmodel.ServiceTplModel.AddClaimingNodePorts(switched, nodePorts); err != nil {
+		requestLog(c.Ctx).Error("create switched template error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	for i, cluster := range param.Clusters {
+		if err := publishServiceTemplate(writeContext(c.Ctx), service, switched, cluster, publishOptions{}); err != nil {
+			requestLog(c.Ctx).Error("publish switched template (%d) to cluster (%s) error.%v", switched.Id, cluster, err)
+			results[i].Error = errorMessage(err)
+			continue
+		}
+		if param.GracePeriod > 0 {
+			deadline := time.Now().Add(time.Duration(param.GracePeriod) * time.Second).Truncate(time.Second)
+			revert := &svcmodel.ServiceSwitchRevert{
+				ServiceId:          service.Id,
+				Cluster:            cluster,
+				PreviousTemplateId: current.Id,
+				SwitchedTemplateId: switched.Id,
+				Deadline:           &deadline,
+				User:               c.User.Name,
+			}
+			if _, err := svcmodel.ServiceSwitchRevertModel.Add(revert); err != nil {
+				requestLog(c.Ctx).Error("record pending revert of service (%d) in cluster (%s) error.%v", service.Id, cluster, err)
+			}
+			go watchSwitchRevert(writeContext(c.Ctx), revert)
+		}
+	}
+
+	c.Success(switchResult{Template: switched, Clusters: results})
+}
+
+// ResumeSwitchReverts resumes watching the switches still in their grace period when wayne starts,
+// those whose grace period ended while nobody watched them are recorded as abandoned.
+// It is an app start hook, the database is not ready before.
+func ResumeSwitchReverts() error {
+	log := logging.New(logging.FieldRequestId, logging.NewRequestId(), logging.FieldAction, "ResumeSwitchReverts")
+	ctx := logging.NewContext(context.Background(), log)
+	reverts, err := svcmodel.ServiceSwitchRevertModel.GetPending()
+	if err != nil {
+		log.Error("get pending switch reverts error.%v", err)
+		return nil
+	}
+	now := time.Now()
+	for _, revert := range reverts {
+		if revert.Deadline == nil || !now.Before(*revert.Deadline) {
+			log.Warning("switch of service (%d) in cluster (%s) to template (%d) was not watched until its deadline, revert abandoned",
+				revert.ServiceId, revert.Cluster, revert.SwitchedTemplateId)
+			finishSwitchRevert(log, revert, svcmodel.SwitchRevertStateAbandoned, "wayne restarted during the grace period")
+			continue
+		}
+		go watchSwitchRevert(ctx, revert)
+	}
+	return nil
+}
+
+// watchSwitchRevert runs revertOnDrop until the deadline of revert and records its outcome.
+func watchSwitchRevert(ctx context.Context, revert *svcmodel.ServiceSwitchRevert) {
+	log := logging.FromContext(ctx).With(logging.FieldServiceId, revert.ServiceId).With(logging.FieldCluster, revert.Cluster)
+	defer recoverBackground(log, "revert switch", func(r interface{}) {
+		finishSwitchRevert(log, revert, svcmodel.SwitchRevertStateFailed, fmt.Sprintf("panic: %v", r))
+	})
+	state, message := revertOnDrop(logging.NewContext(ctx, log), revert)
+	finishSwitchRevert(log, revert, state, message)
+}
+
+func finishSwitchRevert(log *logging.Logger, revert *svcmodel.ServiceSwitchRevert, state string, message string) {
+	if revert.Id == 0 {
+		return
+	}
+	if _, err := svcmodel.ServiceSwitchRevertModel.Finish(revert, state, message); err != nil {
+		log.Error("record switch revert (%d) as %s error.%v", revert.Id, state, err)
+	}
+}
+
+// revertOnDrop publishes the previous template again if the ready endpoints of the switched Service
+// drop to zero before the deadline of revert. It returns the state and message to record.
+func revertOnDrop(ctx context.Context, revert *svcmodel.ServiceSwitchRevert) (string, string) {
+	log := logging.FromContext(ctx)
+	service, err := svcmodel.ServiceModel.GetById(revert.ServiceId)
+	if err != nil {
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	previous, err := svcmodel.ServiceTplModel.GetById(revert.PreviousTemplateId)
+	if err != nil {
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	switched, err := svcmodel.ServiceTplModel.GetById(revert.SwitchedTemplateId)
+	if err != nil {
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	kubeService, err := resources.ServiceFromTemplate(switched.Template, namespace.KubeNamespace)
+	if err != nil {
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	cli, err := resources.Client(revert.Cluster)
+	if err != nil {
+		log.Error("get client of cluster (%s) error.%v", revert.Cluster, err)
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	if !resources.WatchReadyEndpoints(ctx, cli, namespace.KubeNamespace, kubeService.Name, time.Until(*revert.Deadline)) {
+		return svcmodel.SwitchRevertStateKept, ""
+	}
+
+	log.Warning("service %s in cluster (%s) has no ready endpoints after switching to template (%d), revert to template (%d)",
+		kubeService.Name, revert.Cluster, switched.Id, previous.Id)
+	if err := publishServiceTemplate(ctx, service, previous, revert.Cluster, publishOptions{}); err != nil {
+		log.Error("revert service %s in cluster (%s) to template (%d) error.%v", kubeService.Name, revert.Cluster, previous.Id, err)
+		return svcmodel.SwitchRevertStateFailed, err.Error()
+	}
+	return svcmodel.SwitchRevertStateReverted, fmt.Sprintf("no ready endpoints, reverted to template %d", previous.Id)
+}
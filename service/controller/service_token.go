@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"github.com/Qihoo360/wayne/src/backend/controllers/base"
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+)
+
+// 服务 API Token 相关操作，用于 CI 等非交互场景
+type ServiceTokenController struct {
+	base.APIController
+}
+
+// service token scope -> permission action the issuer must hold
+var scopePermissions = map[string]string{
+	svcmodel.ServiceScopeRead:    models.PermissionRead,
+	svcmodel.ServiceScopeCreate:  models.PermissionCreate,
+	svcmodel.ServiceScopeUpdate:  models.PermissionUpdate,
+	svcmodel.ServiceScopeDelete:  models.PermissionDelete,
+	svcmodel.ServiceScopePublish: permissionPublish,
+}
+
+func (c *ServiceTokenController) URLMapping() {
+	c.Mapping("List", c.List)
+	c.Mapping("Create", c.Create)
+	c.Mapping("Revoke", c.Revoke)
+}
+
+func (c *ServiceTokenController) Prepare() {
+	prepareLogger(&c.APIController, "")
+	// Tokens can not be managed with tokens, always require a login session.
+	c.APIController.Prepare()
+	logUser(&c.APIController)
+	// Check permission
+	perAction := ""
+	_, method := c.GetControllerAndAction()
+	switch method {
+	case "List":
+		perAction = models.PermissionRead
+	case "Create", "Revoke":
+		perAction = models.PermissionUpdate
+	}
+	if perAction != "" {
+		c.CheckPermission(models.PermissionTypeService, perAction)
+	}
+}
+
+// @Title GetAll
+// @Description get all service tokens of the app
+// @Success 200 {object} []models.ServiceToken success
+// @router / [get]
+func (c *ServiceTokenController) List() {
+	tokens, err := svcmodel.ServiceTokenModel.ListByAppId(c.AppId)
+	if err != nil {
+		requestLog(c.Ctx).Error("list service tokens of app (%d) error. %v", c.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+
+	c.Success(tokens)
+}
+
+// @Title Create
+// @Description issue a service token, the plaintext token is only returned once
+// @Param	body		body 	models.ServiceToken	true		"The ServiceToken content"
+// @Success 200 return models.ServiceToken success
+// @router / [post]
+func (c *ServiceTokenController) Create() {
+	var token svcmodel.ServiceToken
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &token)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceToken"))
+	}
+	if token.Name == "" || len(token.ScopeList) == 0 {
+		abortError(&c.APIController, apierror.InvalidParam("ServiceToken"))
+	}
+	for _, scope := range token.ScopeList {
+		perAction, ok := scopePermissions[scope]
+		if !ok {
+			abortError(&c.APIController, apierror.InvalidParam("Scopes"))
+		}
+		// the issuer can only grant what they are allowed to do themselves
+		checkServicePermission(&c.APIController, perAction)
+	}
+
+	token.AppId = c.AppId
+	token.User = c.User.Name
+	_, err = svcmodel.ServiceTokenModel.Add(&token)
+	if err != nil {
+		requestLog(c.Ctx).Error("create service token error.%v", err.Error())
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(token)
+}
+
+// @Title Revoke
+// @Description revoke the service token
+// @Param	id		path 	int	true		"The id you want to revoke"
+// @Success 200 {string} revoke success!
+// @router /:id([0-9]+) [delete]
+func (c *ServiceTokenController) Revoke() {
+	id := c.GetIDFromURL()
+
+	token, err := svcmodel.ServiceTokenModel.GetById(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("get service token by id (%d) error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	if token.AppId != c.AppId {
+		abortError(&c.APIController, apierror.Forbidden("Service token does not belong to this app."))
+	}
+
+	err = svcmodel.ServiceTokenModel.Revoke(int64(id))
+	if err != nil {
+		requestLog(c.Ctx).Error("revoke service token %d error.%v", id, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(nil)
+}
@@ -1,16 +1,22 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"k8s.io/api/core/v1"
 
 	"github.com/Qihoo360/wayne/src/backend/controllers/base"
 	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
 	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
 	"github.com/Qihoo360/wayne/src/backend/util/hack"
-	"github.com/Qihoo360/wayne/src/backend/util/logs"
 )
 
 // 服务模版相关操作
@@ -24,16 +30,18 @@ func (c *ServiceTplController) URLMapping() {
 	c.Mapping("Get", c.Get)
 	c.Mapping("Update", c.Update)
 	c.Mapping("Delete", c.Delete)
+	c.Mapping("Promote", c.Promote)
+	c.Mapping("Lineage", c.Lineage)
+	c.Mapping("Lint", c.Lint)
+	c.Mapping("Publish", c.Publish)
 }
 
 func (c *ServiceTplController) Prepare() {
-	// Check administration
-	c.APIController.Prepare()
-	// Check permission
+	prepareLogger(&c.APIController, logging.FieldTemplateId)
 	perAction := ""
 	_, method := c.GetControllerAndAction()
 	switch method {
-	case "Get", "List":
+	case "Get", "List", "Lineage", "Lint":
 		perAction = models.PermissionRead
 	case "Create":
 		perAction = models.PermissionCreate
@@ -41,10 +49,11 @@ func (c *ServiceTplController) Prepare() {
 		perAction = models.PermissionUpdate
 	case "Delete":
 		perAction = models.PermissionDelete
+	case "Promote", "Publish":
+		perAction = permissionPublish
 	}
-	if perAction != "" {
-		c.CheckPermission(models.PermissionTypeService, perAction)
-	}
+	metrics.InstrumentRequest(c.Ctx, "ServiceTplController", method)
+	prepareServiceAccess(&c.APIController, perAction)
 }
 
 // @Title GetAll
@@ -53,6 +62,11 @@ func (c *ServiceTplController) Prepare() {
 // @Param	pageSize		query 	int	false		"the page size"
 // @Param	name		query 	string	false		"name filter"
 // @Param	deleted		query 	bool	false		"is deleted, default list all"
+// @Param	paging		query 	string	false		"cursor for cursor pagination, default pageNo/pageSize"
+// @Param	cursor		query 	string	false		"cursor returned by the previous page"
+// @Param	limit		query 	int	false		"cursor page size, default 20, max 100"
+// @Param	sort		query 	string	false		"id, createTime or updateTime, prefix - for descending, default -id"
+// @Param	skipTotal		query 	bool	false		"skip counting the total, cursor pagination only"
 // @Success 200 {object} []models.ServiceTemplate success
 // @router / [get]
 func (c *ServiceTplController) List() {
@@ -66,14 +80,44 @@ func (c *ServiceTplController) List() {
 
 	serviceId := c.Input().Get("serviceId")
 	if serviceId != "" {
+		id, err := strconv.ParseInt(serviceId, 10, 64)
+		if err != nil {
+			abortError(&c.APIController, apierror.InvalidParam("serviceId"))
+		}
+		appService(&c.APIController, id)
 		param.Query["service_id"] = serviceId
+	} else {
+		param.Query["service__app__id"] = c.AppId
+	}
+
+	if cursorPaging(&c.APIController) {
+		filters := map[string]interface{}{
+			"Deleted":          c.GetDeleteFromQuery(),
+			"Service__App__Id": c.AppId,
+		}
+		if name != "" {
+			filters["Name__contains"] = name
+		}
+		if serviceId != "" {
+			filters["Service__Id"] = serviceId
+		}
+		cursorQuery := cursorQueryFromInput(&c.APIController,
+			svcmodel.Sort{Key: svcmodel.SortKeyId, Desc: true}, svcmodel.ServiceTplListSortKeys...)
+		page, err := svcmodel.ServiceTplModel.ListByCursor(filters, isOnline, cursorQuery)
+		if err != nil {
+			requestLog(c.Ctx).Error("list by filters (%v) and cursor error. %v", filters, err)
+			abortError(&c.APIController, err)
+			return
+		}
+		c.Success(page)
+		return
 	}
 
 	var serviceTpls []models.ServiceTemplate
 	total, err := models.ListTemplate(&serviceTpls, param, models.TableNameServiceTemplate, models.PublishTypeService, isOnline)
 	if err != nil {
-		logs.Error("list by param (%v) error. %v", param, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("list by param (%v) error. %v", param, err)
+		abortError(&c.APIController, err)
 		return
 	}
 	for index, tpl := range serviceTpls {
@@ -92,33 +136,155 @@ func (c *ServiceTplController) Create() {
 	var serviceTpl models.ServiceTemplate
 	err := json.Unmarshal(c.Ctx.Input.RequestBody, &serviceTpl)
 	if err != nil {
-		logs.Error("get body error. %v", err)
-		c.AbortBadRequestFormat("ServiceTemplate")
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceTemplate"))
 	}
-	err = validServiceTemplate(serviceTpl.Template)
+	appService(&c.APIController, serviceTpl.ServiceId)
+	warnings, err := validServiceTemplate(templateContext{
+		AppId:  c.AppId,
+		User:   c.User,
+		Action: policy.ActionCreate,
+	}, serviceTpl.Template)
 	if err != nil {
-		logs.Error("valid template err %v", err)
-		c.AbortBadRequestFormat("KubeService")
+		abortInvalidServiceTemplate(&c.APIController, err)
 	}
+	nodePorts := checkNodePorts(&c.APIController, serviceTpl.ServiceId, serviceTpl.Template)
 
 	serviceTpl.User = c.User.Name
 
-	_, err = svcmodel.ServiceTplModel.Add(&serviceTpl)
+	err = svcmodel.ServiceTplModel.AddClaimingNodePorts(&serviceTpl, nodePorts)
 	if err != nil {
-		logs.Error("create error.%v", err.Error())
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("create error.%v", err.Error())
+		abortError(&c.APIController, err)
 		return
 	}
-	c.Success(serviceTpl)
+	c.Success(serviceTplResult{
+		ServiceTemplate: &serviceTpl,
+		Warnings:        warnings,
+		Clusters:        templateClusterWarnings(c.Ctx.Request.Context(), serviceTpl.ServiceId, serviceTpl.Template),
+	})
+}
+
+// serviceTplResult is a saved template along with the policy warnings it raised and
+// its problems in the clusters the service is live in.
+type serviceTplResult struct {
+	*models.ServiceTemplate
+	Warnings []lint.Violation            `json:"warnings,omitempty"`
+	Clusters map[string]*clusterWarnings `json:"clusters,omitempty"`
+}
+
+// templateClusterWarnings returns the problems of the template in the clusters the service is live in.
+// They are only warnings, failures to compute them are logged and do not fail the save.
+func templateClusterWarnings(ctx context.Context, serviceId int64, template string) map[string]*clusterWarnings {
+	service, err := svcmodel.ServiceModel.GetById(serviceId)
+	if err != nil {
+		logging.FromContext(ctx).Error("get service (%d) error.%v", serviceId, err)
+		return nil
+	}
+	warnings, err := liveClusterWarnings(ctx, service, template)
+	if err != nil {
+		logging.FromContext(ctx).Error("get cluster warnings of service (%d) error.%v", serviceId, err)
+		return nil
+	}
+	return warnings
+}
+
+type templateFormatError struct {
+	err error
+}
+
+func (e *templateFormatError) Error() string {
+	return fmt.Sprintf("service template format error.%v", e.err.Error())
+}
+
+// templateContext is who saves or publishes a template, in which app and for which action.
+type templateContext struct {
+	AppId  int64
+	User   *models.User
+	Action string
+	// Cluster is set when publishing.
+	Cluster string
 }
 
-func validServiceTemplate(serviceTplStr string) error {
+// validServiceTemplate checks that the template is a Service, lints it with the rule sets of the app
+// and evaluates the admission policies. Blocking violations are returned as a *lint.Result error and
+// policy denials as a *policy.Denied error, the lint warnings are returned otherwise.
+func validServiceTemplate(tc templateContext, serviceTplStr string) ([]lint.Violation, error) {
 	service := v1.Service{}
 	err := json.Unmarshal(hack.Slice(serviceTplStr), &service)
 	if err != nil {
-		return fmt.Errorf("service template format error.%v", err.Error())
+		metrics.ValidationFailed(metrics.ValidationReasonFormat)
+		return nil, &templateFormatError{err: err}
+	}
+
+	result, err := lintServiceTemplate(tc.AppId, &service)
+	if err != nil {
+		return nil, err
+	}
+	if result.Blocked {
+		for _, violation := range result.Blocking() {
+			metrics.ValidationFailed(violation.Rule)
+		}
+		return nil, result
+	}
+	if err := checkServicePolicies(tc, &service); err != nil {
+		if _, ok := err.(*policy.Denied); ok {
+			metrics.ValidationFailed(metrics.ValidationReasonPolicy)
+		}
+		return nil, err
+	}
+	return result.Warnings(), nil
+}
+
+func checkServicePolicies(tc templateContext, service *v1.Service) error {
+	modules, err := svcmodel.ServicePolicyModel.GetActiveModules()
+	if err != nil || len(modules) == 0 {
+		return err
+	}
+	input, err := policyInput(tc, service)
+	if err != nil {
+		return err
+	}
+	return policy.Check(context.TODO(), modules, input)
+}
+
+func policyInput(tc templateContext, service *v1.Service) (*policy.Input, error) {
+	app, err := models.AppModel.GetById(tc.AppId)
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := models.NamespaceModel.GetNamespaceByAppId(tc.AppId)
+	if err != nil {
+		return nil, err
+	}
+	return &policy.Input{
+		Service: service,
+		App: policy.App{
+			Id:        app.Id,
+			Name:      app.Name,
+			Namespace: namespace.KubeNamespace,
+		},
+		User: policy.User{
+			Name:  tc.User.Name,
+			Admin: tc.User.Admin,
+		},
+		Action:  tc.Action,
+		Cluster: tc.Cluster,
+	}, nil
+}
+
+func lintServiceTemplate(appId int64, service *v1.Service) (*lint.Result, error) {
+	rules, err := svcmodel.ServiceLintRuleSetModel.GetRules(appId)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return lint.Lint(service, rules)
+}
+
+// abortInvalidServiceTemplate responds to an error of validServiceTemplate.
+func abortInvalidServiceTemplate(c *base.APIController, err error) {
+	requestLog(c.Ctx).Error("valid template err %v", err)
+	abortError(c, err)
 }
 
 // @Title Get
@@ -127,15 +293,7 @@ func validServiceTemplate(serviceTplStr string) error {
 // @Success 200 {object} models.ServiceTemplate success
 // @router /:id([0-9]+) [get]
 func (c *ServiceTplController) Get() {
-	id := c.GetIDFromURL()
-
-	serviceTpl, err := svcmodel.ServiceTplModel.GetById(id)
-	if err != nil {
-		logs.Error("get template error %v", err)
-		c.HandleError(err)
-		return
-	}
-
+	serviceTpl, _ := appTemplate(&c.APIController, int64(c.GetIDFromURL()))
 	c.Success(serviceTpl)
 }
 
@@ -146,26 +304,64 @@ func (c *ServiceTplController) Get() {
 // @Success 200 models.ServiceTemplate success
 // @router /:id([0-9]+) [put]
 func (c *ServiceTplController) Update() {
-	id := c.GetIDFromURL()
+	current, _ := appTemplate(&c.APIController, int64(c.GetIDFromURL()))
 	var serviceTpl models.ServiceTemplate
 	err := json.Unmarshal(c.Ctx.Input.RequestBody, &serviceTpl)
 	if err != nil {
-		logs.Error("Invalid param body.%v", err)
-		c.AbortBadRequestFormat("ServiceTemplate")
+		requestLog(c.Ctx).Error("Invalid param body.%v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceTemplate"))
 	}
-	if err = validServiceTemplate(serviceTpl.Template); err != nil {
-		logs.Error("valid template err %v", err)
-		c.AbortBadRequestFormat("KubeService")
+	// the template stays in its service, whatever the body says
+	serviceTpl.Id = current.Id
+	serviceTpl.ServiceId = current.ServiceId
+	warnings, err := validServiceTemplate(templateContext{
+		AppId:  c.AppId,
+		User:   c.User,
+		Action: policy.ActionUpdate,
+	}, serviceTpl.Template)
+	if err != nil {
+		abortInvalidServiceTemplate(&c.APIController, err)
 	}
+	nodePorts := checkNodePorts(&c.APIController, serviceTpl.ServiceId, serviceTpl.Template)
 
-	serviceTpl.Id = int64(id)
-	err = svcmodel.ServiceTplModel.UpdateById(&serviceTpl)
+	err = svcmodel.ServiceTplModel.UpdateClaimingNodePorts(&serviceTpl, nodePorts)
 	if err != nil {
-		logs.Error("update error.%v", err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("update error.%v", err)
+		abortError(&c.APIController, err)
 		return
 	}
-	c.Success(serviceTpl)
+	c.Success(serviceTplResult{
+		ServiceTemplate: &serviceTpl,
+		Warnings:        warnings,
+		Clusters:        templateClusterWarnings(c.Ctx.Request.Context(), serviceTpl.ServiceId, serviceTpl.Template),
+	})
+}
+
+// @Title Lint
+// @Description dry-run the policy rules of the app against a ServiceTpl without saving it
+// @Param	body		body 	models.ServiceTemplate	true		"The ServiceTpl content"
+// @Success 200 {object} lint.Result success
+// @router /lint [post]
+func (c *ServiceTplController) Lint() {
+	var serviceTpl models.ServiceTemplate
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &serviceTpl)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ServiceTemplate"))
+	}
+	service := v1.Service{}
+	if err = json.Unmarshal(hack.Slice(serviceTpl.Template), &service); err != nil {
+		requestLog(c.Ctx).Error("valid template err %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("KubeService"))
+	}
+
+	result, err := lintServiceTemplate(c.AppId, &service)
+	if err != nil {
+		requestLog(c.Ctx).Error("lint template of app (%d) error.%v", c.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(result)
 }
 
 // @Title Delete
@@ -175,14 +371,26 @@ func (c *ServiceTplController) Update() {
 // @Success 200 {string} delete success!
 // @router /:id([0-9]+) [delete]
 func (c *ServiceTplController) Delete() {
-	id := c.GetIDFromURL()
+	tpl, _ := appTemplate(&c.APIController, int64(c.GetIDFromURL()))
 	logical := c.GetLogicalFromQuery()
 
-	err := svcmodel.ServiceTplModel.DeleteById(int64(id), logical)
+	// the templates of the service may all be logically deleted already
+	latest, err := svcmodel.ServiceTplModel.GetLatestTemplate(tpl.ServiceId)
+	if err != nil && !apierror.IsNotFound(err) {
+		requestLog(c.Ctx).Error("get latest template of service (%d) error.%v", tpl.ServiceId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	err = svcmodel.ServiceTplModel.DeleteById(tpl.Id, logical)
 	if err != nil {
-		logs.Error("delete %d error.%v", id, err)
-		c.HandleError(err)
+		requestLog(c.Ctx).Error("delete %d error.%v", tpl.Id, err)
+		abortError(&c.APIController, err)
 		return
 	}
+	if latest != nil && latest.Id == tpl.Id {
+		if err := reclaimNodePorts(c.Ctx.Request.Context(), tpl.ServiceId); err != nil {
+			requestLog(c.Ctx).Error("reclaim node ports of service (%d) error.%v", tpl.ServiceId, err)
+		}
+	}
 	c.Success(nil)
 }
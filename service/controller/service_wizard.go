@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	svcmodel "github.com/Qihoo360/wayne/src/backend/plugins/service/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/policy"
+)
+
+type wizardPort struct {
+	Name string `json:"name,omitempty"`
+	Port int32  `json:"port"`
+	// TargetPort defaults to Port.
+	TargetPort intstr.IntOrString `json:"targetPort,omitempty"`
+	// Protocol defaults to TCP.
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+}
+
+type externalServiceParam struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// ExternalName is the DNS name the Service resolves to, e.g. db.example.com.
+	ExternalName string       `json:"externalName"`
+	Ports        []wizardPort `json:"ports,omitempty"`
+	Description  string       `json:"description,omitempty"`
+}
+
+type headlessServiceParam struct {
+	Name     string            `json:"name"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Selector map[string]string `json:"selector,omitempty"`
+	Ports    []wizardPort      `json:"ports,omitempty"`
+	// PublishNotReadyAddresses publishes the DNS records of pods before they are ready, e.g. for peer discovery.
+	PublishNotReadyAddresses bool   `json:"publishNotReadyAddresses,omitempty"`
+	Description              string `json:"description,omitempty"`
+}
+
+type wizardResult struct {
+	Service  *models.Service         `json:"service"`
+	Template *models.ServiceTemplate `json:"template"`
+	Warnings []lint.Violation        `json:"warnings,omitempty"`
+}
+
+func (c *ServiceController) wizardPorts(ports []wizardPort) []v1.ServicePort {
+	servicePorts := make([]v1.ServicePort, 0, len(ports))
+	for _, port := range ports {
+		if port.Port <= 0 || port.Port > 65535 {
+			abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid port %d.", port.Port)))
+		}
+		servicePort := v1.ServicePort{
+			Name:       port.Name,
+			Port:       port.Port,
+			TargetPort: port.TargetPort,
+			Protocol:   port.Protocol,
+		}
+		if servicePort.Protocol == "" {
+			servicePort.Protocol = v1.ProtocolTCP
+		}
+		if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal == 0 {
+			servicePort.TargetPort = intstr.FromInt(int(port.Port))
+		}
+		servicePorts = append(servicePorts, servicePort)
+	}
+	return servicePorts
+}
+
+// createFromWizard validates and saves a Service and its first template generated by a wizard.
+func (c *ServiceController) createFromWizard(kubeService *v1.Service, description string) {
+	if errs := validation.IsDNS1035Label(kubeService.Name); len(errs) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid service name %s: %s", kubeService.Name, strings.Join(errs, ","))))
+	}
+	if _, err := svcmodel.ServiceModel.GetByName(c.AppId, kubeService.Name); err == nil {
+		abortError(&c.APIController, apierror.Conflict(fmt.Sprintf("Service %s already exists.", kubeService.Name)))
+	}
+
+	kubeService.APIVersion = "v1"
+	kubeService.Kind = "Service"
+	template, err := json.Marshal(kubeService)
+	if err != nil {
+		requestLog(c.Ctx).Error("marshal template error.%v", err)
+		abortError(&c.APIController, err)
+		return
+	}
+	warnings, err := validServiceTemplate(templateContext{
+		AppId:  c.AppId,
+		User:   c.User,
+		Action: policy.ActionCreate,
+	}, string(template))
+	if err != nil {
+		abortInvalidServiceTemplate(&c.APIController, err)
+	}
+
+	service := &models.Service{
+		Name:        kubeService.Name,
+		Description: description,
+		User:        c.User.Name,
+		AppId:       c.AppId,
+	}
+	tpl := &models.ServiceTemplate{
+		Name:        kubeService.Name,
+		Template:    string(template),
+		Description: description,
+		User:        c.User.Name,
+	}
+	if err := svcmodel.ServiceModel.AddWithTemplates(service, []*models.ServiceTemplate{tpl}); err != nil {
+		requestLog(c.Ctx).Error("create service %s in app (%d) error.%v", service.Name, c.AppId, err)
+		abortError(&c.APIController, err)
+		return
+	}
+	c.Success(wizardResult{Service: service, Template: tpl, Warnings: warnings})
+}
+
+// @Title CreateExternal
+// @Description create an ExternalName Service pointing at an external DNS name
+// @Param	body		body 	controller.externalServiceParam	true		"The name, DNS name and ports"
+// @Success 200 {object} controller.wizardResult success
+// @router /external [post]
+func (c *ServiceController) CreateExternal() {
+	var param externalServiceParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("ExternalService"))
+	}
+	if errs := lint.ValidateExternalName(param.ExternalName); len(errs) > 0 {
+		abortError(&c.APIController, apierror.Validation(fmt.Sprintf("Invalid external name %s: %s", param.ExternalName, strings.Join(errs, ","))))
+	}
+
+	kubeService := &v1.Service{}
+	kubeService.Name = param.Name
+	kubeService.Labels = param.Labels
+	kubeService.Spec.Type = v1.ServiceTypeExternalName
+	kubeService.Spec.ExternalName = param.ExternalName
+	kubeService.Spec.Ports = c.wizardPorts(param.Ports)
+	c.createFromWizard(kubeService, param.Description)
+}
+
+// @Title CreateHeadless
+// @Description create a headless Service, publishing the pod IPs in DNS instead of a cluster IP
+// @Param	body		body 	controller.headlessServiceParam	true		"The name, selector and ports"
+// @Success 200 {object} controller.wizardResult success
+// @router /headless [post]
+func (c *ServiceController) CreateHeadless() {
+	var param headlessServiceParam
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		requestLog(c.Ctx).Error("get body error. %v", err)
+		abortError(&c.APIController, apierror.InvalidParam("HeadlessService"))
+	}
+
+	kubeService := &v1.Service{}
+	kubeService.Name = param.Name
+	kubeService.Labels = param.Labels
+	kubeService.Spec.Type = v1.ServiceTypeClusterIP
+	kubeService.Spec.ClusterIP = v1.ClusterIPNone
+	kubeService.Spec.Selector = param.Selector
+	kubeService.Spec.Ports = c.wizardPorts(param.Ports)
+	kubeService.Spec.PublishNotReadyAddresses = param.PublishNotReadyAddresses
+	c.createFromWizard(kubeService, param.Description)
+}
@@ -1,5 +1,14 @@
 package service
 
 import (
+	"github.com/astaxie/beego"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/controller"
 	_ "github.com/Qihoo360/wayne/src/backend/plugins/service/routers"
 )
+
+func init() {
+	beego.AddAPPStartHook(controller.StartHealthSampler)
+	beego.AddAPPStartHook(controller.ResumeSwitchReverts)
+	beego.AddAPPStartHook(controller.StartLoadBalancerSweep)
+}
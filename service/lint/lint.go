@@ -0,0 +1,173 @@
+// Package lint checks Service templates against the platform policies configured for an app.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+type Severity string
+
+const (
+	// SeverityWarn violations are reported but the template is saved.
+	SeverityWarn Severity = "warn"
+	// SeverityBlock violations reject the template.
+	SeverityBlock Severity = "block"
+)
+
+// Violation is one policy failure of a template.
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return fmt.Sprintf("[%s] %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s", v.Rule, v.Field, v.Message)
+}
+
+// Rule checks one policy, the severity is decided by the rule set, not by the rule.
+type Rule interface {
+	Check(service *v1.Service) []Violation
+}
+
+// RuleFunc adapts a function to a Rule.
+type RuleFunc func(service *v1.Service) []Violation
+
+func (f RuleFunc) Check(service *v1.Service) []Violation {
+	return f(service)
+}
+
+// Factory builds a rule from the params of its rule set entry.
+type Factory func(params map[string]string) (Rule, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a rule available to rule sets under name.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("lint rule %s registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// RuleNames returns the names of all registered rules.
+func RuleNames() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RuleConfig enables a rule with a severity in a rule set.
+type RuleConfig struct {
+	Name     string            `json:"name"`
+	Severity Severity          `json:"severity"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// Validate checks that the rule exists and its params are accepted.
+func (c RuleConfig) Validate() error {
+	if c.Severity != SeverityWarn && c.Severity != SeverityBlock {
+		return fmt.Errorf("rule %s: unknown severity %q", c.Name, c.Severity)
+	}
+	factory, ok := factories[c.Name]
+	if !ok {
+		return fmt.Errorf("unknown rule %s", c.Name)
+	}
+	_, err := factory(c.Params)
+	return err
+}
+
+// Merge overlays the rule configs of more specific rule sets; later sets win for the same rule name.
+func Merge(sets ...[]RuleConfig) []RuleConfig {
+	index := map[string]int{}
+	merged := []RuleConfig{}
+	for _, set := range sets {
+		for _, config := range set {
+			if i, ok := index[config.Name]; ok {
+				merged[i] = config
+				continue
+			}
+			index[config.Name] = len(merged)
+			merged = append(merged, config)
+		}
+	}
+	return merged
+}
+
+// Result is the outcome of linting a template.
+type Result struct {
+	Violations []Violation `json:"violations"`
+	// Blocked is true if any violation has SeverityBlock.
+	Blocked bool `json:"blocked"`
+}
+
+// Blocking returns the violations which reject the template.
+func (r *Result) Blocking() []Violation {
+	blocking := []Violation{}
+	for _, v := range r.Violations {
+		if v.Severity == SeverityBlock {
+			blocking = append(blocking, v)
+		}
+	}
+	return blocking
+}
+
+// Warnings returns the violations which are only reported.
+func (r *Result) Warnings() []Violation {
+	warnings := []Violation{}
+	for _, v := range r.Violations {
+		if v.Severity == SeverityWarn {
+			warnings = append(warnings, v)
+		}
+	}
+	return warnings
+}
+
+// Error describes the blocking violations.
+func (r *Result) Error() string {
+	messages := []string{}
+	for _, v := range r.Blocking() {
+		messages = append(messages, v.String())
+	}
+	return fmt.Sprintf("service template violates policy: %s", strings.Join(messages, "; "))
+}
+
+// Lint checks service against the built-in type rules and the rules in configs.
+func Lint(service *v1.Service, configs []RuleConfig) (*Result, error) {
+	result := &Result{Violations: CheckType(service)}
+	for _, v := range result.Violations {
+		if v.Severity == SeverityBlock {
+			result.Blocked = true
+		}
+	}
+	for _, config := range configs {
+		factory, ok := factories[config.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %s", config.Name)
+		}
+		rule, err := factory(config.Params)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", config.Name, err)
+		}
+		for _, v := range rule.Check(service) {
+			v.Rule = config.Name
+			v.Severity = config.Severity
+			if v.Severity == SeverityBlock {
+				result.Blocked = true
+			}
+			result.Violations = append(result.Violations, v)
+		}
+	}
+	return result, nil
+}
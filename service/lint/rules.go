@@ -0,0 +1,160 @@
+package lint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	RuleLoadBalancerInternal = "loadbalancer-internal"
+	RuleNoExternalIPs        = "no-external-ips"
+	RuleRequiredLabels       = "required-labels"
+	RulePortNamePrefix       = "port-name-prefix"
+	RuleNodePortRange        = "nodeport-range"
+
+	DefaultNodePortMin = 30000
+	DefaultNodePortMax = 32767
+)
+
+func init() {
+	Register(RuleLoadBalancerInternal, newLoadBalancerInternal)
+	Register(RuleNoExternalIPs, newNoExternalIPs)
+	Register(RuleRequiredLabels, newRequiredLabels)
+	Register(RulePortNamePrefix, newPortNamePrefix)
+	Register(RuleNodePortRange, newNodePortRange)
+}
+
+// splitParam splits a comma separated param, falling back to def.
+func splitParam(params map[string]string, key string, def string) []string {
+	value := params[key]
+	if value == "" {
+		value = def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// NodePortRange returns the allowed node port range of the params of a nodeport-range rule.
+func NodePortRange(params map[string]string) (int32, int32, error) {
+	min, max := int64(DefaultNodePortMin), int64(DefaultNodePortMax)
+	var err error
+	if v := params["min"]; v != "" {
+		if min, err = strconv.ParseInt(v, 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("invalid min %s", v)
+		}
+	}
+	if v := params["max"]; v != "" {
+		if max, err = strconv.ParseInt(v, 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("invalid max %s", v)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("min %d is greater than max %d", min, max)
+	}
+	return int32(min), int32(max), nil
+}
+
+// loadbalancer-internal: LoadBalancer Services must carry one of the internal annotations.
+// params: annotations, comma separated annotation keys.
+func newLoadBalancerInternal(params map[string]string) (Rule, error) {
+	annotations := splitParam(params, "annotations", "service.beta.kubernetes.io/internal")
+	return RuleFunc(func(service *v1.Service) []Violation {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			return nil
+		}
+		for _, key := range annotations {
+			if _, ok := service.Annotations[key]; ok {
+				return nil
+			}
+		}
+		return []Violation{{
+			Field:   "metadata.annotations",
+			Message: fmt.Sprintf("LoadBalancer service requires one of the annotations %s", strings.Join(annotations, ", ")),
+		}}
+	}), nil
+}
+
+// no-external-ips: spec.externalIPs must be empty.
+func newNoExternalIPs(params map[string]string) (Rule, error) {
+	return RuleFunc(func(service *v1.Service) []Violation {
+		if len(service.Spec.ExternalIPs) == 0 {
+			return nil
+		}
+		return []Violation{{
+			Field:   "spec.externalIPs",
+			Message: "externalIPs are not allowed",
+		}}
+	}), nil
+}
+
+// required-labels: metadata.labels must contain the keys.
+// params: labels, comma separated label keys, default app,team.
+func newRequiredLabels(params map[string]string) (Rule, error) {
+	keys := splitParam(params, "labels", "app,team")
+	return RuleFunc(func(service *v1.Service) []Violation {
+		violations := []Violation{}
+		for _, key := range keys {
+			if service.Labels[key] == "" {
+				violations = append(violations, Violation{
+					Field:   "metadata.labels." + key,
+					Message: fmt.Sprintf("label %s is required", key),
+				})
+			}
+		}
+		return violations
+	}), nil
+}
+
+// port-name-prefix: every port name must start with one of the prefixes.
+// params: prefixes, comma separated, default http-,grpc-.
+func newPortNamePrefix(params map[string]string) (Rule, error) {
+	prefixes := splitParam(params, "prefixes", "http-,grpc-")
+	return RuleFunc(func(service *v1.Service) []Violation {
+		violations := []Violation{}
+		for i, port := range service.Spec.Ports {
+			matched := false
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(port.Name, prefix) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				violations = append(violations, Violation{
+					Field:   fmt.Sprintf("spec.ports[%d].name", i),
+					Message: fmt.Sprintf("port name %q must start with one of %s", port.Name, strings.Join(prefixes, ", ")),
+				})
+			}
+		}
+		return violations
+	}), nil
+}
+
+// nodeport-range: node ports must be within the allowed range.
+// params: min and max, default 30000-32767.
+func newNodePortRange(params map[string]string) (Rule, error) {
+	min, max, err := NodePortRange(params)
+	if err != nil {
+		return nil, err
+	}
+	return RuleFunc(func(service *v1.Service) []Violation {
+		violations := []Violation{}
+		for i, port := range service.Spec.Ports {
+			if port.NodePort != 0 && (port.NodePort < min || port.NodePort > max) {
+				violations = append(violations, Violation{
+					Field:   fmt.Sprintf("spec.ports[%d].nodePort", i),
+					Message: fmt.Sprintf("node port %d is out of the allowed range %d-%d", port.NodePort, min, max),
+				})
+			}
+		}
+		return violations
+	}), nil
+}
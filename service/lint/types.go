@@ -0,0 +1,156 @@
+package lint
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RuleServiceType is the built-in rule checking the fields each type of Service allows.
+// It runs for every template, before the rules of the rule sets.
+const RuleServiceType = "service-type"
+
+// IsHeadless reports whether service is headless, clusterIP None.
+func IsHeadless(service *v1.Service) bool {
+	return service.Spec.ClusterIP == v1.ClusterIPNone
+}
+
+// ValidateExternalName returns the problems of name as the externalName of a Service.
+func ValidateExternalName(name string) []string {
+	if name == "" {
+		return []string{"externalName is required"}
+	}
+	if net.ParseIP(name) != nil {
+		return []string{"externalName must be a DNS name, not an IP address"}
+	}
+	return validation.IsDNS1123Subdomain(strings.TrimSuffix(name, "."))
+}
+
+// CheckType checks the type-specific rules of headless, ExternalName and regular Services.
+func CheckType(service *v1.Service) []Violation {
+	switch {
+	case service.Spec.Type == v1.ServiceTypeExternalName:
+		return checkExternalName(service)
+	case IsHeadless(service):
+		return append(checkHeadless(service), checkIPFamilies(service)...)
+	}
+
+	violations := checkIPFamilies(service)
+	if len(service.Spec.Ports) == 0 {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.ports", "at least one port is required"))
+	}
+	if service.Spec.ExternalName != "" {
+		violations = append(violations, typeViolation(SeverityWarn, "spec.externalName",
+			"only used by ExternalName services, ignored"))
+	}
+	return violations
+}
+
+func checkExternalName(service *v1.Service) []Violation {
+	violations := []Violation{}
+	for _, message := range ValidateExternalName(service.Spec.ExternalName) {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.externalName", message))
+	}
+	if service.Spec.ClusterIP != "" || len(service.Spec.ClusterIPs) > 0 {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.clusterIP",
+			"ExternalName services have no cluster IP"))
+	}
+	if len(service.Spec.ExternalIPs) > 0 {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.externalIPs",
+			"ExternalName services can not have external IPs"))
+	}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ports.nodePort",
+				"ExternalName services can not allocate node ports"))
+		}
+	}
+	if len(service.Spec.IPFamilies) > 0 || service.Spec.IPFamilyPolicy != nil {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilies",
+			"ExternalName services have no IP families"))
+	}
+	if len(service.Spec.Selector) > 0 {
+		violations = append(violations, typeViolation(SeverityWarn, "spec.selector",
+			"ExternalName services do not select pods, the selector is ignored"))
+	}
+	return violations
+}
+
+// checkIPFamilies checks that ipFamilies and ipFamilyPolicy are consistent, whether the
+// cluster supports them is only known when publishing.
+func checkIPFamilies(service *v1.Service) []Violation {
+	violations := []Violation{}
+	families := service.Spec.IPFamilies
+	seen := map[v1.IPFamily]bool{}
+	for _, family := range families {
+		if family != v1.IPv4Protocol && family != v1.IPv6Protocol {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilies",
+				fmt.Sprintf("unknown IP family %q, must be IPv4 or IPv6", family)))
+		}
+		if seen[family] {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilies",
+				fmt.Sprintf("IP family %s is listed twice", family)))
+		}
+		seen[family] = true
+	}
+	if len(families) > 2 {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilies", "at most two IP families"))
+	}
+
+	policy := service.Spec.IPFamilyPolicy
+	switch {
+	case policy == nil:
+		if len(families) > 1 {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilyPolicy",
+				"PreferDualStack or RequireDualStack is required for two IP families"))
+		}
+	case *policy == v1.IPFamilyPolicySingleStack:
+		if len(families) > 1 {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilyPolicy",
+				"SingleStack services have one IP family"))
+		}
+	case *policy == v1.IPFamilyPolicyPreferDualStack, *policy == v1.IPFamilyPolicyRequireDualStack:
+	default:
+		violations = append(violations, typeViolation(SeverityBlock, "spec.ipFamilyPolicy",
+			fmt.Sprintf("unknown IP family policy %q", *policy)))
+	}
+	if len(service.Spec.ClusterIPs) > 0 && len(families) > 0 && len(service.Spec.ClusterIPs) > len(families) {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.clusterIPs",
+			"more cluster IPs than IP families"))
+	}
+	return violations
+}
+
+func checkHeadless(service *v1.Service) []Violation {
+	violations := []Violation{}
+	if service.Spec.Type != "" && service.Spec.Type != v1.ServiceTypeClusterIP {
+		violations = append(violations, typeViolation(SeverityBlock, "spec.type",
+			"headless services must be of type ClusterIP"))
+	}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			violations = append(violations, typeViolation(SeverityBlock, "spec.ports.nodePort",
+				"headless services can not allocate node ports"))
+		}
+	}
+	if len(service.Spec.ExternalIPs) > 0 {
+		violations = append(violations, typeViolation(SeverityWarn, "spec.externalIPs",
+			"headless services have no virtual IP, external IPs are not routed"))
+	}
+	if service.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		violations = append(violations, typeViolation(SeverityWarn, "spec.sessionAffinity",
+			"headless services have no virtual IP, session affinity is not applied"))
+	}
+	if len(service.Spec.Selector) == 0 {
+		violations = append(violations, typeViolation(SeverityWarn, "spec.selector",
+			"headless services without selector need manually managed endpoints"))
+	}
+	return violations
+}
+
+func typeViolation(severity Severity, field string, message string) Violation {
+	return Violation{Rule: RuleServiceType, Severity: severity, Field: field, Message: message}
+}
@@ -0,0 +1,231 @@
+// Package logging writes the logs of the service plugin as a message followed by key=value fields,
+// e.g. the request id, app, service and template ids, user and action of the request being handled.
+// The logger of a request travels in its context, down to the requests sent to the API servers.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/util/logs"
+)
+
+const (
+	// HeaderRequestId carries the request id from the client, back in the response and to the API servers.
+	HeaderRequestId = "X-Request-Id"
+
+	FieldRequestId  = "requestId"
+	FieldAppId      = "appId"
+	FieldServiceId  = "serviceId"
+	FieldTemplateId = "templateId"
+	FieldUser       = "user"
+	FieldAction     = "action"
+	FieldCluster    = "cluster"
+	FieldLatency    = "latencyMs"
+	FieldStatus     = "status"
+	FieldErrorClass = "errorClass"
+	// FieldQuery is the model method of a failed database operation, FieldQueryLatency its latency.
+	FieldQuery        = "query"
+	FieldQueryLatency = "queryLatencyMs"
+)
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger logs with fields. It is immutable, With returns a copy.
+type Logger struct {
+	fields []field
+}
+
+// New returns a logger with the key value pairs as fields.
+func New(keyValues ...interface{}) *Logger {
+	return (&Logger{}).withKeyValues(keyValues)
+}
+
+// With returns a logger with the field key set to value.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, 0, len(l.fields)+1)
+	for _, f := range l.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	return &Logger{fields: append(fields, field{key: key, value: value})}
+}
+
+// Get returns the value of the field key, nil if not set.
+func (l *Logger) Get(key string) interface{} {
+	for _, f := range l.fields {
+		if f.key == key {
+			return f.value
+		}
+	}
+	return nil
+}
+
+// RequestId returns the request id field, empty outside of requests.
+func (l *Logger) RequestId() string {
+	id, _ := l.Get(FieldRequestId).(string)
+	return id
+}
+
+func (l *Logger) Error(format string, v ...interface{}) {
+	logs.Error("%s", l.line(format, v))
+}
+
+func (l *Logger) Warning(format string, v ...interface{}) {
+	logs.Warning("%s", l.line(format, v))
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	logs.Info("%s", l.line(format, v))
+}
+
+// line formats the message and appends the fields, and the class and fields of the first error argument.
+func (l *Logger) line(format string, v []interface{}) string {
+	fields := l.fields
+	for _, arg := range v {
+		if err, ok := arg.(error); ok && err != nil {
+			var fieldsErr *FieldsError
+			if goerrors.As(err, &fieldsErr) {
+				fields = (&Logger{fields: fields}).withKeyValues(fieldsErr.keyValues).fields
+			}
+			fields = append(fields[:len(fields):len(fields)], field{key: FieldErrorClass, value: ErrorClass(err)})
+			break
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(format, v...))
+	for _, f := range fields {
+		b.WriteString(" ")
+		b.WriteString(f.key)
+		b.WriteString("=")
+		b.WriteString(quote(fmt.Sprint(f.value)))
+	}
+	return b.String()
+}
+
+func (l *Logger) withKeyValues(keyValues []interface{}) *Logger {
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		l = l.With(fmt.Sprint(keyValues[i]), keyValues[i+1])
+	}
+	return l
+}
+
+// FieldsError is an error carrying fields, logged with it by the logger of whoever handles it,
+// e.g. the model method and latency of a failed database operation.
+type FieldsError struct {
+	Err       error
+	keyValues []interface{}
+}
+
+// WithFields returns err carrying the key value pairs as fields. Errors carrying fields already are
+// returned as is, they keep the fields of where they failed first.
+func WithFields(err error, keyValues ...interface{}) error {
+	var fieldsErr *FieldsError
+	if err == nil || goerrors.As(err, &fieldsErr) {
+		return err
+	}
+	return &FieldsError{Err: err, keyValues: keyValues}
+}
+
+func (e *FieldsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldsError) Unwrap() error {
+	return e.Err
+}
+
+func quote(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// ErrorClass returns a coarse class of err to search logs by: timeout, the code of the error in the
+// apierror taxonomy, or the type of the error for unclassified errors.
+func ErrorClass(err error) string {
+	if errors.IsTimeout(err) || errors.IsServerTimeout(err) || err == context.DeadlineExceeded {
+		return "timeout"
+	}
+	if e := apierror.From(err); e.Code != apierror.CodeInternal {
+		return e.Code
+	}
+	if fieldsErr, ok := err.(*FieldsError); ok {
+		return ErrorClass(fieldsErr.Err)
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// StatusClass returns the class of a failed response status, empty for successful ones.
+func StatusClass(status int) string {
+	if status < http.StatusBadRequest {
+		return ""
+	}
+	return apierror.CodeOf(status)
+}
+
+// NewRequestId returns a random request id.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of ctx, a logger without fields if there is none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return &Logger{}
+}
+
+// Detach returns a context for work outliving the request of ctx, which keeps its logger but
+// is not canceled with it.
+func Detach(ctx context.Context) context.Context {
+	return NewContext(context.Background(), FromContext(ctx))
+}
+
+type requestIdTransport struct {
+	rt http.RoundTripper
+}
+
+// Transport wraps rt to send the request id of the logger of the request context in the
+// X-Request-Id header, so API server audit logs can be matched with the plugin logs.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return &requestIdTransport{rt: rt}
+}
+
+func (t *requestIdTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context()).RequestId()
+	if id == "" || req.Header.Get(HeaderRequestId) != "" {
+		return t.rt.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderRequestId, id)
+	return t.rt.RoundTrip(req)
+}
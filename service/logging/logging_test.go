@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLineWithFieldsError(t *testing.T) {
+	err := WithFields(errors.New("bad connection"),
+		FieldServiceId, 12, FieldQuery, "serviceModel.GetById", FieldQueryLatency, 3)
+	l := New(FieldRequestId, "abc", FieldServiceId, "12", FieldUser, "admin")
+
+	line := l.line("get service (%d) error.%v", []interface{}{12, err})
+	want := "get service (12) error.bad connection requestId=abc user=admin serviceId=12 " +
+		"query=serviceModel.GetById queryLatencyMs=3 errorClass=*errors.errorString"
+	if line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+	if strings.Contains(l.line("%s", nil), "query") {
+		t.Errorf("fields of the error leaked into the logger")
+	}
+}
+
+func TestWithFieldsKeepsFirstFields(t *testing.T) {
+	inner := WithFields(errors.New("bad connection"), FieldQuery, "serviceTplModel.GetLatestTemplate")
+	outer := WithFields(fmt.Errorf("reclaim: %w", inner), FieldQuery, "serviceNodePortModel.Claim")
+	var fieldsErr *FieldsError
+	if !errors.As(outer, &fieldsErr) || fieldsErr != inner {
+		t.Errorf("got %v, want the fields of the first failure", fieldsErr)
+	}
+	if WithFields(nil, FieldQuery, "serviceModel.GetById") != nil {
+		t.Errorf("got an error for no error")
+	}
+}
@@ -0,0 +1,165 @@
+// Package metrics holds the Prometheus metrics of the service plugin, all under the
+// wayne_service_plugin namespace. They are registered to Registry, of the plugin only.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const Namespace = "wayne_service_plugin"
+
+const (
+	PublishResultSuccess = "success"
+	PublishResultFailure = "failure"
+
+	ValidationReasonFormat           = "format"
+	ValidationReasonPolicy           = "policy"
+	ValidationReasonNodePortConflict = "nodeport-conflict"
+	ValidationReasonIPFamily         = "ip-family"
+)
+
+var (
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests handled, by controller, handler and status code.",
+	}, []string{"controller", "handler", "code"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time until the response header was written, by controller and handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"controller", "handler"})
+
+	ValidationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "validation_failures_total",
+		Help:      "Templates rejected, by reason: the blocking lint rule, policy, format, nodeport-conflict or ip-family.",
+	}, []string{"reason"})
+
+	PublishResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "publish_results_total",
+		Help:      "Templates published, by cluster and result.",
+	}, []string{"cluster", "result"})
+
+	DriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "drift_detected_total",
+		Help:      "Live Services found drifted from their template, by cluster.",
+	}, []string{"cluster"})
+
+	DriftedFields = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "drifted_fields_total",
+		Help:      "Drifted fields found in live Services, by cluster.",
+	}, []string{"cluster"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of the database operations of the plugin models, by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+// Registry holds the metrics of the plugin, apart from those of the process.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		ValidationFailures,
+		PublishResults,
+		DriftDetected,
+		DriftedFields,
+		DBQueryDuration)
+}
+
+// ObserveDBQuery observes the duration of a database operation started at start, meant to be deferred:
+//
+//	defer metrics.ObserveDBQuery("GetById", time.Now())
+func ObserveDBQuery(operation string, start time.Time) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObservePublish counts a publish to cluster by its outcome.
+func ObservePublish(cluster string, err error) {
+	result := PublishResultSuccess
+	if err != nil {
+		result = PublishResultFailure
+	}
+	PublishResults.WithLabelValues(cluster, result).Inc()
+}
+
+// ObserveDrift counts the drifted fields of a live Service in cluster.
+func ObserveDrift(cluster string, drifted []string) {
+	if len(drifted) == 0 {
+		return
+	}
+	DriftDetected.WithLabelValues(cluster).Inc()
+	DriftedFields.WithLabelValues(cluster).Add(float64(len(drifted)))
+}
+
+// ValidationFailed counts a template rejected for reason.
+func ValidationFailed(reason string) {
+	ValidationFailures.WithLabelValues(reason).Inc()
+}
+
+// InstrumentRequest counts the request and observes its latency once the response header is written,
+// which also covers the requests aborted by the controller.
+func InstrumentRequest(ctx *context.Context, controller string, handler string) {
+	ctx.ResponseWriter.ResponseWriter = &instrumentedWriter{
+		ResponseWriter: ctx.ResponseWriter.ResponseWriter,
+		controller:     controller,
+		handler:        handler,
+		start:          time.Now(),
+	}
+}
+
+type instrumentedWriter struct {
+	http.ResponseWriter
+	controller string
+	handler    string
+	start      time.Time
+	observed   bool
+}
+
+func (w *instrumentedWriter) observe(code int) {
+	if w.observed {
+		return
+	}
+	w.observed = true
+	RequestsTotal.WithLabelValues(w.controller, w.handler, strconv.Itoa(code)).Inc()
+	RequestDuration.WithLabelValues(w.controller, w.handler).Observe(time.Since(w.start).Seconds())
+}
+
+func (w *instrumentedWriter) WriteHeader(code int) {
+	w.observe(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *instrumentedWriter) Write(data []byte) (int, error) {
+	w.observe(http.StatusOK)
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush keeps server-sent event streams working.
+func (w *instrumentedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+)
+
+const (
+	DefaultCursorLimit = 20
+	MaxCursorLimit     = 100
+)
+
+type sortKind int
+
+const (
+	sortKindInt sortKind = iota
+	sortKindTime
+)
+
+// SortKey is a whitelisted sort parameter and the orm field it maps to.
+type SortKey struct {
+	Param string
+	Field string
+	kind  sortKind
+}
+
+var (
+	SortKeyId         = SortKey{Param: "id", Field: "Id", kind: sortKindInt}
+	SortKeyOrder      = SortKey{Param: "order", Field: "OrderId", kind: sortKindInt}
+	SortKeyCreateTime = SortKey{Param: "createTime", Field: "CreateTime", kind: sortKindTime}
+	SortKeyUpdateTime = SortKey{Param: "updateTime", Field: "UpdateTime", kind: sortKindTime}
+)
+
+// Sort is a validated sort order, rows are always ordered by (Key, Id) so the order is stable.
+type Sort struct {
+	Key  SortKey
+	Desc bool
+}
+
+// ParseSort validates a sort parameter like "updateTime" or "-updateTime" against allowed.
+// An empty parameter returns def.
+func ParseSort(param string, def Sort, allowed ...SortKey) (Sort, error) {
+	if param == "" {
+		return def, nil
+	}
+	sort := Sort{}
+	if strings.HasPrefix(param, "-") {
+		sort.Desc = true
+		param = param[1:]
+	}
+	for _, key := range allowed {
+		if key.Param == param {
+			sort.Key = key
+			return sort, nil
+		}
+	}
+	return sort, fmt.Errorf("sort by %s is not supported", param)
+}
+
+// String returns the sort parameter of s, e.g. "-updateTime".
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + s.Key.Param
+	}
+	return s.Key.Param
+}
+
+func (s Sort) orderBy() []string {
+	prefix := ""
+	if s.Desc {
+		prefix = "-"
+	}
+	if s.Key.Field == SortKeyId.Field {
+		return []string{prefix + "Id"}
+	}
+	return []string{prefix + s.Key.Field, prefix + "Id"}
+}
+
+// Cursor is the opaque position after the last returned row.
+type Cursor struct {
+	Value string `json:"v,omitempty"`
+	// Null is set when the sort value of the row is NULL, e.g. a service never updated.
+	Null bool  `json:"null,omitempty"`
+	Id   int64 `json:"id"`
+	// Sort is the sort the cursor was returned for, a cursor can not be reused with another sort.
+	Sort string `json:"s,omitempty"`
+}
+
+// NewCursor builds the cursor of a row. value must be an int64 or a *time.Time matching the sort key.
+func NewCursor(value interface{}, id int64) *Cursor {
+	cursor := &Cursor{Id: id}
+	switch v := value.(type) {
+	case int64:
+		cursor.Value = strconv.FormatInt(v, 10)
+	case *time.Time:
+		if v == nil {
+			cursor.Null = true
+		} else {
+			cursor.Value = v.Format(time.RFC3339Nano)
+		}
+	case nil:
+		cursor.Null = true
+	}
+	return cursor
+}
+
+// cursor builds the cursor of a row listed in the order of s, see NewCursor.
+func (s Sort) cursor(value interface{}, id int64) *Cursor {
+	cursor := NewCursor(value, id)
+	cursor.Sort = s.String()
+	return cursor
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Encode. An empty string returns nil.
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	cursor := &Cursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return cursor, nil
+}
+
+// ParseCursorLimit returns the page size for limit, falling back to DefaultCursorLimit.
+func ParseCursorLimit(limit string) int {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return DefaultCursorLimit
+	}
+	if n > MaxCursorLimit {
+		return MaxCursorLimit
+	}
+	return n
+}
+
+// CheckCursor checks that cursor was returned for the sort s and that its value is one of the sort key.
+// The errors are validation failures of the cursor param.
+func (s Sort) CheckCursor(cursor *Cursor) error {
+	if cursor == nil {
+		return nil
+	}
+	if cursor.Sort != "" && cursor.Sort != s.String() {
+		return apierror.InvalidParam("cursor")
+	}
+	if cursor.Null || s.Key.Field == SortKeyId.Field {
+		// the rows after the cursor are selected by its id only
+		return nil
+	}
+	_, err := s.value(cursor)
+	return err
+}
+
+// value parses the sort value of cursor.
+func (s Sort) value(cursor *Cursor) (interface{}, error) {
+	switch s.Key.kind {
+	case sortKindInt:
+		v, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return nil, apierror.InvalidParam("cursor")
+		}
+		return v, nil
+	case sortKindTime:
+		v, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, apierror.InvalidParam("cursor")
+		}
+		return v, nil
+	}
+	return nil, apierror.InvalidParam("cursor")
+}
+
+// condition returns the keyset condition selecting the rows after cursor.
+// MySQL orders NULL before any value, so NULLs come first ascending and last descending.
+func (s Sort) condition(cursor *Cursor) (*orm.Condition, error) {
+	op := "__gt"
+	if s.Desc {
+		op = "__lt"
+	}
+	cond := orm.NewCondition()
+	if s.Key.Field == SortKeyId.Field {
+		return cond.And("Id"+op, cursor.Id), nil
+	}
+	isNull := s.Key.Field + "__isnull"
+	if cursor.Null {
+		afterNull := orm.NewCondition().And(isNull, true).And("Id"+op, cursor.Id)
+		if s.Desc {
+			return afterNull, nil
+		}
+		return afterNull.Or(isNull, false), nil
+	}
+
+	value, err := s.value(cursor)
+	if err != nil {
+		return nil, err
+	}
+	cond = cond.And(s.Key.Field+op, value).
+		OrCond(orm.NewCondition().And(s.Key.Field, value).And("Id"+op, cursor.Id))
+	if s.Desc {
+		cond = cond.Or(isNull, true)
+	}
+	return cond, nil
+}
+
+// CursorPage is a page of a cursor paginated list.
+type CursorPage struct {
+	List       interface{} `json:"list"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	// Total is omitted when the caller asked to skip counting.
+	Total *int64 `json:"total,omitempty"`
+}
+
+// CursorQuery describes one page of a cursor paginated list.
+type CursorQuery struct {
+	Sort   Sort
+	Cursor *Cursor
+	Limit  int
+	// SkipTotal skips the count query, CursorPage.Total is left empty.
+	SkipTotal bool
+}
+
+// querySeter orders qs by the sort key and restricts it to the rows after the cursor.
+// One extra row is fetched so the caller can tell whether there is a next page.
+func (q *CursorQuery) querySeter(qs orm.QuerySeter) (orm.QuerySeter, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultCursorLimit
+	}
+	if q.Cursor != nil {
+		cond, err := q.Sort.condition(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		qs = andCond(qs, cond)
+	}
+	return qs.OrderBy(q.Sort.orderBy()...).Limit(q.Limit + 1), nil
+}
+
+// andCond adds cond to the conditions set on qs by Filter, SetCond alone would replace them.
+func andCond(qs orm.QuerySeter, cond *orm.Condition) orm.QuerySeter {
+	if cond == nil || cond.IsEmpty() {
+		return qs
+	}
+	if cur := qs.GetCond(); cur != nil {
+		return qs.SetCond(cur.AndCond(cond))
+	}
+	return qs.SetCond(cond)
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+)
+
+func TestSortCheckCursor(t *testing.T) {
+	now := time.Now()
+	byUpdateTime := Sort{Key: SortKeyUpdateTime, Desc: true}
+	byOrder := Sort{Key: SortKeyOrder}
+	tests := []struct {
+		name   string
+		sort   Sort
+		cursor *Cursor
+		valid  bool
+	}{
+		{name: "no cursor", sort: byUpdateTime, valid: true},
+		{name: "same sort", sort: byUpdateTime, cursor: byUpdateTime.cursor(&now, 7), valid: true},
+		{name: "null value", sort: byUpdateTime, cursor: byUpdateTime.cursor((*time.Time)(nil), 7), valid: true},
+		{name: "other direction", sort: Sort{Key: SortKeyUpdateTime}, cursor: byUpdateTime.cursor(&now, 7)},
+		{name: "other key", sort: byOrder, cursor: Sort{Key: SortKeyId}.cursor(int64(7), 7)},
+		{name: "invalid value", sort: byOrder, cursor: &Cursor{Value: "2020-01-01T00:00:00Z", Id: 7}},
+		{name: "former cursor", sort: byOrder, cursor: &Cursor{Value: "3", Id: 7}, valid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.sort.CheckCursor(test.cursor)
+			if test.valid {
+				if err != nil {
+					t.Fatalf("got %v, want a valid cursor", err)
+				}
+				return
+			}
+			if e, ok := err.(*apierror.Error); !ok || e.Code != apierror.CodeValidation {
+				t.Fatalf("got %v, want a validation failure of the cursor", err)
+			}
+		})
+	}
+}
+
+func TestSortConditionInvalidCursor(t *testing.T) {
+	_, err := Sort{Key: SortKeyCreateTime}.condition(&Cursor{Value: "42", Id: 7})
+	if e, ok := err.(*apierror.Error); !ok || e.Status != http.StatusBadRequest {
+		t.Fatalf("got %v, want a validation failure of the cursor", err)
+	}
+}
@@ -0,0 +1,413 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const (
+	DependencyKindService     = "Service"
+	DependencyKindDeployment  = "Deployment"
+	DependencyKindStatefulset = "StatefulSet"
+	DependencyKindConfigMap   = "ConfigMap"
+	DependencyKindIngress     = "Ingress"
+
+	// the service's selector matches the workload's pod labels
+	DependencyReasonSelects = "selects"
+	// the config references the service by its cluster DNS name
+	DependencyReasonDNS = "dns"
+	// the config or ingress references the service by name in the same namespace
+	DependencyReasonName = "name"
+)
+
+type DependencyNode struct {
+	Kind      string `json:"kind"`
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	AppId     int64  `json:"appId"`
+	AppName   string `json:"appName,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (n DependencyNode) key() string {
+	return fmt.Sprintf("%s/%d", n.Kind, n.Id)
+}
+
+type DependencyEdge struct {
+	// From and To are node keys in the form "Kind/id"
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// DependencyGraph describes which workloads a Service selects and which resources depend on it.
+type DependencyGraph struct {
+	Nodes []DependencyNode `json:"nodes"`
+	Edges []DependencyEdge `json:"edges"`
+}
+
+// Readable returns the graph without the nodes of apps outside of appIds and their edges.
+// The first node, the service the graph is about, is always kept.
+func (g *DependencyGraph) Readable(appIds []int64) *DependencyGraph {
+	readable := make(map[int64]bool, len(appIds))
+	for _, id := range appIds {
+		readable[id] = true
+	}
+	result := &DependencyGraph{Nodes: []DependencyNode{}, Edges: []DependencyEdge{}}
+	kept := make(map[string]bool)
+	for i, node := range g.Nodes {
+		if i == 0 || readable[node.AppId] {
+			result.Nodes = append(result.Nodes, node)
+			kept[node.key()] = true
+		}
+	}
+	for _, edge := range g.Edges {
+		if kept[edge.From] && kept[edge.To] {
+			result.Edges = append(result.Edges, edge)
+		}
+	}
+	return result
+}
+
+func (g *DependencyGraph) add(node DependencyNode, edge DependencyEdge) {
+	for _, n := range g.Nodes {
+		if n.key() == node.key() {
+			g.Edges = append(g.Edges, edge)
+			return
+		}
+	}
+	g.Nodes = append(g.Nodes, node)
+	g.Edges = append(g.Edges, edge)
+}
+
+// workloadTemplate is the part of a workload template used to match a Service selector.
+type workloadTemplate struct {
+	Spec struct {
+		Template struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+func podLabels(tpl string) (labels.Set, error) {
+	var w workloadTemplate
+	if err := json.Unmarshal(hack.Slice(tpl), &w); err != nil {
+		return nil, err
+	}
+	return labels.Set(w.Spec.Template.Metadata.Labels), nil
+}
+
+// GetLatestTemplate returns the newest template of the service that is not deleted.
+func (*serviceTplModel) GetLatestTemplate(serviceId int64) (_ *ServiceTemplate, err error) {
+	defer observeQuery("serviceTplModel.GetLatestTemplate", time.Now(), &err, logging.FieldServiceId, serviceId)
+	tpl := &ServiceTemplate{}
+	err = Ormer().
+		QueryTable(new(ServiceTemplate)).
+		Filter("Service__Id", serviceId).
+		Filter("Deleted", false).
+		OrderBy("-Id").
+		One(tpl)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("template of service %d", serviceId))
+	}
+	tpl.ServiceId = serviceId
+	return tpl, nil
+}
+
+func (*serviceModel) node(service *Service, namespace string) DependencyNode {
+	return DependencyNode{
+		Kind:      DependencyKindService,
+		Id:        service.Id,
+		Name:      service.Name,
+		AppId:     service.App.Id,
+		AppName:   service.App.Name,
+		Namespace: namespace,
+	}
+}
+
+// GetDependencies returns the graph of the service: the workloads it selects and the
+// resources of all apps referencing it.
+func (m *serviceModel) GetDependencies(id int64) (_ *DependencyGraph, err error) {
+	defer observeQuery("serviceModel.GetDependencies", time.Now(), &err, logging.FieldServiceId, id)
+	service, namespace, err := m.getWithNamespace(id)
+	if err != nil {
+		return nil, err
+	}
+	graph := &DependencyGraph{Nodes: []DependencyNode{m.node(service, namespace)}, Edges: []DependencyEdge{}}
+
+	selected, err := m.selectedWorkloads(service, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range selected {
+		graph.add(node, DependencyEdge{From: graph.Nodes[0].key(), To: node.key(), Reason: DependencyReasonSelects})
+	}
+
+	dependents, err := m.GetDependents(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, edge := range dependents.Edges {
+		for _, node := range dependents.Nodes {
+			if node.key() == edge.From {
+				graph.add(node, edge)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// GetDependents answers "who depends on me": configs and ingresses of any app which
+// reference the service by its DNS name, or by its name within the same namespace.
+func (m *serviceModel) GetDependents(id int64) (_ *DependencyGraph, err error) {
+	defer observeQuery("serviceModel.GetDependents", time.Now(), &err, logging.FieldServiceId, id)
+	service, namespace, err := m.getWithNamespace(id)
+	if err != nil {
+		return nil, err
+	}
+	self := m.node(service, namespace)
+	graph := &DependencyGraph{Nodes: []DependencyNode{self}, Edges: []DependencyEdge{}}
+
+	dnsPattern := dnsReferencePattern(service.Name, namespace)
+	namePattern := regexp.MustCompile(fmt.Sprintf(`(^|[^a-z0-9.-])%s([^a-z0-9.-]|$)`, regexp.QuoteMeta(service.Name)))
+
+	configTpls := []*ConfigMapTemplate{}
+	qs := Ormer().
+		QueryTable(new(ConfigMapTemplate)).
+		Filter("Deleted", false).
+		Filter("ConfigMap__Deleted", false)
+	if err := latestTemplates(qs, "ConfigMap", "ConfigMap__App__Namespace", &configTpls); err != nil {
+		return nil, err
+	}
+	for _, tpl := range configTpls {
+		app := tpl.ConfigMap.App
+		node := DependencyNode{
+			Kind:      DependencyKindConfigMap,
+			Id:        tpl.ConfigMap.Id,
+			Name:      tpl.ConfigMap.Name,
+			AppId:     app.Id,
+			AppName:   app.Name,
+			Namespace: app.Namespace.KubeNamespace,
+		}
+		switch {
+		case dnsPattern.MatchString(tpl.Template):
+			graph.add(node, DependencyEdge{From: node.key(), To: self.key(), Reason: DependencyReasonDNS})
+		case node.Namespace == namespace && namePattern.MatchString(tpl.Template):
+			graph.add(node, DependencyEdge{From: node.key(), To: self.key(), Reason: DependencyReasonName})
+		}
+	}
+
+	ingressTpls := []*IngressTemplate{}
+	qs = Ormer().
+		QueryTable(new(IngressTemplate)).
+		Filter("Deleted", false).
+		Filter("Ingress__Deleted", false)
+	if err := latestTemplates(qs, "Ingress", "Ingress__App__Namespace", &ingressTpls); err != nil {
+		return nil, err
+	}
+	for _, tpl := range ingressTpls {
+		app := tpl.Ingress.App
+		if app.Namespace.KubeNamespace != namespace {
+			// ingress backends can only point at services in their own namespace
+			continue
+		}
+		if !ingressReferences(tpl.Template, service.Name) {
+			continue
+		}
+		node := DependencyNode{
+			Kind:      DependencyKindIngress,
+			Id:        tpl.Ingress.Id,
+			Name:      tpl.Ingress.Name,
+			AppId:     app.Id,
+			AppName:   app.Name,
+			Namespace: namespace,
+		}
+		graph.add(node, DependencyEdge{From: node.key(), To: self.key(), Reason: DependencyReasonName})
+	}
+
+	return graph, nil
+}
+
+func (m *serviceModel) getWithNamespace(id int64) (*Service, string, error) {
+	service := &Service{Id: id}
+	if err := Ormer().Read(service); err != nil {
+		return nil, "", apierror.Query(err, fmt.Sprintf("service %d", id))
+	}
+	if _, err := Ormer().LoadRelated(service, "App"); err != nil {
+		return nil, "", err
+	}
+	service.AppId = service.App.Id
+	ns, err := NamespaceModel.GetNamespaceByAppId(service.AppId)
+	if err != nil {
+		return nil, "", err
+	}
+	return service, ns.KubeNamespace, nil
+}
+
+// selectedWorkloads returns the workloads in the service's namespace whose pod labels
+// match the selector of the service's latest template. A service without template selects nothing.
+func (*serviceModel) selectedWorkloads(service *Service, namespace string) ([]DependencyNode, error) {
+	tpl, err := ServiceTplModel.GetLatestTemplate(service.Id)
+	if apierror.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kubeService := v1.Service{}
+	if err := json.Unmarshal(hack.Slice(tpl.Template), &kubeService); err != nil {
+		return nil, err
+	}
+	if len(kubeService.Spec.Selector) == 0 {
+		// selector-less services manage their endpoints by hand
+		return nil, nil
+	}
+	selector := labels.SelectorFromSet(kubeService.Spec.Selector)
+	nodes := []DependencyNode{}
+
+	deploymentTpls := []*DeploymentTemplate{}
+	qs := Ormer().
+		QueryTable(new(DeploymentTemplate)).
+		Filter("Deleted", false).
+		Filter("Deployment__Deleted", false).
+		Filter("Deployment__App__Namespace__KubeNamespace", namespace)
+	if err := latestTemplates(qs, "Deployment", "Deployment__App", &deploymentTpls); err != nil {
+		return nil, err
+	}
+	for _, tpl := range deploymentTpls {
+		set, err := podLabels(tpl.Template)
+		if err != nil || !selector.Matches(set) {
+			continue
+		}
+		nodes = append(nodes, DependencyNode{
+			Kind:      DependencyKindDeployment,
+			Id:        tpl.Deployment.Id,
+			Name:      tpl.Deployment.Name,
+			AppId:     tpl.Deployment.App.Id,
+			AppName:   tpl.Deployment.App.Name,
+			Namespace: namespace,
+		})
+	}
+
+	statefulsetTpls := []*StatefulsetTemplate{}
+	qs = Ormer().
+		QueryTable(new(StatefulsetTemplate)).
+		Filter("Deleted", false).
+		Filter("Statefulset__Deleted", false).
+		Filter("Statefulset__App__Namespace__KubeNamespace", namespace)
+	if err := latestTemplates(qs, "Statefulset", "Statefulset__App", &statefulsetTpls); err != nil {
+		return nil, err
+	}
+	for _, tpl := range statefulsetTpls {
+		set, err := podLabels(tpl.Template)
+		if err != nil || !selector.Matches(set) {
+			continue
+		}
+		nodes = append(nodes, DependencyNode{
+			Kind:      DependencyKindStatefulset,
+			Id:        tpl.Statefulset.Id,
+			Name:      tpl.Statefulset.Name,
+			AppId:     tpl.Statefulset.App.Id,
+			AppName:   tpl.Statefulset.App.Name,
+			Namespace: namespace,
+		})
+	}
+
+	return nodes, nil
+}
+
+// latestTemplates loads into container the newest template of each resource matched by qs, with related
+// loaded. Only the ids of the older templates are read, not their bodies.
+func latestTemplates(qs orm.QuerySeter, resourceField string, related string, container interface{}) error {
+	var rows []orm.ParamsList
+	if _, err := qs.ValuesList(&rows, "Id", resourceField); err != nil {
+		return err
+	}
+	latest := make(map[int64]int64)
+	for _, row := range rows {
+		id, resource := paramInt64(row[0]), paramInt64(row[1])
+		if id > latest[resource] {
+			latest[resource] = id
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(latest))
+	for _, id := range latest {
+		ids = append(ids, id)
+	}
+	_, err := qs.
+		Filter("Id__in", ids).
+		RelatedSel(related).
+		All(container)
+	return err
+}
+
+func paramInt64(v interface{}) int64 {
+	switch value := v.(type) {
+	case int64:
+		return value
+	case string:
+		id, _ := strconv.ParseInt(value, 10, 64)
+		return id
+	}
+	id, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+	return id
+}
+
+// dnsReferencePattern matches the DNS names of the Service name in namespace: name.namespace,
+// name.namespace.svc and name.namespace.svc.cluster.local. The name must not go on with another
+// label, web.prod.example.com is not the Service web of namespace prod.
+func dnsReferencePattern(name string, namespace string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`(^|[^a-z0-9.-])%s\.%s(\.svc(\.cluster\.local\.?)?)?([^a-z0-9.-]|$)`,
+		regexp.QuoteMeta(name), regexp.QuoteMeta(namespace)))
+}
+
+// ingressReferences reports whether any backend of the ingress template points at name,
+// supporting both the extensions/v1beta1 (serviceName) and networking/v1 (service.name) forms.
+func ingressReferences(tpl string, name string) bool {
+	var obj interface{}
+	if err := json.Unmarshal(hack.Slice(tpl), &obj); err != nil {
+		return false
+	}
+	var walk func(v interface{}) bool
+	walk = func(v interface{}) bool {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if value["serviceName"] == name {
+				return true
+			}
+			if svc, ok := value["service"].(map[string]interface{}); ok && svc["name"] == name {
+				return true
+			}
+			for _, child := range value {
+				if walk(child) {
+					return true
+				}
+			}
+		case []interface{}:
+			for _, child := range value {
+				if walk(child) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return walk(obj)
+}
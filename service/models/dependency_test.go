@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestDNSReferencePattern(t *testing.T) {
+	pattern := dnsReferencePattern("web", "prod")
+	tests := []struct {
+		text  string
+		match bool
+	}{
+		{"http://web.prod:8080/api", true},
+		{"web.prod", true},
+		{"host: web.prod.svc", true},
+		{"web.prod.svc.cluster.local:80", true},
+		{"web.prod.svc.cluster.local.", true},
+		{`"upstream": "web.prod.svc.cluster.local"`, true},
+		{"web.prod.example.com", false},
+		{"https://web.prod.example.com/", false},
+		{"web.prod.svc.example.com", false},
+		{"api.web.prod", false},
+		{"myweb.prod", false},
+		{"web.production", false},
+	}
+	for _, test := range tests {
+		if match := pattern.MatchString(test.text); match != test.match {
+			t.Errorf("%q matched %v, want %v", test.text, match, test.match)
+		}
+	}
+}
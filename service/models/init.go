@@ -5,13 +5,44 @@ import (
 )
 
 var (
-	ServiceModel    *serviceModel
-	ServiceTplModel *serviceTplModel
+	ServiceModel             *serviceModel
+	ServiceTplModel          *serviceTplModel
+	ServiceTokenModel        *serviceTokenModel
+	ServiceEnvironmentModel  *serviceEnvironmentModel
+	ServiceLintRuleSetModel  *serviceLintRuleSetModel
+	ServicePolicyModel       *servicePolicyModel
+	ServiceCanaryModel       *serviceCanaryModel
+	ServiceEndpointsTplModel *serviceEndpointsTplModel
+	ServiceNodePortModel     *serviceNodePortModel
+	ServiceLoadBalancerModel *serviceLoadBalancerModel
+	ServiceHealthModel       *serviceHealthModel
+	ServiceSwitchRevertModel *serviceSwitchRevertModel
 )
 
 func init() {
-	orm.RegisterModel()
+	orm.RegisterModel(
+		new(ServiceToken),
+		new(ServiceEnvironment),
+		new(ServiceTemplateLineage),
+		new(ServiceLintRuleSet),
+		new(ServicePolicy),
+		new(ServiceCanary),
+		new(ServiceEndpointsTemplate),
+		new(ServiceNodePort),
+		new(ServiceLoadBalancer),
+		new(ServiceHealthSample),
+		new(ServiceSwitchRevert))
 
 	ServiceModel = &serviceModel{}
 	ServiceTplModel = &serviceTplModel{}
+	ServiceTokenModel = &serviceTokenModel{}
+	ServiceEnvironmentModel = &serviceEnvironmentModel{}
+	ServiceLintRuleSetModel = &serviceLintRuleSetModel{}
+	ServicePolicyModel = &servicePolicyModel{}
+	ServiceCanaryModel = &serviceCanaryModel{}
+	ServiceEndpointsTplModel = &serviceEndpointsTplModel{}
+	ServiceNodePortModel = &serviceNodePortModel{}
+	ServiceLoadBalancerModel = &serviceLoadBalancerModel{}
+	ServiceHealthModel = &serviceHealthModel{}
+	ServiceSwitchRevertModel = &serviceSwitchRevertModel{}
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/metrics"
+)
+
+// observeQuery observes the duration of the database operation started at start. Its failure carries
+// the operation, its latency and the key value pairs as fields, logged by the caller with the logger
+// of its request. It is deferred with the error result of the model method:
+//
+//	defer observeQuery("serviceModel.GetById", time.Now(), &err, logging.FieldServiceId, id)
+//
+// Records not found are left to the callers as is.
+func observeQuery(operation string, start time.Time, err *error, keyValues ...interface{}) {
+	metrics.ObserveDBQuery(operation, start)
+	if *err == nil || apierror.IsNotFound(*err) {
+		return
+	}
+	*err = logging.WithFields(*err, append(keyValues,
+		logging.FieldQuery, operation,
+		logging.FieldQueryLatency, time.Since(start).Nanoseconds()/int64(time.Millisecond))...)
+}
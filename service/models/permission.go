@@ -0,0 +1,197 @@
+package models
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+)
+
+// PermittedAppIds returns the ids of the apps in which the user holds perAction on services.
+// Admins are not special-cased here, callers should skip the filter for them.
+func PermittedAppIds(userId int64, perAction string) ([]int64, error) {
+	perName := PermissionModel.MergeName(PermissionTypeService, perAction)
+
+	var list orm.ParamsList
+	_, err := Ormer().
+		QueryTable(new(App)).
+		Filter("Deleted", false).
+		Filter("AppUsers__User__Id__exact", userId).
+		Filter("AppUsers__Group__Permissions__Permission__Name__contains", perName).
+		Distinct().
+		ValuesFlat(&list, "Id")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(list))
+	for _, id := range list {
+		ids = append(ids, id.(int64))
+	}
+	return ids, nil
+}
+
+// HasAppPermission reports whether the user holds perAction on perType in the app,
+// e.g. models.PermissionTypeKubeService and models.PermissionCreate to publish there.
+func HasAppPermission(userId int64, appId int64, perType string, perAction string) (bool, error) {
+	count, err := Ormer().
+		QueryTable(new(AppUser)).
+		Filter("App__Id", appId).
+		Filter("User__Id", userId).
+		Filter("Group__Permissions__Permission__Name", PermissionModel.MergeName(perType, perAction)).
+		Count()
+	return count > 0, err
+}
+
+type permittedAppEntry struct {
+	ids         []int64
+	fingerprint string
+	expire      time.Time
+}
+
+// permittedAppCache caches PermittedAppIds per user and action.
+//
+// An entry is dropped when the user's app memberships, their groups or the permissions of
+// those groups change (detected by a fingerprint query), when it expires, or when Invalidate
+// or InvalidateAll is called. It holds at most size entries, expired ones are evicted first.
+type permittedAppCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*permittedAppEntry
+
+	// fingerprint and load are PermissionFingerprint and PermittedAppIds, replaced in benchmarks.
+	fingerprint func(userId int64) (string, error)
+	load        func(userId int64, perAction string) ([]int64, error)
+}
+
+var PermittedAppCache = newPermittedAppCache(
+	time.Duration(beego.AppConfig.DefaultInt("ServicePermissionCacheTTL", 60))*time.Second,
+	beego.AppConfig.DefaultInt("ServicePermissionCacheSize", 10000))
+
+func newPermittedAppCache(ttl time.Duration, size int) *permittedAppCache {
+	return &permittedAppCache{
+		ttl:         ttl,
+		size:        size,
+		entries:     make(map[string]*permittedAppEntry),
+		fingerprint: PermissionFingerprint,
+		load:        PermittedAppIds,
+	}
+}
+
+func permittedAppKey(userId int64, perAction string) string {
+	return fmt.Sprintf("%d/%s", userId, perAction)
+}
+
+// PermissionFingerprint changes whenever an app membership of the user is added or removed,
+// moved to another group, or a permission is granted to or revoked from one of its groups.
+func PermissionFingerprint(userId int64) (string, error) {
+	var memberships []orm.ParamsList
+	_, err := Ormer().
+		QueryTable(new(AppUser)).
+		Filter("User__Id", userId).
+		OrderBy("Id").
+		ValuesList(&memberships, "Id", "Group")
+	if err != nil {
+		return "", err
+	}
+	if len(memberships) == 0 {
+		return "", nil
+	}
+	groupIds := make([]interface{}, 0, len(memberships))
+	for _, membership := range memberships {
+		groupIds = append(groupIds, membership[1])
+	}
+	var grants []orm.ParamsList
+	_, err = Ormer().
+		QueryTable(new(Group)).
+		Filter("Id__in", groupIds...).
+		OrderBy("Id", "Permissions__Permission__Id").
+		ValuesList(&grants, "Id", "Permissions__Permission__Id")
+	if err != nil {
+		return "", err
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprint(hash, memberships, grants)
+	return strconv.FormatUint(hash.Sum64(), 16), nil
+}
+
+// Get returns the ids of the apps in which the user holds perAction on services.
+func (c *permittedAppCache) Get(userId int64, perAction string) ([]int64, error) {
+	key := permittedAppKey(userId, perAction)
+	fingerprint, err := c.fingerprint(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok && entry.fingerprint == fingerprint && time.Now().Before(entry.expire) {
+		return entry.ids, nil
+	}
+
+	ids, err := c.load(userId, perAction)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[key] = &permittedAppEntry{
+		ids:         ids,
+		fingerprint: fingerprint,
+		expire:      time.Now().Add(c.ttl),
+	}
+	c.mu.Unlock()
+	return ids, nil
+}
+
+// evict makes room for one entry: it drops the expired entries, or the one expiring first
+// if none is. c.mu must be held.
+func (c *permittedAppCache) evict() {
+	now := time.Now()
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.expire) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expire.Before(oldest) {
+			oldestKey, oldest = key, entry.expire
+		}
+	}
+	if len(c.entries) >= c.size && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// Invalidate drops the cached apps of the user, e.g. after its groups or memberships changed.
+func (c *permittedAppCache) Invalidate(userId int64) {
+	prefix := fmt.Sprintf("%d/", userId)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// InvalidateAll drops every cached entry, e.g. after the permissions of a group changed.
+func (c *permittedAppCache) InvalidateAll() {
+	c.mu.Lock()
+	c.entries = make(map[string]*permittedAppEntry)
+	c.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+)
+
+func stubPermittedAppCache(size int, fingerprint *string, loads *int) *permittedAppCache {
+	c := newPermittedAppCache(time.Minute, size)
+	c.fingerprint = func(userId int64) (string, error) {
+		return *fingerprint, nil
+	}
+	c.load = func(userId int64, perAction string) ([]int64, error) {
+		*loads++
+		return []int64{userId}, nil
+	}
+	return c
+}
+
+func TestPermittedAppCache(t *testing.T) {
+	fingerprint := "a"
+	loads := 0
+	c := stubPermittedAppCache(2, &fingerprint, &loads)
+
+	c.Get(1, PermissionRead)
+	c.Get(1, PermissionRead)
+	if loads != 1 {
+		t.Fatalf("cached entry loaded %d times, want 1", loads)
+	}
+
+	fingerprint = "b"
+	c.Get(1, PermissionRead)
+	if loads != 2 {
+		t.Fatalf("changed fingerprint loaded %d times, want 2", loads)
+	}
+
+	c.Invalidate(1)
+	c.Get(1, PermissionRead)
+	if loads != 3 {
+		t.Fatalf("invalidated entry loaded %d times, want 3", loads)
+	}
+
+	c.Get(2, PermissionRead)
+	c.Get(3, PermissionRead)
+	if len(c.entries) != 2 {
+		t.Fatalf("cache holds %d entries, want at most 2", len(c.entries))
+	}
+
+	c.InvalidateAll()
+	if len(c.entries) != 0 {
+		t.Fatalf("cache holds %d entries after InvalidateAll", len(c.entries))
+	}
+}
+
+// BenchmarkPermittedAppCacheHit is the cost of a List of a non-admin user once the apps are cached,
+// without the fingerprint query.
+func BenchmarkPermittedAppCacheHit(b *testing.B) {
+	fingerprint := "a"
+	loads := 0
+	c := stubPermittedAppCache(10000, &fingerprint, &loads)
+	c.Get(1, PermissionRead)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Get(1, PermissionRead)
+		}
+	})
+}
+
+// The benchmarks below compare the former join query of List with the IN filter on a seeded wayne
+// database. They are skipped unless SERVICE_BENCH_DSN (a MySQL DSN) and SERVICE_BENCH_USER (the id
+// of a non-admin user with many apps) are set:
+//
+//	SERVICE_BENCH_DSN='root:root@tcp(127.0.0.1:3306)/wayne?charset=utf8' SERVICE_BENCH_USER=42 \
+//		go test -run '^$' -bench List ./models/
+var registerBenchDB sync.Once
+
+func benchUser(b *testing.B) int64 {
+	dsn := os.Getenv("SERVICE_BENCH_DSN")
+	userId, _ := strconv.ParseInt(os.Getenv("SERVICE_BENCH_USER"), 10, 64)
+	if dsn == "" || userId == 0 {
+		b.Skip("SERVICE_BENCH_DSN and SERVICE_BENCH_USER are not set")
+	}
+	var err error
+	registerBenchDB.Do(func() {
+		err = orm.RegisterDataBase("default", "mysql", dsn)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return userId
+}
+
+func BenchmarkListJoin(b *testing.B) {
+	userId := benchUser(b)
+	perName := PermissionModel.MergeName(PermissionTypeService, PermissionRead)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		services := []Service{}
+		_, err := Ormer().
+			QueryTable(new(Service)).
+			Filter("Deleted", false).
+			Filter("App__AppUsers__User__Id__exact", userId).
+			Filter("App__AppUsers__Group__Permissions__Permission__Name__contains", perName).
+			GroupBy("Id").
+			OrderBy("-Id").
+			Limit(DefaultCursorLimit).
+			All(&services)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchListIn(b *testing.B, cache *permittedAppCache) {
+	userId := benchUser(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		appIds, err := cache.Get(userId, PermissionRead)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(appIds) == 0 {
+			b.Fatalf("user %d can not read any app", userId)
+		}
+		services := []Service{}
+		_, err = Ormer().
+			QueryTable(new(Service)).
+			Filter("Deleted", false).
+			Filter("App__Id__in", appIds).
+			OrderBy("-Id").
+			Limit(DefaultCursorLimit).
+			All(&services)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkListInCached resolves the apps from the cache, paying the fingerprint query only.
+func BenchmarkListInCached(b *testing.B) {
+	benchListIn(b, newPermittedAppCache(time.Hour, 10))
+}
+
+// BenchmarkListInUncached resolves the apps on every List.
+func BenchmarkListInUncached(b *testing.B) {
+	benchListIn(b, newPermittedAppCache(0, 10))
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"k8s.io/api/core/v1"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const (
+	// upper bound of services scanned for one search page, the returned cursor
+	// continues from where the scan stopped
+	maxSearchScan   = 5000
+	searchBatchSize = 200
+)
+
+var ServiceSearchSortKeys = []SortKey{SortKeyId, SortKeyCreateTime, SortKeyUpdateTime}
+
+// ServiceSearchQuery holds the filters of a Service search. Empty fields do not filter.
+// The Service filters apply to the Service, the template filters to its latest template.
+type ServiceSearchQuery struct {
+	// AppIds limits the search to these apps, nil means all apps.
+	AppIds []int64
+	Name   string
+	// Label matches the selector of the latest template, either "key" or "key=value".
+	Label string
+	Port  int32
+	Type  v1.ServiceType
+	// Annotation matches metadata.annotations of the latest template, either "key" or "key=value".
+	Annotation string
+	// Creator is the user who created the Service.
+	Creator string
+	// Description matches the description of the Service or of its latest template.
+	Description   string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+
+	Sort   Sort
+	Cursor *Cursor
+	Limit  int
+}
+
+// ServiceSearchResult is a Service found by a search and the latest template it matched with,
+// nil if the Service has no template and the query has no template filter.
+type ServiceSearchResult struct {
+	*Service
+	Template *ServiceTemplate `json:"template,omitempty"`
+}
+
+func splitKeyValue(s string) (string, string, bool) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1], true
+	}
+	return parts[0], "", false
+}
+
+func matchKeyValue(m map[string]string, expr string) bool {
+	key, value, hasValue := splitKeyValue(expr)
+	v, ok := m[key]
+	if !ok {
+		return false
+	}
+	return !hasValue || v == value
+}
+
+// matches applies the filters which need the latest template of service, tpl is nil if it has none.
+func (q *ServiceSearchQuery) matches(service *Service, tpl *ServiceTemplate) bool {
+	if q.Description != "" {
+		description := strings.ToLower(q.Description)
+		if !strings.Contains(strings.ToLower(service.Description), description) &&
+			(tpl == nil || !strings.Contains(strings.ToLower(tpl.Description), description)) {
+			return false
+		}
+	}
+	if q.Label == "" && q.Port == 0 && q.Type == "" && q.Annotation == "" {
+		return true
+	}
+	if tpl == nil {
+		return false
+	}
+	kubeService := v1.Service{}
+	if err := json.Unmarshal(hack.Slice(tpl.Template), &kubeService); err != nil {
+		return false
+	}
+	if q.Label != "" && !matchKeyValue(kubeService.Spec.Selector, q.Label) {
+		return false
+	}
+	if q.Annotation != "" && !matchKeyValue(kubeService.Annotations, q.Annotation) {
+		return false
+	}
+	if q.Type != "" {
+		serviceType := kubeService.Spec.Type
+		if serviceType == "" {
+			serviceType = v1.ServiceTypeClusterIP
+		}
+		if serviceType != q.Type {
+			return false
+		}
+	}
+	if q.Port != 0 {
+		found := false
+		for _, port := range kubeService.Spec.Ports {
+			if port.Port == q.Port || port.NodePort == q.Port || port.TargetPort.String() == strconv.Itoa(int(q.Port)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ServiceSearchQuery) querySeter(after *Cursor) (orm.QuerySeter, error) {
+	qs := Ormer().
+		QueryTable(new(Service)).
+		Filter("Deleted", false)
+
+	if q.AppIds != nil {
+		qs = qs.Filter("App__Id__in", q.AppIds)
+	}
+	if q.Name != "" {
+		qs = qs.Filter("Name__contains", q.Name)
+	}
+	if q.Creator != "" {
+		qs = qs.Filter("User", q.Creator)
+	}
+	if q.CreatedAfter != nil {
+		qs = qs.Filter("CreateTime__gte", q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		qs = qs.Filter("CreateTime__lt", q.CreatedBefore)
+	}
+	if q.UpdatedAfter != nil {
+		qs = qs.Filter("UpdateTime__gte", q.UpdatedAfter)
+	}
+	if q.UpdatedBefore != nil {
+		qs = qs.Filter("UpdateTime__lt", q.UpdatedBefore)
+	}
+
+	if after != nil {
+		cond, err := q.Sort.condition(after)
+		if err != nil {
+			return nil, err
+		}
+		qs = andCond(qs, cond)
+	}
+	return qs.RelatedSel("App").OrderBy(q.Sort.orderBy()...), nil
+}
+
+// latestServiceTemplates returns the latest template of each of services by service id.
+func latestServiceTemplates(services []*Service) (map[int64]*ServiceTemplate, error) {
+	latest := make(map[int64]*ServiceTemplate, len(services))
+	if len(services) == 0 {
+		return latest, nil
+	}
+	ids := make([]int64, 0, len(services))
+	for _, service := range services {
+		ids = append(ids, service.Id)
+	}
+	qs := Ormer().
+		QueryTable(new(ServiceTemplate)).
+		Filter("Deleted", false).
+		Filter("Service__Id__in", ids)
+	tpls := []*ServiceTemplate{}
+	if err := latestTemplates(qs, "Service", "Service", &tpls); err != nil {
+		return nil, err
+	}
+	for _, tpl := range tpls {
+		tpl.ServiceId = tpl.Service.Id
+		latest[tpl.ServiceId] = tpl
+	}
+	return latest, nil
+}
+
+// Search returns the Services matching q in the order of q.Sort, each with its latest template.
+// Template filters are applied while scanning, so a page can be short; follow NextCursor until
+// it is empty.
+func (*serviceModel) Search(q *ServiceSearchQuery) (_ *CursorPage, err error) {
+	defer observeQuery("serviceModel.Search", time.Now(), &err)
+	if q.Limit <= 0 {
+		q.Limit = DefaultCursorLimit
+	}
+	result := make([]*ServiceSearchResult, 0, q.Limit)
+	page := &CursorPage{List: result}
+	if q.AppIds != nil && len(q.AppIds) == 0 {
+		return page, nil
+	}
+
+	cursor := q.Cursor
+	scanned := 0
+	for scanned < maxSearchScan {
+		qs, err := q.querySeter(cursor)
+		if err != nil {
+			return nil, err
+		}
+		batch := []*Service{}
+		if _, err := qs.Limit(searchBatchSize).All(&batch); err != nil {
+			return nil, err
+		}
+		latest, err := latestServiceTemplates(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, service := range batch {
+			scanned++
+			cursor = serviceCursor(q.Sort, service)
+			tpl := latest[service.Id]
+			if !q.matches(service, tpl) {
+				continue
+			}
+			service.AppId = service.App.Id
+			result = append(result, &ServiceSearchResult{Service: service, Template: tpl})
+			if len(result) == q.Limit {
+				page.List = result
+				page.NextCursor = cursor.Encode()
+				return page, nil
+			}
+		}
+		if len(batch) < searchBatchSize {
+			page.List = result
+			return page, nil
+		}
+	}
+
+	// scan budget exhausted, let the client continue after the last scanned row
+	page.List = result
+	page.NextCursor = cursor.Encode()
+	return page, nil
+}
@@ -5,13 +5,21 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
 
 	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
 )
 
 type serviceModel struct{}
 
-func (*serviceModel) GetNames(filters map[string]interface{}) ([]Service, error) {
+var ServiceListSortKeys = []SortKey{SortKeyId, SortKeyOrder, SortKeyCreateTime, SortKeyUpdateTime}
+
+func (*serviceModel) GetNames(filters map[string]interface{}) (_ []Service, err error) {
+	defer observeQuery("serviceModel.GetNames", time.Now(), &err)
 	services := []Service{}
 	qs := Ormer().
 		QueryTable(new(Service))
@@ -22,7 +30,7 @@ func (*serviceModel) GetNames(filters map[string]interface{}) ([]Service, error)
 		}
 	}
 
-	_, err := qs.All(&services, "Id", "Name")
+	_, err = qs.All(&services, "Id", "Name")
 
 	if err != nil {
 		return nil, err
@@ -32,13 +40,15 @@ func (*serviceModel) GetNames(filters map[string]interface{}) ([]Service, error)
 }
 
 func (*serviceModel) Add(m *Service) (id int64, err error) {
+	defer observeQuery("serviceModel.Add", time.Now(), &err, logging.FieldAppId, m.AppId)
 	m.App = &App{Id: m.AppId}
 	m.CreateTime = nil
 	id, err = Ormer().Insert(m)
-	return
+	return id, apierror.Query(err, fmt.Sprintf("service %s", m.Name))
 }
 
-func (*serviceModel) UpdateOrders(services []*Service) error {
+func (*serviceModel) UpdateOrders(services []*Service) (err error) {
+	defer observeQuery("serviceModel.UpdateOrders", time.Now(), &err)
 	if len(services) < 1 {
 		return errors.New("services' length should greater than 0. ")
 	}
@@ -50,14 +60,15 @@ func (*serviceModel) UpdateOrders(services []*Service) error {
 	}
 	batchUpateSql = fmt.Sprintf("%s END WHERE `id` IN (%s)", batchUpateSql, strings.Join(ids, ","))
 
-	_, err := Ormer().Raw(batchUpateSql).Exec()
+	_, err = Ormer().Raw(batchUpateSql).Exec()
 	return err
 }
 
 func (*serviceModel) UpdateById(m *Service) (err error) {
+	defer observeQuery("serviceModel.UpdateById", time.Now(), &err, logging.FieldServiceId, m.Id)
 	v := Service{Id: m.Id}
 	// ascertain id exists in the database
-	if err = Ormer().Read(&v); err == nil {
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("service %d", m.Id)); err == nil {
 		m.UpdateTime = nil
 		m.App = &App{Id: m.AppId}
 		_, err = Ormer().Update(m)
@@ -67,19 +78,38 @@ func (*serviceModel) UpdateById(m *Service) (err error) {
 }
 
 func (*serviceModel) GetById(id int64) (v *Service, err error) {
+	defer observeQuery("serviceModel.GetById", time.Now(), &err, logging.FieldServiceId, id)
 	v = &Service{Id: id}
 
-	if err = Ormer().Read(v); err == nil {
+	if err = apierror.Query(Ormer().Read(v), fmt.Sprintf("service %d", id)); err == nil {
 		v.AppId = v.App.Id
 		return v, nil
 	}
 	return nil, err
 }
 
+// GetByName returns the service named name in the app.
+func (*serviceModel) GetByName(appId int64, name string) (v *Service, err error) {
+	defer observeQuery("serviceModel.GetByName", time.Now(), &err, logging.FieldAppId, appId)
+	v = &Service{}
+	err = Ormer().
+		QueryTable(new(Service)).
+		Filter("App__Id", appId).
+		Filter("Name", name).
+		Filter("Deleted", false).
+		One(v)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("service %s", name))
+	}
+	v.AppId = appId
+	return v, nil
+}
+
 func (*serviceModel) DeleteById(id int64, logical bool) (err error) {
+	defer observeQuery("serviceModel.DeleteById", time.Now(), &err, logging.FieldServiceId, id)
 	v := Service{Id: id}
 	// ascertain id exists in the database
-	if err = Ormer().Read(&v); err == nil {
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("service %d", id)); err == nil {
 		if logical {
 			v.Deleted = true
 			_, err = Ormer().Update(&v)
@@ -90,3 +120,89 @@ func (*serviceModel) DeleteById(id int64, logical bool) (err error) {
 	}
 	return
 }
+
+func serviceCursor(sort Sort, service *Service) *Cursor {
+	switch sort.Key.Field {
+	case SortKeyOrder.Field:
+		return sort.cursor(service.OrderId, service.Id)
+	case SortKeyCreateTime.Field:
+		return sort.cursor(service.CreateTime, service.Id)
+	case SortKeyUpdateTime.Field:
+		return sort.cursor(service.UpdateTime, service.Id)
+	}
+	return sort.cursor(service.Id, service.Id)
+}
+
+// ListByCursor returns one page of the services matching filters, ordered by (q.Sort, id).
+func (*serviceModel) ListByCursor(filters map[string]interface{}, q *CursorQuery) (_ *CursorPage, err error) {
+	defer observeQuery("serviceModel.ListByCursor", time.Now(), &err)
+	qs := Ormer().QueryTable(new(Service))
+	for k, v := range filters {
+		qs = qs.Filter(k, v)
+	}
+
+	page := &CursorPage{}
+	if !q.SkipTotal {
+		total, err := qs.Count()
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	qs, err = q.querySeter(qs)
+	if err != nil {
+		return nil, err
+	}
+	services := []Service{}
+	if _, err = qs.All(&services); err != nil {
+		return nil, err
+	}
+	if len(services) > q.Limit {
+		services = services[:q.Limit]
+		page.NextCursor = serviceCursor(q.Sort, &services[q.Limit-1]).Encode()
+	}
+	for key, one := range services {
+		services[key].AppId = one.App.Id
+	}
+	page.List = services
+	return page, nil
+}
+
+// AddWithTemplates inserts target and tpls attached to it in one transaction.
+// target.Id and the ids of tpls are updated to the inserted rows. The node ports of tpls are not
+// claimed, tpls must not set any.
+func (*serviceModel) AddWithTemplates(target *Service, tpls []*ServiceTemplate) (err error) {
+	defer observeQuery("serviceModel.AddWithTemplates", time.Now(), &err, logging.FieldAppId, target.AppId)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	target.Id = 0
+	target.App = &App{Id: target.AppId}
+	target.CreateTime = nil
+	target.Deleted = false
+	if target.Id, err = o.Insert(target); err != nil {
+		return apierror.Query(err, fmt.Sprintf("service %s", target.Name))
+	}
+
+	for _, tpl := range tpls {
+		tpl.Id = 0
+		tpl.Service = target
+		tpl.ServiceId = target.Id
+		tpl.CreateTime = nil
+		tpl.Deleted = false
+		if tpl.Id, err = o.Insert(tpl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
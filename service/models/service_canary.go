@@ -0,0 +1,166 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const (
+	TableNameServiceCanary = "service_canary"
+
+	CanaryStatusRunning  = "running"
+	CanaryStatusPromoted = "promoted"
+	CanaryStatusAborted  = "aborted"
+)
+
+type serviceCanaryModel struct{}
+
+// ServiceCanary is a canary of a Service: a companion Service selecting the canary pods, which
+// receives Weight percent of the traffic of the ingresses supporting weighted routing.
+type ServiceCanary struct {
+	Id      int64    `orm:"auto" json:"id,omitempty"`
+	Service *Service `orm:"index;rel(fk)" json:"-"`
+	// TemplateId is the template the companion Service was generated from.
+	TemplateId int64 `orm:"index" json:"templateId"`
+	// Selector are the labels of the canary pods, merged into the selector of the template.
+	Selector   string     `orm:"type(text)" json:"-"`
+	Weight     int        `orm:"default(0)" json:"weight"`
+	Clusters   string     `orm:"size(1024)" json:"-"`
+	Status     string     `orm:"index;size(32)" json:"status"`
+	CreateTime *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	UpdateTime *time.Time `orm:"auto_now;type(datetime)" json:"updateTime,omitempty"`
+	User       string     `orm:"size(128)" json:"user,omitempty"`
+
+	ServiceId   int64             `orm:"-" json:"serviceId,omitempty"`
+	SelectorMap map[string]string `orm:"-" json:"selector"`
+	ClusterList []string          `orm:"-" json:"clusters"`
+}
+
+func (*ServiceCanary) TableName() string {
+	return TableNameServiceCanary
+}
+
+func (m *ServiceCanary) parse() error {
+	if m.Service != nil {
+		m.ServiceId = m.Service.Id
+	}
+	m.ClusterList = []string{}
+	if m.Clusters != "" {
+		m.ClusterList = strings.Split(m.Clusters, ",")
+	}
+	m.SelectorMap = map[string]string{}
+	if m.Selector == "" {
+		return nil
+	}
+	return json.Unmarshal(hack.Slice(m.Selector), &m.SelectorMap)
+}
+
+func (m *ServiceCanary) prepare() error {
+	m.Service = &Service{Id: m.ServiceId}
+	m.Clusters = strings.Join(m.ClusterList, ",")
+	data, err := json.Marshal(m.SelectorMap)
+	if err != nil {
+		return err
+	}
+	m.Selector = string(data)
+	return nil
+}
+
+// GetRunning returns the running canary of the service.
+func (*serviceCanaryModel) GetRunning(serviceId int64) (_ *ServiceCanary, err error) {
+	defer observeQuery("serviceCanaryModel.GetRunning", time.Now(), &err, logging.FieldServiceId, serviceId)
+	canary := &ServiceCanary{}
+	err = Ormer().
+		QueryTable(new(ServiceCanary)).
+		Filter("Service__Id", serviceId).
+		Filter("Status", CanaryStatusRunning).
+		One(canary)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("running canary of service %d", serviceId))
+	}
+	return canary, canary.parse()
+}
+
+// GetAll returns the canaries of the service, newest first.
+func (*serviceCanaryModel) GetAll(serviceId int64) (_ []*ServiceCanary, err error) {
+	defer observeQuery("serviceCanaryModel.GetAll", time.Now(), &err, logging.FieldServiceId, serviceId)
+	canaries := []*ServiceCanary{}
+	_, err = Ormer().
+		QueryTable(new(ServiceCanary)).
+		Filter("Service__Id", serviceId).
+		OrderBy("-Id").
+		All(&canaries)
+	if err != nil {
+		return nil, err
+	}
+	for _, canary := range canaries {
+		if err := canary.parse(); err != nil {
+			return nil, err
+		}
+	}
+	return canaries, nil
+}
+
+// Add starts the canary m. It fails with a conflict if the service already has a running canary:
+// the row of the service is locked while checking, so concurrent starts are serialized.
+func (*serviceCanaryModel) Add(m *ServiceCanary) (id int64, err error) {
+	defer observeQuery("serviceCanaryModel.Add", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldTemplateId, m.TemplateId)
+	if err = m.prepare(); err != nil {
+		return
+	}
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	if err = o.ReadForUpdate(&Service{Id: m.ServiceId}); err != nil {
+		return 0, apierror.Query(err, fmt.Sprintf("service %d", m.ServiceId))
+	}
+	running, err := o.QueryTable(new(ServiceCanary)).
+		Filter("Service__Id", m.ServiceId).
+		Filter("Status", CanaryStatusRunning).
+		Count()
+	if err != nil {
+		return
+	}
+	if running > 0 {
+		return 0, apierror.Conflict(fmt.Sprintf("Service %d already has a running canary.", m.ServiceId))
+	}
+	m.Status = CanaryStatusRunning
+	m.CreateTime = nil
+	id, err = o.Insert(m)
+	return
+}
+
+// UpdateWeight updates the weight of the canary.
+func (*serviceCanaryModel) UpdateWeight(m *ServiceCanary) (err error) {
+	defer observeQuery("serviceCanaryModel.UpdateWeight", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
+	m.UpdateTime = nil
+	_, err = Ormer().Update(m, "Weight", "UpdateTime")
+	return
+}
+
+// Finish ends the canary with status promoted or aborted.
+func (*serviceCanaryModel) Finish(m *ServiceCanary, status string) (err error) {
+	defer observeQuery("serviceCanaryModel.Finish", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
+	m.Status = status
+	m.UpdateTime = nil
+	_, err = Ormer().Update(m, "Status", "UpdateTime")
+	return
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceEndpointsTemplate = "service_endpoints_template"
+)
+
+type serviceEndpointsTplModel struct{}
+
+// ServiceEndpointsTemplate are the hand-managed endpoints of a Service without selector.
+// Template is the JSON of a resources.EndpointsSpec, the latest template is published with the Service.
+type ServiceEndpointsTemplate struct {
+	Id          int64      `orm:"auto" json:"id,omitempty"`
+	Name        string     `orm:"size(128)" json:"name,omitempty"`
+	Template    string     `orm:"type(text)" json:"template,omitempty"`
+	Service     *Service   `orm:"index;rel(fk)" json:"-"`
+	Description string     `orm:"null;size(512)" json:"description,omitempty"`
+	CreateTime  *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	UpdateTime  *time.Time `orm:"auto_now;type(datetime)" json:"updateTime,omitempty"`
+	User        string     `orm:"size(128)" json:"user,omitempty"`
+
+	ServiceId int64 `orm:"-" json:"serviceId,omitempty"`
+}
+
+func (*ServiceEndpointsTemplate) TableName() string {
+	return TableNameServiceEndpointsTemplate
+}
+
+// GetAll returns the endpoints templates of the service, newest first.
+func (*serviceEndpointsTplModel) GetAll(serviceId int64) (_ []*ServiceEndpointsTemplate, err error) {
+	defer observeQuery("serviceEndpointsTplModel.GetAll", time.Now(), &err, logging.FieldServiceId, serviceId)
+	tpls := []*ServiceEndpointsTemplate{}
+	_, err = Ormer().
+		QueryTable(new(ServiceEndpointsTemplate)).
+		Filter("Service__Id", serviceId).
+		OrderBy("-Id").
+		All(&tpls)
+	if err != nil {
+		return nil, err
+	}
+	for _, tpl := range tpls {
+		tpl.ServiceId = serviceId
+	}
+	return tpls, nil
+}
+
+// GetLatest returns the newest endpoints template of the service.
+func (*serviceEndpointsTplModel) GetLatest(serviceId int64) (_ *ServiceEndpointsTemplate, err error) {
+	defer observeQuery("serviceEndpointsTplModel.GetLatest", time.Now(), &err, logging.FieldServiceId, serviceId)
+	tpl := &ServiceEndpointsTemplate{}
+	err = Ormer().
+		QueryTable(new(ServiceEndpointsTemplate)).
+		Filter("Service__Id", serviceId).
+		OrderBy("-Id").
+		One(tpl)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("endpoints template of service %d", serviceId))
+	}
+	tpl.ServiceId = serviceId
+	return tpl, nil
+}
+
+func (*serviceEndpointsTplModel) GetById(id int64) (v *ServiceEndpointsTemplate, err error) {
+	defer observeQuery("serviceEndpointsTplModel.GetById", time.Now(), &err)
+	v = &ServiceEndpointsTemplate{Id: id}
+
+	if err = apierror.Query(Ormer().Read(v), fmt.Sprintf("endpoints template %d", id)); err == nil {
+		v.ServiceId = v.Service.Id
+		return v, nil
+	}
+	return nil, err
+}
+
+func (*serviceEndpointsTplModel) Add(m *ServiceEndpointsTemplate) (id int64, err error) {
+	defer observeQuery("serviceEndpointsTplModel.Add", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
+	m.Service = &Service{Id: m.ServiceId}
+	m.CreateTime = nil
+	id, err = Ormer().Insert(m)
+	return id, apierror.Query(err, "endpoints template")
+}
+
+func (*serviceEndpointsTplModel) UpdateById(m *ServiceEndpointsTemplate) (err error) {
+	defer observeQuery("serviceEndpointsTplModel.UpdateById", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
+	v := ServiceEndpointsTemplate{Id: m.Id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("endpoints template %d", m.Id)); err == nil {
+		m.Service = &Service{Id: m.ServiceId}
+		m.UpdateTime = nil
+		_, err = Ormer().Update(m)
+		return err
+	}
+	return
+}
+
+func (*serviceEndpointsTplModel) DeleteById(id int64) (err error) {
+	defer observeQuery("serviceEndpointsTplModel.DeleteById", time.Now(), &err)
+	v := ServiceEndpointsTemplate{Id: id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("endpoints template %d", id)); err == nil {
+		_, err = Ormer().Delete(&v)
+		return err
+	}
+	return
+}
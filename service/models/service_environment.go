@@ -0,0 +1,227 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceEnvironment     = "service_environment"
+	TableNameServiceTemplateLineage = "service_template_lineage"
+)
+
+type serviceEnvironmentModel struct{}
+
+// ServiceEnvironment is one stage of a promotion pipeline, e.g. dev -> staging -> prod.
+// A stage is an app and a cluster; templates live there are promoted to the next stage.
+type ServiceEnvironment struct {
+	Id       int64  `orm:"auto" json:"id,omitempty"`
+	Pipeline string `orm:"index;size(128)" json:"pipeline,omitempty"`
+	Name     string `orm:"size(128)" json:"name,omitempty"`
+	// Stage orders the environments of a pipeline, promotion goes to the next greater stage.
+	Stage   int    `orm:"default(0)" json:"stage"`
+	App     *App   `orm:"index;rel(fk)" json:"app,omitempty"`
+	Cluster string `orm:"size(128)" json:"cluster,omitempty"`
+	// Overrides is a JSON merge patch applied to templates promoted into this environment.
+	Overrides  string     `orm:"null;type(text)" json:"overrides,omitempty"`
+	CreateTime *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	UpdateTime *time.Time `orm:"auto_now;type(datetime)" json:"updateTime,omitempty"`
+	User       string     `orm:"size(128)" json:"user,omitempty"`
+
+	AppId int64 `orm:"-" json:"appId,omitempty"`
+}
+
+func (*ServiceEnvironment) TableName() string {
+	return TableNameServiceEnvironment
+}
+
+// ServiceTemplateLineage records that a template was promoted from another one.
+type ServiceTemplateLineage struct {
+	Id               int64      `orm:"auto" json:"id,omitempty"`
+	SourceTemplateId int64      `orm:"index" json:"sourceTemplateId"`
+	SourceCluster    string     `orm:"size(128)" json:"sourceCluster"`
+	TargetTemplateId int64      `orm:"index" json:"targetTemplateId"`
+	TargetCluster    string     `orm:"size(128)" json:"targetCluster"`
+	Pipeline         string     `orm:"size(128)" json:"pipeline"`
+	FromEnvironment  string     `orm:"size(128)" json:"fromEnvironment"`
+	ToEnvironment    string     `orm:"size(128)" json:"toEnvironment"`
+	CreateTime       *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	User             string     `orm:"size(128)" json:"user,omitempty"`
+}
+
+func (*ServiceTemplateLineage) TableName() string {
+	return TableNameServiceTemplateLineage
+}
+
+func (*serviceEnvironmentModel) GetAll() (_ []ServiceEnvironment, err error) {
+	defer observeQuery("serviceEnvironmentModel.GetAll", time.Now(), &err)
+	envs := []ServiceEnvironment{}
+	_, err = Ormer().
+		QueryTable(new(ServiceEnvironment)).
+		OrderBy("Pipeline", "Stage").
+		All(&envs)
+	if err != nil {
+		return nil, err
+	}
+	for i, env := range envs {
+		envs[i].AppId = env.App.Id
+	}
+	return envs, nil
+}
+
+func (*serviceEnvironmentModel) Add(m *ServiceEnvironment) (id int64, err error) {
+	defer observeQuery("serviceEnvironmentModel.Add", time.Now(), &err, logging.FieldAppId, m.AppId)
+	m.App = &App{Id: m.AppId}
+	m.CreateTime = nil
+	id, err = Ormer().Insert(m)
+	return id, apierror.Query(err, fmt.Sprintf("environment %s", m.Name))
+}
+
+func (*serviceEnvironmentModel) UpdateById(m *ServiceEnvironment) (err error) {
+	defer observeQuery("serviceEnvironmentModel.UpdateById", time.Now(), &err, logging.FieldAppId, m.AppId)
+	v := ServiceEnvironment{Id: m.Id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("environment %d", m.Id)); err == nil {
+		m.UpdateTime = nil
+		m.App = &App{Id: m.AppId}
+		_, err = Ormer().Update(m)
+		return err
+	}
+	return
+}
+
+func (*serviceEnvironmentModel) DeleteById(id int64) (err error) {
+	defer observeQuery("serviceEnvironmentModel.DeleteById", time.Now(), &err)
+	v := ServiceEnvironment{Id: id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("environment %d", id)); err == nil {
+		_, err = Ormer().Delete(&v)
+		return err
+	}
+	return
+}
+
+// GetByAppAndCluster returns the environment an app and cluster belong to.
+func (*serviceEnvironmentModel) GetByAppAndCluster(appId int64, cluster string) (_ *ServiceEnvironment, err error) {
+	defer observeQuery("serviceEnvironmentModel.GetByAppAndCluster", time.Now(), &err, logging.FieldAppId, appId, logging.FieldCluster, cluster)
+	env := &ServiceEnvironment{}
+	err = Ormer().
+		QueryTable(new(ServiceEnvironment)).
+		Filter("App__Id", appId).
+		Filter("Cluster", cluster).
+		One(env)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("environment of cluster %s", cluster))
+	}
+	env.AppId = appId
+	return env, nil
+}
+
+// GetNext returns the environment following env in its pipeline.
+func (*serviceEnvironmentModel) GetNext(env *ServiceEnvironment) (_ *ServiceEnvironment, err error) {
+	defer observeQuery("serviceEnvironmentModel.GetNext", time.Now(), &err, logging.FieldAppId, env.AppId)
+	next := &ServiceEnvironment{}
+	err = Ormer().
+		QueryTable(new(ServiceEnvironment)).
+		Filter("Pipeline", env.Pipeline).
+		Filter("Stage__gt", env.Stage).
+		OrderBy("Stage").
+		One(next)
+	if err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("environment after %s", env.Name))
+	}
+	next.AppId = next.App.Id
+	return next, nil
+}
+
+func (*serviceEnvironmentModel) AddLineage(m *ServiceTemplateLineage) (id int64, err error) {
+	defer observeQuery("serviceEnvironmentModel.AddLineage", time.Now(), &err, logging.FieldTemplateId, m.TargetTemplateId)
+	m.CreateTime = nil
+	id, err = Ormer().Insert(m)
+	return
+}
+
+// AddPromotion inserts the promoted template and its lineage in one transaction, with target
+// when it does not exist yet (target.Id is 0). The ids are updated to the inserted rows.
+func (*serviceEnvironmentModel) AddPromotion(target *Service, tpl *ServiceTemplate, lineage *ServiceTemplateLineage) (err error) {
+	defer observeQuery("serviceEnvironmentModel.AddPromotion", time.Now(), &err, logging.FieldAppId, target.AppId)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	if target.Id == 0 {
+		target.App = &App{Id: target.AppId}
+		if target.Id, err = o.Insert(target); err != nil {
+			return apierror.Query(err, fmt.Sprintf("service %s", target.Name))
+		}
+	}
+	tpl.Service = target
+	tpl.ServiceId = target.Id
+	if tpl.Id, err = o.Insert(tpl); err != nil {
+		return apierror.Query(err, fmt.Sprintf("template %s", tpl.Name))
+	}
+	lineage.TargetTemplateId = tpl.Id
+	lineage.CreateTime = nil
+	lineage.Id, err = o.Insert(lineage)
+	return err
+}
+
+// RemovePromotion deletes the rows inserted by AddPromotion, with target if createdTarget,
+// when the promoted template could not be published.
+func (*serviceEnvironmentModel) RemovePromotion(target *Service, createdTarget bool, tpl *ServiceTemplate, lineage *ServiceTemplateLineage) (err error) {
+	defer observeQuery("serviceEnvironmentModel.RemovePromotion", time.Now(), &err, logging.FieldServiceId, target.Id)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	if _, err = o.Delete(&ServiceTemplateLineage{Id: lineage.Id}); err != nil {
+		return err
+	}
+	if _, err = o.Delete(&ServiceTemplate{Id: tpl.Id}); err != nil {
+		return err
+	}
+	if createdTarget {
+		_, err = o.Delete(&Service{Id: target.Id})
+	}
+	return err
+}
+
+// GetLineage returns the promotions the template took part in, as source or as target.
+func (*serviceEnvironmentModel) GetLineage(templateId int64) (_ []ServiceTemplateLineage, err error) {
+	defer observeQuery("serviceEnvironmentModel.GetLineage", time.Now(), &err, logging.FieldTemplateId, templateId)
+	lineages := []ServiceTemplateLineage{}
+	cond := orm.NewCondition().
+		Or("SourceTemplateId", templateId).
+		Or("TargetTemplateId", templateId)
+	_, err = Ormer().
+		QueryTable(new(ServiceTemplateLineage)).
+		SetCond(cond).
+		OrderBy("-Id").
+		All(&lineages)
+	if err != nil {
+		return nil, err
+	}
+	return lineages, nil
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceHealthSample = "service_health_sample"
+)
+
+type serviceHealthModel struct{}
+
+// ServiceHealthSample is a stretch of time during which the endpoints of a Service in a cluster kept
+// the same ready and not ready counts. Samples only add a row when the counts change or sampling
+// was interrupted, otherwise they extend EndTime of the latest row.
+type ServiceHealthSample struct {
+	Id        int64      `orm:"auto" json:"id,omitempty"`
+	Service   *Service   `orm:"index;rel(fk)" json:"-"`
+	Cluster   string     `orm:"index;size(128)" json:"cluster"`
+	Ready     int        `orm:"default(0)" json:"ready"`
+	NotReady  int        `orm:"default(0)" json:"notReady"`
+	StartTime *time.Time `orm:"index;type(datetime)" json:"startTime"`
+	// EndTime is the last sample with these counts.
+	EndTime *time.Time `orm:"index;type(datetime)" json:"endTime"`
+
+	ServiceId int64 `orm:"-" json:"serviceId,omitempty"`
+}
+
+func (*ServiceHealthSample) TableName() string {
+	return TableNameServiceHealthSample
+}
+
+// TableUnique keeps one sample starting at a time per Service and cluster.
+func (*ServiceHealthSample) TableUnique() [][]string {
+	return [][]string{{"Service", "Cluster", "StartTime"}}
+}
+
+// Record stores the endpoint counts sampled at. It extends the latest sample of the service in cluster
+// if the counts did not change and it is no older than maxGap. A sample another sampler recorded at
+// the same time is kept.
+func (*serviceHealthModel) Record(serviceId int64, cluster string, ready int, notReady int, at time.Time, maxGap time.Duration) (err error) {
+	defer observeQuery("serviceHealthModel.Record", time.Now(), &err, logging.FieldServiceId, serviceId, logging.FieldCluster, cluster)
+	latest := &ServiceHealthSample{}
+	err = Ormer().
+		QueryTable(new(ServiceHealthSample)).
+		Filter("Service__Id", serviceId).
+		Filter("Cluster", cluster).
+		OrderBy("-EndTime").
+		Limit(1).
+		One(latest)
+	switch {
+	case err == orm.ErrNoRows:
+	case err != nil:
+		return err
+	case latest.Ready == ready && latest.NotReady == notReady && at.Sub(*latest.EndTime) <= maxGap:
+		latest.EndTime = &at
+		_, err = Ormer().Update(latest, "EndTime")
+		return err
+	}
+
+	_, err = Ormer().Insert(&ServiceHealthSample{
+		Service:   &Service{Id: serviceId},
+		Cluster:   cluster,
+		Ready:     ready,
+		NotReady:  notReady,
+		StartTime: &at,
+		EndTime:   &at,
+	})
+	err = apierror.Query(err, fmt.Sprintf("health sample of service %d in cluster %s", serviceId, cluster))
+	if e, ok := err.(*apierror.Error); ok && e.Code == apierror.CodeConflict {
+		return nil
+	}
+	return err
+}
+
+// GetRange returns the samples of the service overlapping [from, to], of cluster if not empty,
+// ordered by cluster and time.
+func (*serviceHealthModel) GetRange(serviceId int64, cluster string, from time.Time, to time.Time) (_ []*ServiceHealthSample, err error) {
+	defer observeQuery("serviceHealthModel.GetRange", time.Now(), &err, logging.FieldServiceId, serviceId)
+	samples := []*ServiceHealthSample{}
+	qs := Ormer().
+		QueryTable(new(ServiceHealthSample)).
+		Filter("Service__Id", serviceId).
+		Filter("EndTime__gte", from).
+		Filter("StartTime__lte", to)
+	if cluster != "" {
+		qs = qs.Filter("Cluster", cluster)
+	}
+	if _, err := qs.OrderBy("Cluster", "StartTime").All(&samples); err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		sample.ServiceId = serviceId
+	}
+	return samples, nil
+}
+
+// GetPublished returns where the services are published, the services to sample.
+func (*serviceHealthModel) GetPublished() (_ []PublishStatus, err error) {
+	defer observeQuery("serviceHealthModel.GetPublished", time.Now(), &err)
+	status := []PublishStatus{}
+	_, err = Ormer().
+		QueryTable(new(PublishStatus)).
+		Filter("Type", PublishTypeService).
+		All(&status)
+	return status, err
+}
+
+// DeleteBefore removes the samples which ended before t.
+func (*serviceHealthModel) DeleteBefore(t time.Time) (_ int64, err error) {
+	defer observeQuery("serviceHealthModel.DeleteBefore", time.Now(), &err)
+	return Ormer().
+		QueryTable(new(ServiceHealthSample)).
+		Filter("EndTime__lt", t).
+		Delete()
+}
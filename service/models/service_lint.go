@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/lint"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const (
+	TableNameServiceLintRuleSet = "service_lint_rule_set"
+)
+
+type serviceLintRuleSetModel struct{}
+
+// ServiceLintRuleSet enables lint rules for Service templates. A rule set without app and
+// namespace applies globally; namespace rule sets override it and app rule sets override both.
+type ServiceLintRuleSet struct {
+	Id         int64      `orm:"auto" json:"id,omitempty"`
+	Name       string     `orm:"size(128)" json:"name,omitempty"`
+	Namespace  *Namespace `orm:"null;index;rel(fk)" json:"namespace,omitempty"`
+	App        *App       `orm:"null;index;rel(fk)" json:"app,omitempty"`
+	Rules      string     `orm:"type(text)" json:"-"`
+	CreateTime *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	UpdateTime *time.Time `orm:"auto_now;type(datetime)" json:"updateTime,omitempty"`
+	User       string     `orm:"size(128)" json:"user,omitempty"`
+
+	NamespaceId int64             `orm:"-" json:"namespaceId,omitempty"`
+	AppId       int64             `orm:"-" json:"appId,omitempty"`
+	RuleList    []lint.RuleConfig `orm:"-" json:"rules"`
+}
+
+func (*ServiceLintRuleSet) TableName() string {
+	return TableNameServiceLintRuleSet
+}
+
+func (m *ServiceLintRuleSet) parse() error {
+	if m.Namespace != nil {
+		m.NamespaceId = m.Namespace.Id
+	}
+	if m.App != nil {
+		m.AppId = m.App.Id
+	}
+	m.RuleList = []lint.RuleConfig{}
+	if m.Rules == "" {
+		return nil
+	}
+	return json.Unmarshal(hack.Slice(m.Rules), &m.RuleList)
+}
+
+func (m *ServiceLintRuleSet) prepare() error {
+	m.Namespace = nil
+	if m.NamespaceId != 0 {
+		m.Namespace = &Namespace{Id: m.NamespaceId}
+	}
+	m.App = nil
+	if m.AppId != 0 {
+		m.App = &App{Id: m.AppId}
+	}
+	data, err := json.Marshal(m.RuleList)
+	if err != nil {
+		return err
+	}
+	m.Rules = string(data)
+	return nil
+}
+
+func (*serviceLintRuleSetModel) GetAll() (_ []*ServiceLintRuleSet, err error) {
+	defer observeQuery("serviceLintRuleSetModel.GetAll", time.Now(), &err)
+	sets := []*ServiceLintRuleSet{}
+	if _, err := Ormer().QueryTable(new(ServiceLintRuleSet)).OrderBy("Id").All(&sets); err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		if err := set.parse(); err != nil {
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+func (*serviceLintRuleSetModel) Add(m *ServiceLintRuleSet) (id int64, err error) {
+	defer observeQuery("serviceLintRuleSetModel.Add", time.Now(), &err, logging.FieldAppId, m.AppId)
+	if err = m.prepare(); err != nil {
+		return
+	}
+	m.CreateTime = nil
+	id, err = Ormer().Insert(m)
+	return
+}
+
+func (*serviceLintRuleSetModel) UpdateById(m *ServiceLintRuleSet) (err error) {
+	defer observeQuery("serviceLintRuleSetModel.UpdateById", time.Now(), &err, logging.FieldAppId, m.AppId)
+	v := ServiceLintRuleSet{Id: m.Id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("lint rule set %d", m.Id)); err == nil {
+		if err = m.prepare(); err != nil {
+			return
+		}
+		m.UpdateTime = nil
+		_, err = Ormer().Update(m)
+		return err
+	}
+	return
+}
+
+func (*serviceLintRuleSetModel) DeleteById(id int64) (err error) {
+	defer observeQuery("serviceLintRuleSetModel.DeleteById", time.Now(), &err)
+	v := ServiceLintRuleSet{Id: id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("lint rule set %d", id)); err == nil {
+		_, err = Ormer().Delete(&v)
+		return err
+	}
+	return
+}
+
+// GetRules returns the effective rules of the app: global, then namespace, then app rule sets merged.
+func (*serviceLintRuleSetModel) GetRules(appId int64) (_ []lint.RuleConfig, err error) {
+	defer observeQuery("serviceLintRuleSetModel.GetRules", time.Now(), &err, logging.FieldAppId, appId)
+	app := &App{Id: appId}
+	if err := Ormer().Read(app); err != nil {
+		return nil, apierror.Query(err, fmt.Sprintf("app %d", appId))
+	}
+
+	cond := orm.NewCondition()
+	cond = cond.
+		OrCond(orm.NewCondition().And("App__isnull", true).And("Namespace__isnull", true)).
+		OrCond(orm.NewCondition().And("App__isnull", true).And("Namespace__Id", app.Namespace.Id)).
+		OrCond(orm.NewCondition().And("App__Id", appId))
+	sets := []*ServiceLintRuleSet{}
+	if _, err := Ormer().QueryTable(new(ServiceLintRuleSet)).SetCond(cond).OrderBy("Id").All(&sets); err != nil {
+		return nil, err
+	}
+
+	var global, namespace, application [][]lint.RuleConfig
+	for _, set := range sets {
+		if err := set.parse(); err != nil {
+			return nil, err
+		}
+		switch {
+		case set.AppId != 0:
+			application = append(application, set.RuleList)
+		case set.NamespaceId != 0:
+			namespace = append(namespace, set.RuleList)
+		default:
+			global = append(global, set.RuleList)
+		}
+	}
+	ordered := append(append(global, namespace...), application...)
+	return lint.Merge(ordered...), nil
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceLoadBalancer = "service_load_balancer"
+
+	// startLoadBalancerAttempts bounds the inserts of Start racing a concurrent publish.
+	startLoadBalancerAttempts = 2
+
+	LoadBalancerStatePending     = "pending"
+	LoadBalancerStateProvisioned = "provisioned"
+	LoadBalancerStateFailed      = "failed"
+)
+
+type serviceLoadBalancerModel struct{}
+
+// ServiceLoadBalancer tracks the provisioning of the load balancer of a Service in a cluster
+// since its last publish.
+type ServiceLoadBalancer struct {
+	Id         int64    `orm:"auto" json:"id,omitempty"`
+	Service    *Service `orm:"index;rel(fk)" json:"-"`
+	Cluster    string   `orm:"index;size(128)" json:"cluster"`
+	TemplateId int64    `orm:"default(0)" json:"templateId"`
+	State      string   `orm:"size(32)" json:"state"`
+	// Ingress are the comma separated IPs and hostnames of the provisioned load balancer.
+	Ingress string `orm:"size(1024)" json:"-"`
+	// Message is the latest warning event of the Service, or why provisioning failed.
+	Message   string     `orm:"type(text)" json:"message,omitempty"`
+	StartTime *time.Time `orm:"type(datetime)" json:"startTime,omitempty"`
+	EndTime   *time.Time `orm:"null;type(datetime)" json:"endTime,omitempty"`
+
+	ServiceId   int64    `orm:"-" json:"serviceId,omitempty"`
+	IngressList []string `orm:"-" json:"ingress"`
+	// ElapsedSeconds is the provisioning time, up to now while pending.
+	ElapsedSeconds int64 `orm:"-" json:"elapsedSeconds"`
+}
+
+func (*ServiceLoadBalancer) TableName() string {
+	return TableNameServiceLoadBalancer
+}
+
+// TableUnique keeps one tracking per Service and cluster.
+func (*ServiceLoadBalancer) TableUnique() [][]string {
+	return [][]string{{"Service", "Cluster"}}
+}
+
+func (m *ServiceLoadBalancer) parse() {
+	if m.Service != nil {
+		m.ServiceId = m.Service.Id
+	}
+	m.IngressList = []string{}
+	if m.Ingress != "" {
+		m.IngressList = strings.Split(m.Ingress, ",")
+	}
+	if m.StartTime != nil {
+		end := time.Now()
+		if m.EndTime != nil {
+			end = *m.EndTime
+		}
+		m.ElapsedSeconds = int64(end.Sub(*m.StartTime).Seconds())
+	}
+}
+
+// GetAll returns the load balancers of the service in all clusters.
+func (*serviceLoadBalancerModel) GetAll(serviceId int64) (_ []*ServiceLoadBalancer, err error) {
+	defer observeQuery("serviceLoadBalancerModel.GetAll", time.Now(), &err, logging.FieldServiceId, serviceId)
+	lbs := []*ServiceLoadBalancer{}
+	_, err = Ormer().
+		QueryTable(new(ServiceLoadBalancer)).
+		Filter("Service__Id", serviceId).
+		OrderBy("Cluster").
+		All(&lbs)
+	if err != nil {
+		return nil, err
+	}
+	for _, lb := range lbs {
+		lb.parse()
+	}
+	return lbs, nil
+}
+
+// Start restarts the tracking of the load balancer of the service in cluster after the template was published.
+// The tracking is restarted again if a concurrent publish inserted it first.
+func (*serviceLoadBalancerModel) Start(serviceId int64, templateId int64, cluster string) (lb *ServiceLoadBalancer, err error) {
+	defer observeQuery("serviceLoadBalancerModel.Start", time.Now(), &err, logging.FieldServiceId, serviceId, logging.FieldCluster, cluster)
+	for attempt := 1; ; attempt++ {
+		lb, err = startLoadBalancer(serviceId, templateId, cluster)
+		if e, ok := err.(*apierror.Error); ok && e.Code == apierror.CodeConflict && attempt < startLoadBalancerAttempts {
+			continue
+		}
+		return lb, err
+	}
+}
+
+func startLoadBalancer(serviceId int64, templateId int64, cluster string) (*ServiceLoadBalancer, error) {
+	lb := &ServiceLoadBalancer{}
+	err := Ormer().
+		QueryTable(new(ServiceLoadBalancer)).
+		Filter("Service__Id", serviceId).
+		Filter("Cluster", cluster).
+		One(lb)
+	if err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+
+	// datetime columns keep seconds, StartTime identifies this tracking in finishLoadBalancer
+	now := time.Now().Truncate(time.Second)
+	lb.Service = &Service{Id: serviceId}
+	lb.Cluster = cluster
+	lb.TemplateId = templateId
+	lb.State = LoadBalancerStatePending
+	lb.Ingress = ""
+	lb.Message = ""
+	lb.StartTime = &now
+	lb.EndTime = nil
+	if lb.Id == 0 {
+		_, err = Ormer().Insert(lb)
+		err = apierror.Query(err, fmt.Sprintf("load balancer of service %d in cluster %s", serviceId, cluster))
+	} else {
+		_, err = Ormer().Update(lb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lb.parse()
+	return lb, nil
+}
+
+// UpdateMessage records the latest warning event of a pending load balancer.
+func (*serviceLoadBalancerModel) UpdateMessage(m *ServiceLoadBalancer, message string) (err error) {
+	defer observeQuery("serviceLoadBalancerModel.UpdateMessage", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldCluster, m.Cluster)
+	m.Message = message
+	_, err = Ormer().Update(m, "Message")
+	return
+}
+
+// Provisioned ends the tracking with the ingress of the load balancer.
+func (*serviceLoadBalancerModel) Provisioned(m *ServiceLoadBalancer, ingress []string) (err error) {
+	defer observeQuery("serviceLoadBalancerModel.Provisioned", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldCluster, m.Cluster)
+	return finishLoadBalancer(m, LoadBalancerStateProvisioned, strings.Join(ingress, ","), m.Message)
+}
+
+// Fail ends the tracking of a load balancer not provisioned in time.
+func (*serviceLoadBalancerModel) Fail(m *ServiceLoadBalancer, message string) (err error) {
+	defer observeQuery("serviceLoadBalancerModel.Fail", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldCluster, m.Cluster)
+	return finishLoadBalancer(m, LoadBalancerStateFailed, "", message)
+}
+
+// FailStale fails the load balancers pending since before deadline and returns how many.
+func (*serviceLoadBalancerModel) FailStale(deadline time.Time, message string) (_ int64, err error) {
+	defer observeQuery("serviceLoadBalancerModel.FailStale", time.Now(), &err)
+	now := time.Now()
+	return Ormer().
+		QueryTable(new(ServiceLoadBalancer)).
+		Filter("State", LoadBalancerStatePending).
+		Filter("StartTime__lt", deadline).
+		Update(map[string]interface{}{
+			"State":   LoadBalancerStateFailed,
+			"Message": message,
+			"EndTime": &now,
+		})
+}
+
+func finishLoadBalancer(m *ServiceLoadBalancer, state string, ingress string, message string) (err error) {
+	now := time.Now()
+	m.State = state
+	m.Ingress = ingress
+	m.Message = message
+	m.EndTime = &now
+	// no-op if a newer publish restarted the tracking meanwhile
+	_, err = Ormer().
+		QueryTable(new(ServiceLoadBalancer)).
+		Filter("Id", m.Id).
+		Filter("StartTime", m.StartTime).
+		Update(map[string]interface{}{
+			"State":   m.State,
+			"Ingress": m.Ingress,
+			"Message": m.Message,
+			"EndTime": m.EndTime,
+		})
+	m.parse()
+	return
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceNodePort = "service_node_port"
+)
+
+type serviceNodePortModel struct{}
+
+// ServiceNodePort is a node port claimed by the latest template of a Service, in every cluster,
+// or held in one cluster by a live Service wayne does not manage, found by reconciling the cluster.
+type ServiceNodePort struct {
+	Id   int64 `orm:"auto" json:"id,omitempty"`
+	Port int32 `orm:"index" json:"port"`
+	// Cluster is empty for ports claimed by templates.
+	Cluster    string   `orm:"index;size(128)" json:"cluster,omitempty"`
+	Service    *Service `orm:"null;index;rel(fk)" json:"service,omitempty"`
+	TemplateId int64    `orm:"default(0)" json:"templateId,omitempty"`
+	// Namespace and Name are the live Service holding the port in Cluster.
+	Namespace  string     `orm:"size(128)" json:"namespace,omitempty"`
+	Name       string     `orm:"size(128)" json:"name,omitempty"`
+	CreateTime *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+}
+
+func (*ServiceNodePort) TableName() string {
+	return TableNameServiceNodePort
+}
+
+// TableUnique makes a port claimed by one template, and held by one Service per cluster.
+func (*ServiceNodePort) TableUnique() [][]string {
+	return [][]string{{"Cluster", "Port"}}
+}
+
+// Owner describes who holds the port.
+func (m *ServiceNodePort) Owner() string {
+	if m.Service != nil {
+		if m.Service.App != nil {
+			return fmt.Sprintf("service %s of app %s", m.Service.Name, m.Service.App.Name)
+		}
+		return fmt.Sprintf("service %s", m.Service.Name)
+	}
+	return fmt.Sprintf("service %s/%s in cluster %s", m.Namespace, m.Name, m.Cluster)
+}
+
+func (*serviceNodePortModel) GetAll(filters map[string]interface{}) (_ []*ServiceNodePort, err error) {
+	defer observeQuery("serviceNodePortModel.GetAll", time.Now(), &err)
+	ports := []*ServiceNodePort{}
+	qs := Ormer().QueryTable(new(ServiceNodePort)).RelatedSel("Service__App")
+	for k, v := range filters {
+		qs = qs.Filter(k, v)
+	}
+	if _, err := qs.OrderBy("Port", "Cluster").All(&ports); err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+// GetConflicts returns the holders of ports other than the service, among the template claims
+// and the live ports of clusters.
+func (m *serviceNodePortModel) GetConflicts(serviceId int64, ports []int32, clusters []string) (_ []*ServiceNodePort, err error) {
+	defer observeQuery("serviceNodePortModel.GetConflicts", time.Now(), &err, logging.FieldServiceId, serviceId)
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	cond := orm.NewCondition().And("Cluster", "")
+	if len(clusters) > 0 {
+		cond = cond.Or("Cluster__in", clusters)
+	}
+	holders := []*ServiceNodePort{}
+	_, err = Ormer().
+		QueryTable(new(ServiceNodePort)).
+		RelatedSel("Service__App").
+		SetCond(orm.NewCondition().AndCond(cond).And("Port__in", ports)).
+		OrderBy("Port").
+		All(&holders)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := []*ServiceNodePort{}
+	for _, holder := range holders {
+		// a null foreign key can not be excluded in SQL without dropping the row
+		if holder.Service == nil || holder.Service.Id != serviceId {
+			conflicts = append(conflicts, holder)
+		}
+	}
+	return conflicts, nil
+}
+
+// GetUsed returns the ports claimed by templates and held in cluster, all clusters if empty.
+func (*serviceNodePortModel) GetUsed(cluster string) (_ map[int32]bool, err error) {
+	defer observeQuery("serviceNodePortModel.GetUsed", time.Now(), &err, logging.FieldCluster, cluster)
+	qs := Ormer().QueryTable(new(ServiceNodePort))
+	if cluster != "" {
+		qs = qs.SetCond(orm.NewCondition().And("Cluster", "").Or("Cluster", cluster))
+	}
+	var ports orm.ParamsList
+	if _, err := qs.ValuesFlat(&ports, "Port"); err != nil {
+		return nil, err
+	}
+	used := make(map[int32]bool, len(ports))
+	for _, port := range ports {
+		switch p := port.(type) {
+		case int64:
+			used[int32(p)] = true
+		case int32:
+			used[p] = true
+		}
+	}
+	return used, nil
+}
+
+// Claim replaces the ports claimed by the service with the ports of its template.
+// A port claimed by another service fails with a conflict.
+func (*serviceNodePortModel) Claim(serviceId int64, templateId int64, ports []int32) (err error) {
+	defer observeQuery("serviceNodePortModel.Claim", time.Now(), &err, logging.FieldServiceId, serviceId, logging.FieldTemplateId, templateId)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	return claimNodePorts(o, serviceId, templateId, ports)
+}
+
+// claimNodePorts replaces the ports claimed by the service in the transaction of o. The unique
+// index on (cluster, port) fails the claims of a port claimed by another service meanwhile.
+func claimNodePorts(o orm.Ormer, serviceId int64, templateId int64, ports []int32) error {
+	_, err := o.QueryTable(new(ServiceNodePort)).
+		Filter("Service__Id", serviceId).
+		Filter("Cluster", "").
+		Delete()
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		claim := &ServiceNodePort{Port: port, Service: &Service{Id: serviceId}, TemplateId: templateId}
+		if _, err := o.Insert(claim); err != nil {
+			return apierror.Query(err, fmt.Sprintf("claim of node port %d", port))
+		}
+	}
+	return nil
+}
+
+// GetClaimingServiceIds returns the ids of the services claiming ports.
+func (*serviceNodePortModel) GetClaimingServiceIds() (_ map[int64]bool, err error) {
+	defer observeQuery("serviceNodePortModel.GetClaimingServiceIds", time.Now(), &err)
+	var ids orm.ParamsList
+	_, err = Ormer().
+		QueryTable(new(ServiceNodePort)).
+		Filter("Cluster", "").
+		Distinct().
+		ValuesFlat(&ids, "Service")
+	if err != nil {
+		return nil, err
+	}
+	claiming := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if id, ok := id.(int64); ok {
+			claiming[id] = true
+		}
+	}
+	return claiming, nil
+}
+
+// Release removes the ports claimed by the service.
+func (*serviceNodePortModel) Release(serviceId int64) (err error) {
+	defer observeQuery("serviceNodePortModel.Release", time.Now(), &err, logging.FieldServiceId, serviceId)
+	_, err = Ormer().
+		QueryTable(new(ServiceNodePort)).
+		Filter("Service__Id", serviceId).
+		Delete()
+	return
+}
+
+// ReplaceLive replaces the live ports recorded for cluster.
+func (*serviceNodePortModel) ReplaceLive(cluster string, ports []*ServiceNodePort) (err error) {
+	defer observeQuery("serviceNodePortModel.ReplaceLive", time.Now(), &err, logging.FieldCluster, cluster)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	if _, err = o.QueryTable(new(ServiceNodePort)).Filter("Cluster", cluster).Delete(); err != nil {
+		return err
+	}
+	for _, port := range ports {
+		port.Id = 0
+		port.Cluster = cluster
+		port.CreateTime = nil
+		if _, err = o.Insert(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
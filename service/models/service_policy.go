@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+)
+
+const (
+	TableNameServicePolicy = "service_policy"
+
+	// addVersionAttempts bounds the retries of AddVersion when concurrent saves took the version.
+	addVersionAttempts = 3
+)
+
+type servicePolicyModel struct{}
+
+// ServicePolicy is one version of a Rego policy. Updating a policy adds a new version,
+// only the latest version of each policy is evaluated, and only if it is enabled.
+type ServicePolicy struct {
+	Id          int64      `orm:"auto" json:"id,omitempty"`
+	Name        string     `orm:"index;size(128)" json:"name,omitempty"`
+	Version     int64      `orm:"default(1)" json:"version"`
+	Module      string     `orm:"type(text)" json:"module,omitempty"`
+	Enabled     bool       `orm:"default(true)" json:"enabled"`
+	Description string     `orm:"null;size(512)" json:"description,omitempty"`
+	CreateTime  *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	User        string     `orm:"size(128)" json:"user,omitempty"`
+}
+
+func (*ServicePolicy) TableName() string {
+	return TableNameServicePolicy
+}
+
+// TableUnique makes a version number taken by one save of the policy.
+func (*ServicePolicy) TableUnique() [][]string {
+	return [][]string{{"Name", "Version"}}
+}
+
+// ModuleName is the name of the policy module in the compiler, unique per version.
+func (m *ServicePolicy) ModuleName() string {
+	return fmt.Sprintf("%s.v%d.rego", m.Name, m.Version)
+}
+
+// GetLatest returns the latest version of every policy.
+func (*servicePolicyModel) GetLatest() (_ []*ServicePolicy, err error) {
+	defer observeQuery("servicePolicyModel.GetLatest", time.Now(), &err)
+	all := []*ServicePolicy{}
+	_, err = Ormer().
+		QueryTable(new(ServicePolicy)).
+		OrderBy("Name", "-Version").
+		All(&all)
+	if err != nil {
+		return nil, err
+	}
+	latest := []*ServicePolicy{}
+	for _, policy := range all {
+		if len(latest) > 0 && latest[len(latest)-1].Name == policy.Name {
+			continue
+		}
+		latest = append(latest, policy)
+	}
+	return latest, nil
+}
+
+// GetActiveModules returns the modules of the latest enabled policies, keyed by module name.
+func (m *servicePolicyModel) GetActiveModules() (_ map[string]string, err error) {
+	defer observeQuery("servicePolicyModel.GetActiveModules", time.Now(), &err)
+	return m.activeModulesExcept("")
+}
+
+// GetOtherActiveModules returns the active modules of the policies other than the policy named name,
+// the modules a new version of it is evaluated along with.
+func (m *servicePolicyModel) GetOtherActiveModules(name string) (_ map[string]string, err error) {
+	defer observeQuery("servicePolicyModel.GetOtherActiveModules", time.Now(), &err)
+	return m.activeModulesExcept(name)
+}
+
+func (m *servicePolicyModel) activeModulesExcept(name string) (map[string]string, error) {
+	latest, err := m.GetLatest()
+	if err != nil {
+		return nil, err
+	}
+	modules := make(map[string]string)
+	for _, policy := range latest {
+		if policy.Enabled && policy.Name != name {
+			modules[policy.ModuleName()] = policy.Module
+		}
+	}
+	return modules, nil
+}
+
+// GetVersions returns all versions of the policy named name, newest first.
+func (*servicePolicyModel) GetVersions(name string) (_ []*ServicePolicy, err error) {
+	defer observeQuery("servicePolicyModel.GetVersions", time.Now(), &err)
+	versions := []*ServicePolicy{}
+	_, err = Ormer().
+		QueryTable(new(ServicePolicy)).
+		Filter("Name", name).
+		OrderBy("-Version").
+		All(&versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (*servicePolicyModel) GetById(id int64) (v *ServicePolicy, err error) {
+	defer observeQuery("servicePolicyModel.GetById", time.Now(), &err)
+	v = &ServicePolicy{Id: id}
+
+	if err = apierror.Query(Ormer().Read(v), fmt.Sprintf("policy %d", id)); err == nil {
+		return v, nil
+	}
+	return nil, err
+}
+
+// AddVersion stores policy as the next version of the policy named policy.Name. The version is
+// taken again if a concurrent save inserted it first.
+func (m *servicePolicyModel) AddVersion(policy *ServicePolicy) (id int64, err error) {
+	defer observeQuery("servicePolicyModel.AddVersion", time.Now(), &err)
+	for attempt := 1; ; attempt++ {
+		versions, err := m.GetVersions(policy.Name)
+		if err != nil {
+			return 0, err
+		}
+		policy.Id = 0
+		policy.Version = 1
+		if len(versions) > 0 {
+			policy.Version = versions[0].Version + 1
+		}
+		policy.CreateTime = nil
+		id, err = Ormer().Insert(policy)
+		err = apierror.Query(err, fmt.Sprintf("policy %s version %d", policy.Name, policy.Version))
+		if e, ok := err.(*apierror.Error); ok && e.Code == apierror.CodeConflict && attempt < addVersionAttempts {
+			continue
+		}
+		return id, err
+	}
+}
+
+// DeleteByName deletes all versions of the policy.
+func (*servicePolicyModel) DeleteByName(name string) (err error) {
+	defer observeQuery("servicePolicyModel.DeleteByName", time.Now(), &err)
+	_, err = Ormer().
+		QueryTable(new(ServicePolicy)).
+		Filter("Name", name).
+		Delete()
+	return
+}
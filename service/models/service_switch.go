@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceSwitchRevert = "service_switch_revert"
+
+	SwitchRevertStatePending = "pending"
+	// SwitchRevertStateKept is a switch whose endpoints stayed ready during the grace period.
+	SwitchRevertStateKept     = "kept"
+	SwitchRevertStateReverted = "reverted"
+	SwitchRevertStateFailed   = "failed"
+	// SwitchRevertStateAbandoned is a switch nobody watched until the end of its grace period,
+	// e.g. because wayne restarted.
+	SwitchRevertStateAbandoned = "abandoned"
+)
+
+type serviceSwitchRevertModel struct{}
+
+// ServiceSwitchRevert is the pending revert of a blue/green switch in a cluster: the previous
+// template is published again if the ready endpoints drop to zero before the deadline.
+type ServiceSwitchRevert struct {
+	Id                 int64      `orm:"auto" json:"id,omitempty"`
+	Service            *Service   `orm:"index;rel(fk)" json:"-"`
+	Cluster            string     `orm:"size(128)" json:"cluster"`
+	PreviousTemplateId int64      `orm:"default(0)" json:"previousTemplateId"`
+	SwitchedTemplateId int64      `orm:"default(0)" json:"switchedTemplateId"`
+	State              string     `orm:"index;size(32)" json:"state"`
+	Message            string     `orm:"null;type(text)" json:"message,omitempty"`
+	Deadline           *time.Time `orm:"type(datetime)" json:"deadline,omitempty"`
+	CreateTime         *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	EndTime            *time.Time `orm:"null;type(datetime)" json:"endTime,omitempty"`
+	User               string     `orm:"size(128)" json:"user,omitempty"`
+
+	ServiceId int64 `orm:"-" json:"serviceId,omitempty"`
+}
+
+func (*ServiceSwitchRevert) TableName() string {
+	return TableNameServiceSwitchRevert
+}
+
+// Add records a pending revert.
+func (*serviceSwitchRevertModel) Add(m *ServiceSwitchRevert) (id int64, err error) {
+	defer observeQuery("serviceSwitchRevertModel.Add", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldCluster, m.Cluster)
+	m.Service = &Service{Id: m.ServiceId}
+	m.State = SwitchRevertStatePending
+	m.CreateTime = nil
+	m.EndTime = nil
+	m.Id, err = Ormer().Insert(m)
+	return m.Id, err
+}
+
+// GetPending returns the reverts still pending, e.g. to resume watching them after a restart.
+func (*serviceSwitchRevertModel) GetPending() (_ []*ServiceSwitchRevert, err error) {
+	defer observeQuery("serviceSwitchRevertModel.GetPending", time.Now(), &err)
+	reverts := []*ServiceSwitchRevert{}
+	_, err = Ormer().
+		QueryTable(new(ServiceSwitchRevert)).
+		Filter("State", SwitchRevertStatePending).
+		All(&reverts)
+	if err != nil {
+		return nil, err
+	}
+	for _, revert := range reverts {
+		revert.ServiceId = revert.Service.Id
+	}
+	return reverts, nil
+}
+
+// Finish ends a pending revert with state. It reports false if it was no longer pending,
+// e.g. finished by another replica watching it too.
+func (*serviceSwitchRevertModel) Finish(m *ServiceSwitchRevert, state string, message string) (_ bool, err error) {
+	defer observeQuery("serviceSwitchRevertModel.Finish", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldCluster, m.Cluster)
+	now := time.Now()
+	num, err := Ormer().
+		QueryTable(new(ServiceSwitchRevert)).
+		Filter("Id", m.Id).
+		Filter("State", SwitchRevertStatePending).
+		Update(map[string]interface{}{
+			"State":   state,
+			"Message": message,
+			"EndTime": &now,
+		})
+	if err != nil {
+		return false, err
+	}
+	m.State = state
+	m.Message = message
+	m.EndTime = &now
+	return num > 0, nil
+}
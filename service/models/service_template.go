@@ -1,21 +1,90 @@
 package models
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
 	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
 )
 
 type serviceTplModel struct{}
 
+var ServiceTplListSortKeys = []SortKey{SortKeyId, SortKeyCreateTime, SortKeyUpdateTime}
+
 func (*serviceTplModel) Add(m *ServiceTemplate) (id int64, err error) {
+	defer observeQuery("serviceTplModel.Add", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
 	m.Service = &Service{Id: m.ServiceId}
 	id, err = Ormer().Insert(m)
-	return
+	return id, apierror.Query(err, fmt.Sprintf("template %s", m.Name))
+}
+
+// AddClaimingNodePorts inserts the template and claims its node ports in one transaction, the
+// template is not saved if another service claimed one of the ports meanwhile.
+func (*serviceTplModel) AddClaimingNodePorts(m *ServiceTemplate, ports []int32) (err error) {
+	defer observeQuery("serviceTplModel.AddClaimingNodePorts", time.Now(), &err, logging.FieldServiceId, m.ServiceId)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	m.Service = &Service{Id: m.ServiceId}
+	if m.Id, err = o.Insert(m); err != nil {
+		return apierror.Query(err, fmt.Sprintf("template %s", m.Name))
+	}
+	return claimNodePorts(o, m.ServiceId, m.Id, ports)
+}
+
+// UpdateClaimingNodePorts updates the template and, if it is the latest template of its service,
+// claims its node ports in one transaction.
+func (*serviceTplModel) UpdateClaimingNodePorts(m *ServiceTemplate, ports []int32) (err error) {
+	defer observeQuery("serviceTplModel.UpdateClaimingNodePorts", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldTemplateId, m.Id)
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+			return
+		}
+		err = o.Commit()
+	}()
+
+	v := ServiceTemplate{Id: m.Id}
+	if err = apierror.Query(o.Read(&v), fmt.Sprintf("template %d", m.Id)); err != nil {
+		return err
+	}
+	m.Service = &Service{Id: m.ServiceId}
+	if _, err = o.Update(m); err != nil {
+		return err
+	}
+	newer, err := o.QueryTable(new(ServiceTemplate)).
+		Filter("Service__Id", m.ServiceId).
+		Filter("Deleted", false).
+		Filter("Id__gt", m.Id).
+		Count()
+	if err != nil || newer > 0 {
+		return err
+	}
+	return claimNodePorts(o, m.ServiceId, m.Id, ports)
 }
 
 func (*serviceTplModel) UpdateById(m *ServiceTemplate) (err error) {
+	defer observeQuery("serviceTplModel.UpdateById", time.Now(), &err, logging.FieldServiceId, m.ServiceId, logging.FieldTemplateId, m.Id)
 	v := ServiceTemplate{Id: m.Id}
 	// ascertain id exists in the database
-	if err = Ormer().Read(&v); err == nil {
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("template %d", m.Id)); err == nil {
 		m.Service = &Service{Id: m.ServiceId}
 		_, err = Ormer().Update(m)
 		return err
@@ -24,9 +93,10 @@ func (*serviceTplModel) UpdateById(m *ServiceTemplate) (err error) {
 }
 
 func (*serviceTplModel) GetById(id int64) (v *ServiceTemplate, err error) {
+	defer observeQuery("serviceTplModel.GetById", time.Now(), &err, logging.FieldTemplateId, id)
 	v = &ServiceTemplate{Id: id}
 
-	if err = Ormer().Read(v); err == nil {
+	if err = apierror.Query(Ormer().Read(v), fmt.Sprintf("template %d", id)); err == nil {
 		_, err = Ormer().LoadRelated(v, "Service")
 		if err == nil {
 			v.ServiceId = v.Service.Id
@@ -37,9 +107,10 @@ func (*serviceTplModel) GetById(id int64) (v *ServiceTemplate, err error) {
 }
 
 func (*serviceTplModel) DeleteById(id int64, logical bool) (err error) {
+	defer observeQuery("serviceTplModel.DeleteById", time.Now(), &err, logging.FieldTemplateId, id)
 	v := ServiceTemplate{Id: id}
 	// ascertain id exists in the database
-	if err = Ormer().Read(&v); err == nil {
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("template %d", id)); err == nil {
 		if logical {
 			v.Deleted = true
 			_, err = Ormer().Update(&v)
@@ -50,3 +121,64 @@ func (*serviceTplModel) DeleteById(id int64, logical bool) (err error) {
 	}
 	return
 }
+
+func serviceTplCursor(sort Sort, tpl *ServiceTemplate) *Cursor {
+	switch sort.Key.Field {
+	case SortKeyCreateTime.Field:
+		return sort.cursor(tpl.CreateTime, tpl.Id)
+	case SortKeyUpdateTime.Field:
+		return sort.cursor(tpl.UpdateTime, tpl.Id)
+	}
+	return sort.cursor(tpl.Id, tpl.Id)
+}
+
+// ListByCursor returns one page of the templates matching filters, ordered by (q.Sort, id).
+// If isOnline is true only templates published to some cluster are returned.
+func (*serviceTplModel) ListByCursor(filters map[string]interface{}, isOnline bool, q *CursorQuery) (_ *CursorPage, err error) {
+	defer observeQuery("serviceTplModel.ListByCursor", time.Now(), &err)
+	qs := Ormer().QueryTable(new(ServiceTemplate))
+	for k, v := range filters {
+		qs = qs.Filter(k, v)
+	}
+	if isOnline {
+		var ids orm.ParamsList
+		_, err := Ormer().
+			QueryTable(new(PublishStatus)).
+			Filter("Type", PublishTypeService).
+			ValuesFlat(&ids, "TemplateId")
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return &CursorPage{List: []ServiceTemplate{}}, nil
+		}
+		qs = qs.Filter("Id__in", ids...)
+	}
+
+	page := &CursorPage{}
+	if !q.SkipTotal {
+		total, err := qs.Count()
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	qs, err = q.querySeter(qs)
+	if err != nil {
+		return nil, err
+	}
+	tpls := []ServiceTemplate{}
+	if _, err = qs.All(&tpls); err != nil {
+		return nil, err
+	}
+	if len(tpls) > q.Limit {
+		tpls = tpls[:q.Limit]
+		page.NextCursor = serviceTplCursor(q.Sort, &tpls[q.Limit-1]).Encode()
+	}
+	for index, tpl := range tpls {
+		tpls[index].ServiceId = tpl.Service.Id
+	}
+	page.List = tpls
+	return page, nil
+}
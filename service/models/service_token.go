@@ -0,0 +1,176 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/Qihoo360/wayne/src/backend/models"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	TableNameServiceToken = "service_token"
+
+	// ServiceTokenPrefix marks plaintext service tokens so they can be told apart from login JWTs.
+	ServiceTokenPrefix = "wst_"
+
+	ServiceScopeRead    = "service:read"
+	ServiceScopeCreate  = "service:create"
+	ServiceScopeUpdate  = "service:update"
+	ServiceScopeDelete  = "service:delete"
+	ServiceScopePublish = "service:publish"
+
+	// last used time is only written back when it is older than this, to avoid a write per request
+	serviceTokenTouchInterval = time.Minute
+)
+
+var ServiceScopes = []string{
+	ServiceScopeRead,
+	ServiceScopeCreate,
+	ServiceScopeUpdate,
+	ServiceScopeDelete,
+	ServiceScopePublish,
+}
+
+type serviceTokenModel struct{}
+
+// ServiceToken is an API token scoped to one app and a set of service actions, used by CI pipelines.
+type ServiceToken struct {
+	Id           int64      `orm:"auto" json:"id,omitempty"`
+	Name         string     `orm:"size(128)" json:"name,omitempty"`
+	TokenHash    string     `orm:"unique;size(64)" json:"-"`
+	App          *App       `orm:"index;rel(fk)" json:"app,omitempty"`
+	Scopes       string     `orm:"size(512)" json:"-"`
+	Description  string     `orm:"null;size(512)" json:"description,omitempty"`
+	ExpireTime   *time.Time `orm:"null;type(datetime)" json:"expireTime,omitempty"`
+	LastUsedTime *time.Time `orm:"null;type(datetime)" json:"lastUsedTime,omitempty"`
+	CreateTime   *time.Time `orm:"auto_now_add;type(datetime)" json:"createTime,omitempty"`
+	User         string     `orm:"size(128)" json:"user,omitempty"`
+	Revoked      bool       `orm:"default(false)" json:"revoked,omitempty"`
+
+	AppId     int64    `orm:"-" json:"appId,omitempty"`
+	ScopeList []string `orm:"-" json:"scopes,omitempty"`
+	// Token is the plaintext token, only returned once on creation.
+	Token string `orm:"-" json:"token,omitempty"`
+}
+
+func (*ServiceToken) TableName() string {
+	return TableNameServiceToken
+}
+
+// HasScope reports whether the token grants scope.
+func (t *ServiceToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token is past its expire time.
+func (t *ServiceToken) Expired() bool {
+	return t.ExpireTime != nil && t.ExpireTime.Before(time.Now())
+}
+
+func hashServiceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *ServiceToken) parse() {
+	if t.App != nil {
+		t.AppId = t.App.Id
+	}
+	t.ScopeList = nil
+	if t.Scopes != "" {
+		t.ScopeList = strings.Split(t.Scopes, ",")
+	}
+}
+
+// Add generates a new token, stores its hash and sets m.Token to the plaintext value.
+func (*serviceTokenModel) Add(m *ServiceToken) (id int64, err error) {
+	defer observeQuery("serviceTokenModel.Add", time.Now(), &err, logging.FieldAppId, m.AppId)
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	m.Token = ServiceTokenPrefix + hex.EncodeToString(buf)
+	m.TokenHash = hashServiceToken(m.Token)
+	m.Scopes = strings.Join(m.ScopeList, ",")
+	m.App = &App{Id: m.AppId}
+	m.CreateTime = nil
+	m.LastUsedTime = nil
+	m.Revoked = false
+	id, err = Ormer().Insert(m)
+	return
+}
+
+func (*serviceTokenModel) ListByAppId(appId int64) (_ []*ServiceToken, err error) {
+	defer observeQuery("serviceTokenModel.ListByAppId", time.Now(), &err, logging.FieldAppId, appId)
+	tokens := []*ServiceToken{}
+	_, err = Ormer().
+		QueryTable(new(ServiceToken)).
+		Filter("App__Id", appId).
+		OrderBy("-Id").
+		All(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		t.parse()
+	}
+	return tokens, nil
+}
+
+func (*serviceTokenModel) GetById(id int64) (v *ServiceToken, err error) {
+	defer observeQuery("serviceTokenModel.GetById", time.Now(), &err)
+	v = &ServiceToken{Id: id}
+
+	if err = apierror.Query(Ormer().Read(v), fmt.Sprintf("token %d", id)); err == nil {
+		v.parse()
+		return v, nil
+	}
+	return nil, err
+}
+
+// GetByToken looks a token up by its plaintext value.
+func (*serviceTokenModel) GetByToken(token string) (v *ServiceToken, err error) {
+	defer observeQuery("serviceTokenModel.GetByToken", time.Now(), &err)
+	v = &ServiceToken{TokenHash: hashServiceToken(token)}
+
+	if err = apierror.Query(Ormer().Read(v, "TokenHash"), "token"); err == nil {
+		v.parse()
+		return v, nil
+	}
+	return nil, err
+}
+
+// Touch records that the token has just been used.
+func (*serviceTokenModel) Touch(m *ServiceToken) (err error) {
+	defer observeQuery("serviceTokenModel.Touch", time.Now(), &err)
+	now := time.Now()
+	if m.LastUsedTime != nil && now.Sub(*m.LastUsedTime) < serviceTokenTouchInterval {
+		return nil
+	}
+	m.LastUsedTime = &now
+	_, err = Ormer().Update(m, "LastUsedTime")
+	return err
+}
+
+func (*serviceTokenModel) Revoke(id int64) (err error) {
+	defer observeQuery("serviceTokenModel.Revoke", time.Now(), &err)
+	v := ServiceToken{Id: id}
+	// ascertain id exists in the database
+	if err = apierror.Query(Ormer().Read(&v), fmt.Sprintf("token %d", id)); err == nil {
+		v.Revoked = true
+		_, err = Ormer().Update(&v, "Revoked")
+		return err
+	}
+	return
+}
@@ -0,0 +1,195 @@
+// Package policy evaluates admin uploaded Rego policies against Services, using the embedded OPA engine.
+//
+// Policies are modules of package wayne.service and deny a Service by adding messages to the deny set:
+//
+//	package wayne.service
+//
+//	deny[msg] {
+//		input.service.spec.type == "NodePort"
+//		input.action == "publish"
+//		msg := "NodePort services can not be published"
+//	}
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// Query is evaluated against the policies, it must produce a set of deny messages.
+	Query = "data.wayne.service.deny"
+
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionPublish = "publish"
+)
+
+// App is the app context of the input.
+type App struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// User is the user context of the input.
+type User struct {
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+// Input is the document policies are evaluated against.
+type Input struct {
+	Service *v1.Service `json:"service"`
+	App     App         `json:"app"`
+	User    User        `json:"user"`
+	Action  string      `json:"action"`
+	// Cluster is set when publishing.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// Denied is returned when policies deny the input.
+type Denied struct {
+	Messages []string `json:"messages"`
+}
+
+func (d *Denied) Error() string {
+	return fmt.Sprintf("denied by policy: %s", strings.Join(d.Messages, "; "))
+}
+
+// Compile checks that module parses, is a wayne.service policy and compiles together with
+// the other modules evaluated along with it, keyed by name. A module conflicting with another
+// one, e.g. defining a rule of the same name with another arity, would fail every evaluation.
+func Compile(name string, module string, others map[string]string) error {
+	parsed, err := ast.ParseModule(name, module)
+	if err != nil {
+		return err
+	}
+	if parsed.Package.Path.String() != "data.wayne.service" {
+		return fmt.Errorf("policy must declare package wayne.service, got %s", parsed.Package.Path)
+	}
+	modules := map[string]*ast.Module{name: parsed}
+	for otherName, other := range others {
+		if otherName == name {
+			continue
+		}
+		parsedOther, err := ast.ParseModule(otherName, other)
+		if err != nil {
+			return fmt.Errorf("policy %s: %v", otherName, err)
+		}
+		modules[otherName] = parsedOther
+	}
+	compiler := ast.NewCompiler()
+	compiler.Compile(modules)
+	if compiler.Failed() {
+		return compiler.Errors
+	}
+	return nil
+}
+
+// Evaluate evaluates the modules, keyed by name, against input and returns the deny messages.
+// The modules are compiled on every call, e.g. to try a draft policy.
+func Evaluate(ctx context.Context, modules map[string]string, input *Input) ([]string, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+	query, err := prepare(ctx, modules)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(ctx, query, input)
+}
+
+// EvaluateCached is Evaluate reusing the compiled query while the module names are unchanged.
+// Names must be unique per module content, e.g. carry the policy version.
+func EvaluateCached(ctx context.Context, modules map[string]string, input *Input) ([]string, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+	query, err := compiled.get(ctx, modules)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(ctx, query, input)
+}
+
+// Check evaluates the modules with EvaluateCached and returns a *Denied error if input is denied.
+func Check(ctx context.Context, modules map[string]string, input *Input) error {
+	messages, err := EvaluateCached(ctx, modules, input)
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		return &Denied{Messages: messages}
+	}
+	return nil
+}
+
+// compiledQuery is the query compiled for the last set of module names, they only change when
+// a policy version is added, enabled or deleted.
+type compiledQuery struct {
+	mu    sync.Mutex
+	key   string
+	query rego.PreparedEvalQuery
+}
+
+var compiled compiledQuery
+
+func (c *compiledQuery) get(ctx context.Context, modules map[string]string) (rego.PreparedEvalQuery, error) {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, "\n")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key == key {
+		return c.query, nil
+	}
+	query, err := prepare(ctx, modules)
+	if err != nil {
+		return query, err
+	}
+	c.key, c.query = key, query
+	return query, nil
+}
+
+func prepare(ctx context.Context, modules map[string]string) (rego.PreparedEvalQuery, error) {
+	options := []func(*rego.Rego){
+		rego.Query(Query),
+	}
+	for name, module := range modules {
+		options = append(options, rego.Module(name, module))
+	}
+	return rego.New(options...).PrepareForEval(ctx)
+}
+
+func evaluate(ctx context.Context, query rego.PreparedEvalQuery, input *Input) ([]string, error) {
+	rs, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	messages := []string{}
+	for _, result := range rs {
+		for _, expression := range result.Expressions {
+			values, ok := expression.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a set of messages, got %T", Query, expression.Value)
+			}
+			for _, value := range values {
+				messages = append(messages, fmt.Sprint(value))
+			}
+		}
+	}
+	sort.Strings(messages)
+	return messages, nil
+}
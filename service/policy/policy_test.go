@@ -0,0 +1,60 @@
+package policy
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	active := map[string]string{
+		"ports.v3.rego": `package wayne.service
+
+deny[msg] {
+	exposed(input.service)
+	msg := "exposed"
+}
+
+exposed(service) {
+	service.spec.type == "NodePort"
+}`,
+	}
+	tests := []struct {
+		name   string
+		module string
+		valid  bool
+	}{
+		{
+			name: "independent rules",
+			module: `package wayne.service
+
+deny[msg] {
+	input.service.spec.type == "LoadBalancer"
+	msg := "no load balancers"
+}`,
+			valid: true,
+		},
+		{
+			name: "same rule with another arity",
+			module: `package wayne.service
+
+exposed(service, cluster) {
+	cluster == "prod"
+}`,
+		},
+		{
+			name: "deny as a complete rule",
+			module: `package wayne.service
+
+deny = ["always"]`,
+		},
+		{
+			name:   "other package",
+			module: "package wayne.deployment\n\ndeny[msg] { msg := \"x\" }",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Compile("new", test.module, active)
+			if (err == nil) != test.valid {
+				t.Fatalf("got %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// FieldManager is the Server-Side Apply field manager of the fields published by wayne.
+const FieldManager = "wayne"
+
+// LegacyFieldManagers are the field managers of the Services published with Update before
+// Server-Side Apply: client-go names them after the binary when no field manager is set.
+var LegacyFieldManagers = []string{filepath.Base(os.Args[0])}
+
+var serviceResource = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+
+// conflict causes read like: conflict with "cloud-controller-manager" using v1: .metadata.annotations.x
+var conflictManagerRegexp = regexp.MustCompile(`conflict with "([^"]+)"`)
+
+// Publisher reads, applies and deletes the Services of one cluster.
+type Publisher interface {
+	// Get returns the live Service, or nil if it does not exist.
+	Get(ctx context.Context, namespace string, name string) (*v1.Service, error)
+	// Apply applies the fields of service with Server-Side Apply. Fields owned by other managers
+	// fail with an *ApplyConflictError unless force is set.
+	Apply(ctx context.Context, service *v1.Service, force bool) (*v1.Service, error)
+	// DryRunApply returns the Service Apply would result in, without persisting it. The fields of
+	// the legacy managers are not handed over, they are kept as Apply would before migrating.
+	DryRunApply(ctx context.Context, service *v1.Service, force bool) (*v1.Service, error)
+	Delete(ctx context.Context, namespace string, name string) error
+}
+
+// ApplyConflict is a field owned by another field manager.
+type ApplyConflict struct {
+	Manager string `json:"manager"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ApplyConflictError is returned when applying would take fields over from other field managers.
+type ApplyConflictError struct {
+	Conflicts []ApplyConflict
+}
+
+func (e *ApplyConflictError) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%s is owned by %s", conflict.Field, conflict.Manager))
+	}
+	return fmt.Sprintf("apply conflicts: %s", strings.Join(conflicts, "; "))
+}
+
+type dynamicPublisher struct {
+	client dynamic.Interface
+	// legacyManagers are migrated to FieldManager before applying.
+	legacyManagers []string
+}
+
+// NewPublisher returns a Publisher using client, which may be a fake dynamic client. The fields
+// owned by legacyManagers with Update are handed over to FieldManager on the first apply.
+func NewPublisher(client dynamic.Interface, legacyManagers ...string) Publisher {
+	return &dynamicPublisher{client: client, legacyManagers: legacyManagers}
+}
+
+// ClusterPublisher returns the Publisher of the cluster.
+func ClusterPublisher(cluster string) (Publisher, error) {
+	c, err := clientsOf(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return NewPublisher(c.dynamic, LegacyFieldManagers...), nil
+}
+
+func (p *dynamicPublisher) Get(ctx context.Context, namespace string, name string) (*v1.Service, error) {
+	obj, err := p.client.Resource(serviceResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(obj)
+}
+
+func (p *dynamicPublisher) Apply(ctx context.Context, service *v1.Service, force bool) (*v1.Service, error) {
+	if err := p.migrateOwnership(ctx, service.Namespace, service.Name); err != nil {
+		return nil, err
+	}
+	return p.apply(ctx, service, metav1.ApplyOptions{FieldManager: FieldManager, Force: force})
+}
+
+func (p *dynamicPublisher) DryRunApply(ctx context.Context, service *v1.Service, force bool) (*v1.Service, error) {
+	return p.apply(ctx, service, metav1.ApplyOptions{FieldManager: FieldManager, Force: force, DryRun: []string{metav1.DryRunAll}})
+}
+
+func (p *dynamicPublisher) apply(ctx context.Context, service *v1.Service, options metav1.ApplyOptions) (*v1.Service, error) {
+	obj, err := applyConfiguration(service)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := p.client.Resource(serviceResource).Namespace(service.Namespace).Apply(ctx, service.Name, obj, options)
+	if err != nil {
+		if errors.IsConflict(err) {
+			if conflictErr := conflictError(err); conflictErr != nil {
+				return nil, conflictErr
+			}
+		}
+		return nil, err
+	}
+	return fromUnstructured(applied)
+}
+
+func (p *dynamicPublisher) Delete(ctx context.Context, namespace string, name string) error {
+	err := p.client.Resource(serviceResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// migrateOwnership hands the fields owned by the legacy managers with Update over to FieldManager,
+// otherwise the first apply would conflict with every field wayne published before. The live
+// managedFields are replaced, guarded by the resourceVersion they were read at.
+func (p *dynamicPublisher) migrateOwnership(ctx context.Context, namespace string, name string) error {
+	if len(p.legacyManagers) == 0 {
+		return nil
+	}
+	live, err := p.client.Resource(serviceResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	managedFields, migrated, err := migrateManagedFields(live.GetManagedFields(), p.legacyManagers)
+	if err != nil || !migrated {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": live.GetResourceVersion(),
+			"managedFields":   managedFields,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.client.Resource(serviceResource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// migrateManagedFields merges the Update entries of legacyManagers into the Apply entry of
+// FieldManager. It reports whether there was anything to migrate.
+func migrateManagedFields(entries []metav1.ManagedFieldsEntry, legacyManagers []string) ([]metav1.ManagedFieldsEntry, bool, error) {
+	legacy := make(map[string]bool, len(legacyManagers))
+	for _, manager := range legacyManagers {
+		legacy[manager] = true
+	}
+
+	var applied *metav1.ManagedFieldsEntry
+	fields := make(map[string]interface{})
+	kept := make([]metav1.ManagedFieldsEntry, 0, len(entries))
+	migrated := false
+	for i, entry := range entries {
+		switch {
+		case entry.Operation == metav1.ManagedFieldsOperationUpdate && legacy[entry.Manager] && entry.Subresource == "":
+			migrated = true
+		case entry.Operation == metav1.ManagedFieldsOperationApply && entry.Manager == FieldManager:
+			applied = &entries[i]
+		default:
+			kept = append(kept, entry)
+			continue
+		}
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		owned := make(map[string]interface{})
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &owned); err != nil {
+			return nil, false, err
+		}
+		mergeFieldSets(fields, owned)
+	}
+	if !migrated {
+		return entries, false, nil
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false, err
+	}
+	entry := metav1.ManagedFieldsEntry{
+		Manager:    FieldManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	}
+	if applied != nil {
+		entry.APIVersion = applied.APIVersion
+		entry.Time = applied.Time
+	}
+	return append(kept, entry), true, nil
+}
+
+// mergeFieldSets adds the fields of src, a FieldsV1 set, to dst.
+func mergeFieldSets(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		srcSet, ok := value.(map[string]interface{})
+		dstSet, exists := dst[key].(map[string]interface{})
+		if ok && exists {
+			mergeFieldSets(dstSet, srcSet)
+			continue
+		}
+		if !exists {
+			dst[key] = value
+		}
+	}
+}
+
+// applyConfiguration is the object sent with Server-Side Apply, only the fields wayne manages.
+func applyConfiguration(service *v1.Service) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(service)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Service")
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj, nil
+}
+
+func fromUnstructured(obj *unstructured.Unstructured) (*v1.Service, error) {
+	service := &v1.Service{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, service); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// conflictError extracts the conflicting fields and their managers from an apply conflict.
+func conflictError(err error) *ApplyConflictError {
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return nil
+	}
+	conflicts := []ApplyConflict{}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflict := ApplyConflict{Field: cause.Field, Message: cause.Message}
+		if match := conflictManagerRegexp.FindStringSubmatch(cause.Message); match != nil {
+			conflict.Manager = match[1]
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return &ApplyConflictError{Conflicts: conflicts}
+}
@@ -0,0 +1,246 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func testService(managedFields ...metav1.ManagedFieldsEntry) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "default",
+			ResourceVersion: "7",
+			ManagedFields:   managedFields,
+		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": "web"},
+			Ports:    []v1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+}
+
+func newFakeDynamicClient(t *testing.T, services ...*v1.Service) *dynamicfake.FakeDynamicClient {
+	objects := make([]runtime.Object, 0, len(services))
+	for _, service := range services {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Service")
+		objects = append(objects, obj)
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{serviceResource: "ServiceList"}, objects...)
+}
+
+// recordPatches answers the patches of services with their body, the fake tracker does not
+// implement Server-Side Apply.
+func recordPatches(client *dynamicfake.FakeDynamicClient, patches map[types.PatchType][][]byte) {
+	client.PrependReactor("patch", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+		patch := action.(ktesting.PatchAction)
+		patches[patch.GetPatchType()] = append(patches[patch.GetPatchType()], patch.GetPatch())
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		obj.SetName(patch.GetName())
+		obj.SetNamespace(patch.GetNamespace())
+		return true, obj, nil
+	})
+}
+
+func TestPublisherGet(t *testing.T) {
+	publisher := NewPublisher(newFakeDynamicClient(t, testService()))
+
+	live, err := publisher.Get(context.Background(), "default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live == nil || live.Spec.Ports[0].Port != 80 {
+		t.Fatalf("got %+v, want the live service", live)
+	}
+
+	missing, err := publisher.Get(context.Background(), "default", "missing")
+	if err != nil || missing != nil {
+		t.Fatalf("got %+v, %v for a missing service, want nil, nil", missing, err)
+	}
+}
+
+func TestPublisherApply(t *testing.T) {
+	client := newFakeDynamicClient(t)
+	patches := make(map[types.PatchType][][]byte)
+	recordPatches(client, patches)
+
+	service := testService()
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	applied, err := NewPublisher(client).Apply(context.Background(), service, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.Name != "web" {
+		t.Fatalf("applied %q, want web", applied.Name)
+	}
+	if len(patches[types.ApplyPatchType]) != 1 {
+		t.Fatalf("sent %d apply patches, want 1", len(patches[types.ApplyPatchType]))
+	}
+
+	sent := make(map[string]interface{})
+	if err := json.Unmarshal(patches[types.ApplyPatchType][0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	obj := &unstructured.Unstructured{Object: sent}
+	if obj.GetKind() != "Service" || obj.GetAPIVersion() != "v1" {
+		t.Errorf("sent %s %s, want v1 Service", obj.GetAPIVersion(), obj.GetKind())
+	}
+	if obj.GetResourceVersion() != "" {
+		t.Errorf("sent resourceVersion %q, want none", obj.GetResourceVersion())
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(sent, "status"); found {
+		t.Error("sent the status")
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(sent, "metadata", "creationTimestamp"); found {
+		t.Error("sent the creationTimestamp")
+	}
+}
+
+func TestPublisherApplyConflict(t *testing.T) {
+	client := newFakeDynamicClient(t)
+	client.PrependReactor("patch", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewApplyConflict([]metav1.StatusCause{{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "cloud-controller-manager" using v1: .metadata.annotations.x`,
+			Field:   ".metadata.annotations.x",
+		}}, "Apply failed with 1 conflict")
+	})
+
+	_, err := NewPublisher(client).Apply(context.Background(), testService(), false)
+	conflictErr, ok := err.(*ApplyConflictError)
+	if !ok {
+		t.Fatalf("got %T %v, want *ApplyConflictError", err, err)
+	}
+	if len(conflictErr.Conflicts) != 1 || conflictErr.Conflicts[0].Manager != "cloud-controller-manager" ||
+		conflictErr.Conflicts[0].Field != ".metadata.annotations.x" {
+		t.Fatalf("got conflicts %+v", conflictErr.Conflicts)
+	}
+}
+
+func TestPublisherMigratesLegacyOwnership(t *testing.T) {
+	legacy := metav1.ManagedFieldsEntry{
+		Manager:    "backend",
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:ports":{},"f:selector":{}}}`)},
+	}
+	other := metav1.ManagedFieldsEntry{
+		Manager:    "kube-controller-manager",
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:finalizers":{}}}`)},
+	}
+	client := newFakeDynamicClient(t, testService(legacy, other))
+	patches := make(map[types.PatchType][][]byte)
+	recordPatches(client, patches)
+
+	if _, err := NewPublisher(client, "backend").Apply(context.Background(), testService(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches[types.MergePatchType]) != 1 {
+		t.Fatalf("sent %d ownership patches, want 1", len(patches[types.MergePatchType]))
+	}
+	var patch struct {
+		Metadata struct {
+			ResourceVersion string                      `json:"resourceVersion"`
+			ManagedFields   []metav1.ManagedFieldsEntry `json:"managedFields"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patches[types.MergePatchType][0], &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Metadata.ResourceVersion != "7" {
+		t.Errorf("patch guarded by resourceVersion %q, want 7", patch.Metadata.ResourceVersion)
+	}
+	managers := make(map[string]metav1.ManagedFieldsEntry)
+	for _, entry := range patch.Metadata.ManagedFields {
+		managers[entry.Manager] = entry
+	}
+	if _, ok := managers["backend"]; ok {
+		t.Error("legacy manager still owns fields")
+	}
+	if _, ok := managers["kube-controller-manager"]; !ok {
+		t.Error("other managers were dropped")
+	}
+	wayne, ok := managers[FieldManager]
+	if !ok || wayne.Operation != metav1.ManagedFieldsOperationApply {
+		t.Fatalf("got %+v, want an Apply entry of %s", wayne, FieldManager)
+	}
+	if string(wayne.FieldsV1.Raw) != `{"f:spec":{"f:ports":{},"f:selector":{}}}` {
+		t.Errorf("%s owns %s", FieldManager, wayne.FieldsV1.Raw)
+	}
+	if len(patches[types.ApplyPatchType]) != 1 {
+		t.Errorf("sent %d apply patches after migrating, want 1", len(patches[types.ApplyPatchType]))
+	}
+}
+
+func TestPublisherSkipsMigratedOwnership(t *testing.T) {
+	applied := metav1.ManagedFieldsEntry{
+		Manager:    FieldManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:ports":{}}}`)},
+	}
+	client := newFakeDynamicClient(t, testService(applied))
+	patches := make(map[types.PatchType][][]byte)
+	recordPatches(client, patches)
+
+	if _, err := NewPublisher(client, "backend").Apply(context.Background(), testService(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches[types.MergePatchType]) != 0 {
+		t.Fatalf("sent %d ownership patches, want none", len(patches[types.MergePatchType]))
+	}
+}
+
+func TestMigrateManagedFields(t *testing.T) {
+	entries := []metav1.ManagedFieldsEntry{
+		{
+			Manager:   FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:type":{}}}`)},
+		},
+		{
+			Manager:   "backend",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:ports":{}}}`)},
+		},
+	}
+	merged, migrated, err := migrateManagedFields(entries, []string{"backend"})
+	if err != nil || !migrated {
+		t.Fatalf("got %v, %v, want migrated", migrated, err)
+	}
+	if len(merged) != 1 || string(merged[0].FieldsV1.Raw) != `{"f:spec":{"f:ports":{},"f:type":{}}}` {
+		t.Fatalf("got %+v", merged)
+	}
+}
+
+func TestPublisherDeleteMissing(t *testing.T) {
+	if err := NewPublisher(newFakeDynamicClient(t)).Delete(context.Background(), "default", "missing"); err != nil {
+		t.Fatalf("deleting a missing service: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelCanaryOf marks the companion Services and Ingresses generated for the canary of a Service.
+	LabelCanaryOf = "wayne.io/canary-of"
+
+	annotationIngressClass = "kubernetes.io/ingress.class"
+	annotationCanary       = "nginx.ingress.kubernetes.io/canary"
+	annotationCanaryWeight = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+// CanaryIngressClasses are the ingress classes supporting weighted canary routing by annotations.
+var CanaryIngressClasses = []string{"nginx"}
+
+// CanaryServiceName is the name of the companion Service of the canary of the Service name.
+func CanaryServiceName(name string) string {
+	return name + "-canary"
+}
+
+// CanaryIngressName is the name of the canary ingress of ingress for the Service name. Ingresses
+// can route to several Services, each running its own canary.
+func CanaryIngressName(ingress string, name string) string {
+	canary := fmt.Sprintf("%s-%s-canary", ingress, name)
+	if len(canary) <= validation.DNS1123SubdomainMaxLength {
+		return canary
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(canary))
+	suffix := fmt.Sprintf("-%08x-canary", hash.Sum32())
+	return canary[:validation.DNS1123SubdomainMaxLength-len(suffix)] + suffix
+}
+
+// CanaryService generates the companion Service of base selecting the canary pods.
+// It only serves the canary traffic in the cluster, so it is a ClusterIP Service.
+func CanaryService(base *v1.Service, selector map[string]string) *v1.Service {
+	canary := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        CanaryServiceName(base.Name),
+			Namespace:   base.Namespace,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string, len(base.Annotations)),
+		},
+		Spec: v1.ServiceSpec{
+			Type:            v1.ServiceTypeClusterIP,
+			Selector:        make(map[string]string),
+			SessionAffinity: base.Spec.SessionAffinity,
+		},
+	}
+	for key, value := range base.Labels {
+		canary.Labels[key] = value
+	}
+	for key, value := range base.Annotations {
+		canary.Annotations[key] = value
+	}
+	canary.Labels[LabelCanaryOf] = base.Name
+	for key, value := range base.Spec.Selector {
+		canary.Spec.Selector[key] = value
+	}
+	for key, value := range selector {
+		canary.Spec.Selector[key] = value
+	}
+	for _, port := range base.Spec.Ports {
+		port.NodePort = 0
+		canary.Spec.Ports = append(canary.Spec.Ports, port)
+	}
+	return canary
+}
+
+// ApplyCanaryIngresses creates or updates, for every ingress routing to the Service name, a canary
+// ingress routing weight percent of its traffic to the companion Service. Ingresses of classes not
+// supporting weighted routing are skipped and returned as warnings.
+func ApplyCanaryIngresses(ctx context.Context, cli kubernetes.Interface, namespace string, name string, weight int) ([]string, error) {
+	ingresses := cli.NetworkingV1().Ingresses(namespace)
+	list, err := ingresses.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []string{}
+	for i := range list.Items {
+		ingress := &list.Items[i]
+		if _, ok := ingress.Labels[LabelCanaryOf]; ok {
+			continue
+		}
+		canary := canaryIngress(ingress, name)
+		if canary == nil {
+			continue
+		}
+		if class := ingressClass(ingress); !supportsCanary(class) {
+			warnings = append(warnings, fmt.Sprintf("ingress %s of class %q does not support weighted canary routing", ingress.Name, class))
+			continue
+		}
+		canary.Annotations[annotationCanaryWeight] = strconv.Itoa(weight)
+
+		live, err := ingresses.Get(ctx, canary.Name, metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			_, err = ingresses.Create(ctx, canary, metav1.CreateOptions{})
+		case err == nil:
+			canary.ResourceVersion = live.ResourceVersion
+			_, err = ingresses.Update(ctx, canary, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return warnings, err
+		}
+		if err := deleteLegacyCanaryIngress(ctx, cli, ingress, name); err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// deleteLegacyCanaryIngress deletes the canary ingress of ingress for the Service name named
+// before CanaryIngressName, it would otherwise route the canary traffic too.
+func deleteLegacyCanaryIngress(ctx context.Context, cli kubernetes.Interface, ingress *networkingv1.Ingress, name string) error {
+	ingresses := cli.NetworkingV1().Ingresses(ingress.Namespace)
+	legacy, err := ingresses.Get(ctx, ingress.Name+"-canary", metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || legacy.Labels[LabelCanaryOf] != name {
+		return err
+	}
+	err = ingresses.Delete(ctx, legacy.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteCanary deletes the canary ingresses and the companion Service of the Service name.
+func DeleteCanary(ctx context.Context, cli kubernetes.Interface, namespace string, name string) error {
+	selector := labels.SelectorFromSet(labels.Set{LabelCanaryOf: name}).String()
+	err := cli.NetworkingV1().Ingresses(namespace).DeleteCollection(ctx, metav1.DeleteOptions{},
+		metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	err = cli.CoreV1().Services(namespace).Delete(ctx, CanaryServiceName(name), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// canaryIngress copies the rules of ingress routing to the Service name, rerouted to its companion
+// Service, or returns nil if ingress does not route to name.
+func canaryIngress(ingress *networkingv1.Ingress, name string) *networkingv1.Ingress {
+	canary := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      CanaryIngressName(ingress.Name, name),
+			Namespace: ingress.Namespace,
+			Labels:    map[string]string{LabelCanaryOf: name},
+			Annotations: map[string]string{
+				annotationCanary: "true",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ingress.Spec.IngressClassName,
+			TLS:              ingress.Spec.TLS,
+		},
+	}
+	if class, ok := ingress.Annotations[annotationIngressClass]; ok {
+		canary.Annotations[annotationIngressClass] = class
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		paths := []networkingv1.HTTPIngressPath{}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service == nil || path.Backend.Service.Name != name {
+				continue
+			}
+			backend := *path.Backend.Service
+			backend.Name = CanaryServiceName(name)
+			path.Backend.Service = &backend
+			paths = append(paths, path)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		canary.Spec.Rules = append(canary.Spec.Rules, networkingv1.IngressRule{
+			Host: rule.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+	if len(canary.Spec.Rules) == 0 {
+		return nil
+	}
+	return canary
+}
+
+func ingressClass(ingress *networkingv1.Ingress) string {
+	if ingress.Spec.IngressClassName != nil {
+		return *ingress.Spec.IngressClassName
+	}
+	return ingress.Annotations[annotationIngressClass]
+}
+
+// supportsCanary reports whether class is one of CanaryIngressClasses.
+func supportsCanary(class string) bool {
+	for _, supported := range CanaryIngressClasses {
+		if class == supported {
+			return true
+		}
+	}
+	return false
+}
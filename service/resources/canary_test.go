@@ -0,0 +1,58 @@
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestCanaryIngressOfSharedIngress(t *testing.T) {
+	path := func(service string) networkingv1.HTTPIngressPath {
+		return networkingv1.HTTPIngressPath{
+			Path: "/" + service,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: service},
+			},
+		}
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "www", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+			Host: "example.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{path("web"), path("api")}},
+			},
+		}}},
+	}
+
+	web, api := canaryIngress(ingress, "web"), canaryIngress(ingress, "api")
+	if web == nil || api == nil {
+		t.Fatalf("got canary ingresses %v and %v", web, api)
+	}
+	if web.Name == api.Name {
+		t.Errorf("both canaries are named %s", web.Name)
+	}
+	if web.Labels[LabelCanaryOf] != "web" || api.Labels[LabelCanaryOf] != "api" {
+		t.Errorf("labeled %v and %v", web.Labels, api.Labels)
+	}
+	if backend := api.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name; backend != CanaryServiceName("api") {
+		t.Errorf("api canary routes to %s", backend)
+	}
+}
+
+func TestCanaryIngressNameLength(t *testing.T) {
+	ingress, name := strings.Repeat("i", 200), strings.Repeat("s", 63)
+	canary := CanaryIngressName(ingress, name)
+	if len(canary) > validation.DNS1123SubdomainMaxLength {
+		t.Errorf("name of length %d", len(canary))
+	}
+	if canary == CanaryIngressName(ingress, strings.Repeat("t", 63)) {
+		t.Errorf("truncated names collide")
+	}
+	if canary := CanaryIngressName("www", "web"); canary != "www-web-canary" {
+		t.Errorf("got %s", canary)
+	}
+}
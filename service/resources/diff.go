@@ -0,0 +1,219 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+)
+
+const (
+	PublishActionCreate    = "create"
+	PublishActionUpdate    = "update"
+	PublishActionUnchanged = "unchanged"
+)
+
+// FieldDiff is a field whose value would change when publishing.
+type FieldDiff struct {
+	Path    string      `json:"path"`
+	Live    interface{} `json:"live,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+// PublishPreview is what publishing a Service would do in one cluster.
+type PublishPreview struct {
+	Cluster string      `json:"cluster"`
+	Action  string      `json:"action"`
+	Diff    []FieldDiff `json:"diff,omitempty"`
+	// Recreate is set when immutable fields change and the Service has to be recreated.
+	Recreate bool     `json:"recreate,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	// State is the state of the publish, pending for a LoadBalancer Service until its load balancer
+	// is provisioned, see the status of the Service.
+	State string `json:"state,omitempty"`
+	// Failure is the error of publishing to the cluster with its status and code.
+	Failure *apierror.Error `json:"failure,omitempty"`
+	// Conflicts are the fields publishing failed to take over from other field managers.
+	Conflicts []ApplyConflict `json:"conflicts,omitempty"`
+}
+
+// prefixes under which the live fields missing from the template are lost when the Service is
+// recreated, other live-only fields are defaulted or allocated by the cluster again
+var ownedPrefixes = []string{
+	"metadata.labels.",
+	"metadata.annotations.",
+	"spec.selector.",
+	"spec.ports[",
+	"spec.externalIPs[",
+	"spec.loadBalancerSourceRanges[",
+}
+
+// Preview computes what publishing desired would change compared to live, nil live means create.
+// applied is the result of a server-side dry-run apply of desired, see Publisher.DryRunApply: the
+// fields of other field managers it kept are not changes. It is nil when the Service has to be
+// recreated, the changes are then the fields of desired and the live fields it drops.
+func Preview(cluster string, live *v1.Service, desired *v1.Service, applied *v1.Service) (*PublishPreview, error) {
+	preview := &PublishPreview{Cluster: cluster}
+	if live == nil {
+		preview.Action = PublishActionCreate
+		return preview, nil
+	}
+
+	liveFields, err := flattenObject(live)
+	if err != nil {
+		return nil, err
+	}
+	if applied != nil {
+		appliedFields, err := flattenObject(applied)
+		if err != nil {
+			return nil, err
+		}
+		preview.Diff = diffFields(liveFields, appliedFields, func(string) bool { return true })
+	} else {
+		desiredFields, err := flattenObject(withLiveDefaults(desired, live))
+		if err != nil {
+			return nil, err
+		}
+		preview.Diff = diffFields(liveFields, desiredFields, owned)
+	}
+
+	changes := ImmutableChanges(live, desired)
+	for _, change := range changes {
+		preview.Warnings = append(preview.Warnings, change.String())
+	}
+	if len(changes) > 0 {
+		preview.Recreate = true
+		preview.Warnings = append(preview.Warnings,
+			"publishing with the recreate strategy deletes the service first, it is unavailable until created again")
+	}
+
+	preview.Action = PublishActionUpdate
+	if len(preview.Diff) == 0 {
+		preview.Action = PublishActionUnchanged
+	}
+	return preview, nil
+}
+
+// diffFields returns the fields of desired which differ from live, and the fields of live missing
+// from desired for which dropped is true.
+func diffFields(liveFields map[string]interface{}, desiredFields map[string]interface{}, dropped func(path string) bool) []FieldDiff {
+	diff := []FieldDiff{}
+	for path, value := range desiredFields {
+		if liveValue, ok := liveFields[path]; !ok || !reflect.DeepEqual(liveValue, value) {
+			diff = append(diff, FieldDiff{Path: path, Live: liveFields[path], Desired: value})
+		}
+	}
+	for path, value := range liveFields {
+		if _, ok := desiredFields[path]; ok || !dropped(path) {
+			continue
+		}
+		diff = append(diff, FieldDiff{Path: path, Live: value})
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Path < diff[j].Path
+	})
+	return diff
+}
+
+// withLiveDefaults fills the fields left empty in desired which the API server defaults
+// or allocates, so they are not reported as changes.
+func withLiveDefaults(desired *v1.Service, live *v1.Service) *v1.Service {
+	service := desired.DeepCopy()
+	spec := &service.Spec
+	if spec.Type == "" {
+		spec.Type = v1.ServiceTypeClusterIP
+	}
+	if spec.SessionAffinity == "" {
+		spec.SessionAffinity = live.Spec.SessionAffinity
+	}
+	if spec.ClusterIP == "" {
+		spec.ClusterIP = live.Spec.ClusterIP
+		spec.ClusterIPs = live.Spec.ClusterIPs
+	}
+	if len(spec.IPFamilies) == 0 {
+		spec.IPFamilies = live.Spec.IPFamilies
+	}
+	if spec.IPFamilyPolicy == nil {
+		spec.IPFamilyPolicy = live.Spec.IPFamilyPolicy
+	}
+	if spec.ExternalTrafficPolicy == "" {
+		spec.ExternalTrafficPolicy = live.Spec.ExternalTrafficPolicy
+	}
+	if spec.InternalTrafficPolicy == nil {
+		spec.InternalTrafficPolicy = live.Spec.InternalTrafficPolicy
+	}
+	for i := range spec.Ports {
+		port := &spec.Ports[i]
+		if port.Protocol == "" {
+			port.Protocol = v1.ProtocolTCP
+		}
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+			port.TargetPort = intstr.FromInt(int(port.Port))
+		}
+		if port.NodePort == 0 && i < len(live.Spec.Ports) && live.Spec.Ports[i].Port == port.Port {
+			port.NodePort = live.Spec.Ports[i].NodePort
+		}
+	}
+	return service
+}
+
+func owned(path string) bool {
+	for _, prefix := range ownedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenObject flattens the metadata and spec of a Service into dotted paths.
+func flattenObject(service *v1.Service) (map[string]interface{}, error) {
+	data, err := json.Marshal(struct {
+		Metadata map[string]interface{} `json:"metadata"`
+		Spec     v1.ServiceSpec         `json:"spec"`
+	}{
+		Metadata: map[string]interface{}{
+			"name":        service.Name,
+			"labels":      service.Labels,
+			"annotations": service.Annotations,
+		},
+		Spec: service.Spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	flatten("", obj, fields)
+	return fields, nil
+}
+
+func flatten(prefix string, value interface{}, fields map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			flatten(path, child, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), child, fields)
+		}
+	case nil:
+	default:
+		fields[prefix] = v
+	}
+}
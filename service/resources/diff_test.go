@@ -0,0 +1,67 @@
+package resources
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func diffPaths(preview *PublishPreview) map[string]FieldDiff {
+	paths := make(map[string]FieldDiff, len(preview.Diff))
+	for _, diff := range preview.Diff {
+		paths[diff.Path] = diff
+	}
+	return paths
+}
+
+func TestPreviewKeepsFieldsOfOtherManagers(t *testing.T) {
+	live := testService()
+	live.Annotations = map[string]string{
+		"service.beta.kubernetes.io/load-balancer-id": "lb-1",
+		"team": "web",
+	}
+	live.Spec.ClusterIP = "10.0.0.1"
+	desired := testService()
+	desired.Annotations = map[string]string{"team": "platform"}
+	// the dry-run apply keeps the annotation of the cloud controller and the allocated IP
+	applied := live.DeepCopy()
+	applied.Annotations["team"] = "platform"
+
+	preview, err := Preview("bj", live, desired, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := diffPaths(preview)
+	if len(paths) != 1 || paths["metadata.annotations.team"].Desired != "platform" {
+		t.Fatalf("got diff %+v, want the team annotation only", preview.Diff)
+	}
+	if preview.Action != PublishActionUpdate {
+		t.Errorf("action %s, want update", preview.Action)
+	}
+
+	preview, err = Preview("bj", live, live.DeepCopy(), live.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Action != PublishActionUnchanged {
+		t.Errorf("got %+v for an unchanged Service", preview.Diff)
+	}
+}
+
+func TestPreviewRecreate(t *testing.T) {
+	live := testService()
+	live.Annotations = map[string]string{"service.beta.kubernetes.io/load-balancer-id": "lb-1"}
+	live.Spec.ClusterIP = v1.ClusterIPNone
+	desired := testService()
+
+	preview, err := Preview("bj", live, desired, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !preview.Recreate {
+		t.Fatal("headless to allocated clusterIP previewed without recreate")
+	}
+	if _, ok := diffPaths(preview)["metadata.annotations.service.beta.kubernetes.io/load-balancer-id"]; !ok {
+		t.Errorf("got diff %+v, want the annotations lost by recreating", preview.Diff)
+	}
+}
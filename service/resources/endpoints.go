@@ -0,0 +1,237 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+const (
+	// managed-by value of the EndpointSlices published by wayne
+	endpointSliceManager = "wayne"
+	// the Endpoints are published along with their EndpointSlices, they must not be mirrored
+	labelSkipMirror = "endpointslice.kubernetes.io/skip-mirror"
+)
+
+// EndpointsPort is a backend port, named after the Service port it serves.
+type EndpointsPort struct {
+	Name     string      `json:"name,omitempty"`
+	Port     int32       `json:"port"`
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+}
+
+// EndpointsSpec are the hand-managed backends of a Service without selector, e.g. a database VM.
+type EndpointsSpec struct {
+	Addresses []string `json:"addresses"`
+	// NotReadyAddresses are published but receive no traffic.
+	NotReadyAddresses []string        `json:"notReadyAddresses,omitempty"`
+	Ports             []EndpointsPort `json:"ports"`
+}
+
+// EndpointsSpecFromTemplate parses an endpoints template.
+func EndpointsSpecFromTemplate(tpl string) (*EndpointsSpec, error) {
+	spec := &EndpointsSpec{}
+	if err := json.Unmarshal(hack.Slice(tpl), spec); err != nil {
+		return nil, fmt.Errorf("endpoints template format error.%v", err.Error())
+	}
+	for i := range spec.Ports {
+		if spec.Ports[i].Protocol == "" {
+			spec.Ports[i].Protocol = v1.ProtocolTCP
+		}
+	}
+	return spec, nil
+}
+
+// ValidateEndpoints returns the problems of spec as the endpoints of service.
+func ValidateEndpoints(spec *EndpointsSpec, service *v1.Service) []string {
+	errs := []string{}
+	if len(service.Spec.Selector) > 0 {
+		errs = append(errs, fmt.Sprintf("service %s has a selector, its endpoints are managed by the cluster", service.Name))
+	}
+	if service.Spec.Type == v1.ServiceTypeExternalName {
+		errs = append(errs, fmt.Sprintf("service %s is an ExternalName service and has no endpoints", service.Name))
+	}
+
+	if len(spec.Addresses)+len(spec.NotReadyAddresses) == 0 {
+		errs = append(errs, "at least one address is required")
+	}
+	seen := map[string]bool{}
+	for _, address := range append(append([]string{}, spec.Addresses...), spec.NotReadyAddresses...) {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			errs = append(errs, fmt.Sprintf("address %s is not an IP", address))
+		case ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast():
+			errs = append(errs, fmt.Sprintf("address %s can not be an endpoint", address))
+		case seen[address]:
+			errs = append(errs, fmt.Sprintf("address %s is listed twice", address))
+		}
+		seen[address] = true
+	}
+
+	// endpoints ports are matched to the Service ports by name
+	servicePorts := map[string]v1.ServicePort{}
+	for _, port := range service.Spec.Ports {
+		servicePorts[port.Name] = port
+	}
+	ports := map[string]bool{}
+	for _, port := range spec.Ports {
+		servicePort, ok := servicePorts[port.Name]
+		switch {
+		case port.Port <= 0 || port.Port > 65535:
+			errs = append(errs, fmt.Sprintf("port %q: %d is not a valid port", port.Name, port.Port))
+		case !ok:
+			errs = append(errs, fmt.Sprintf("port %q: service %s has no port of this name", port.Name, service.Name))
+		case servicePort.Protocol != "" && servicePort.Protocol != port.Protocol:
+			errs = append(errs, fmt.Sprintf("port %q: protocol %s does not match the service port protocol %s",
+				port.Name, port.Protocol, servicePort.Protocol))
+		}
+		ports[port.Name] = true
+	}
+	for name := range servicePorts {
+		if !ports[name] {
+			errs = append(errs, fmt.Sprintf("service port %q has no endpoints port", name))
+		}
+	}
+	return errs
+}
+
+// Endpoints renders the Endpoints of the Service in namespace.
+func Endpoints(spec *EndpointsSpec, namespace string, name string) *v1.Endpoints {
+	subset := v1.EndpointSubset{}
+	for _, address := range spec.Addresses {
+		subset.Addresses = append(subset.Addresses, v1.EndpointAddress{IP: address})
+	}
+	for _, address := range spec.NotReadyAddresses {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, v1.EndpointAddress{IP: address})
+	}
+	for _, port := range spec.Ports {
+		subset.Ports = append(subset.Ports, v1.EndpointPort{Name: port.Name, Port: port.Port, Protocol: port.Protocol})
+	}
+	return &v1.Endpoints{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Endpoints"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{labelSkipMirror: "true"},
+		},
+		Subsets: []v1.EndpointSubset{subset},
+	}
+}
+
+// EndpointSlices renders the EndpointSlices of the Service in namespace, one per address family.
+func EndpointSlices(spec *EndpointsSpec, namespace string, name string) []*discoveryv1.EndpointSlice {
+	slices := map[discoveryv1.AddressType]*discoveryv1.EndpointSlice{}
+	add := func(address string, ready bool) {
+		addressType := discoveryv1.AddressTypeIPv4
+		if net.ParseIP(address).To4() == nil {
+			addressType = discoveryv1.AddressTypeIPv6
+		}
+		slice, ok := slices[addressType]
+		if !ok {
+			slice = &discoveryv1.EndpointSlice{
+				TypeMeta: metav1.TypeMeta{APIVersion: "discovery.k8s.io/v1", Kind: "EndpointSlice"},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      fmt.Sprintf("%s-%s", name, strings.ToLower(string(addressType))),
+					Namespace: namespace,
+					Labels: map[string]string{
+						discoveryv1.LabelServiceName: name,
+						discoveryv1.LabelManagedBy:   endpointSliceManager,
+					},
+				},
+				AddressType: addressType,
+			}
+			for i := range spec.Ports {
+				port := spec.Ports[i]
+				slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{Name: &port.Name, Port: &port.Port, Protocol: &port.Protocol})
+			}
+			slices[addressType] = slice
+		}
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+	for _, address := range spec.Addresses {
+		add(address, true)
+	}
+	for _, address := range spec.NotReadyAddresses {
+		add(address, false)
+	}
+
+	list := []*discoveryv1.EndpointSlice{}
+	for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+		if slice, ok := slices[addressType]; ok {
+			list = append(list, slice)
+		}
+	}
+	return list
+}
+
+// ApplyEndpoints publishes the Endpoints and EndpointSlices of the Service name with Server-Side Apply.
+// It returns PublishActionCreate if the Endpoints did not exist yet, PublishActionUpdate otherwise.
+func ApplyEndpoints(ctx context.Context, cli kubernetes.Interface, spec *EndpointsSpec, namespace string, name string) (string, error) {
+	force := true
+	options := metav1.PatchOptions{FieldManager: FieldManager, Force: &force}
+
+	action := PublishActionUpdate
+	_, err := cli.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		action = PublishActionCreate
+	case err != nil:
+		return "", err
+	}
+	data, err := json.Marshal(Endpoints(spec, namespace, name))
+	if err != nil {
+		return "", err
+	}
+	_, err = cli.CoreV1().Endpoints(namespace).Patch(ctx, name, types.ApplyPatchType, data, options)
+	if err != nil {
+		return "", err
+	}
+	slices := cli.DiscoveryV1().EndpointSlices(namespace)
+	applied := map[string]bool{}
+	for _, slice := range EndpointSlices(spec, namespace, name) {
+		data, err := json.Marshal(slice)
+		if err != nil {
+			return action, err
+		}
+		if _, err = slices.Patch(ctx, slice.Name, types.ApplyPatchType, data, options); err != nil {
+			return action, err
+		}
+		applied[slice.Name] = true
+	}
+
+	// remove the slice of an address family no longer used
+	list, err := slices.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			discoveryv1.LabelServiceName: name,
+			discoveryv1.LabelManagedBy:   endpointSliceManager,
+		}).String(),
+	})
+	if err != nil {
+		return action, err
+	}
+	for _, slice := range list.Items {
+		if applied[slice.Name] {
+			continue
+		}
+		if err := slices.Delete(ctx, slice.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return action, err
+		}
+	}
+	return action, nil
+}
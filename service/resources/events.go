@@ -0,0 +1,324 @@
+package resources
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+)
+
+const (
+	WatchKindEvent     = "Event"
+	WatchKindEndpoints = "Endpoints"
+	// WatchKindError is the failure to open a watch of a cluster, which is retried.
+	WatchKindError = "Error"
+
+	// WatchRetryInterval is how long a failed watch waits before it is opened again.
+	WatchRetryInterval = 5 * time.Second
+)
+
+// Watcher opens the watches of the Events and Endpoints of a Service in a cluster. It is backed by
+// the kubernetes client of the cluster, tests can hand out watch.NewFake watchers instead.
+type Watcher interface {
+	// Watch watches the objects of kind related to the Service name from resourceVersion,
+	// the current state if empty.
+	Watch(ctx context.Context, kind string, namespace string, name string, resourceVersion string) (watch.Interface, error)
+}
+
+type clientWatcher struct {
+	cli kubernetes.Interface
+}
+
+// NewWatcher returns a Watcher backed by cli.
+func NewWatcher(cli kubernetes.Interface) Watcher {
+	return &clientWatcher{cli: cli}
+}
+
+func (w *clientWatcher) Watch(ctx context.Context, kind string, namespace string, name string, resourceVersion string) (watch.Interface, error) {
+	options := metav1.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true}
+	if kind == WatchKindEndpoints {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		return w.cli.CoreV1().Endpoints(namespace).Watch(ctx, options)
+	}
+	options.FieldSelector = fields.Set{
+		"involvedObject.kind": "Service",
+		"involvedObject.name": name,
+	}.AsSelector().String()
+	return w.cli.CoreV1().Events(namespace).Watch(ctx, options)
+}
+
+// ServiceEvent is a Kubernetes Event of a Service, or a change of its Endpoints, in a cluster.
+type ServiceEvent struct {
+	Cluster string          `json:"cluster"`
+	Kind    string          `json:"kind"`
+	Type    watch.EventType `json:"type"`
+	// Event
+	EventType string `json:"eventType,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// Endpoints
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Ready   int      `json:"ready"`
+	// Error
+	Error string `json:"error,omitempty"`
+
+	Time time.Time `json:"time"`
+	// Cursor resumes the stream after this event.
+	Cursor string `json:"-"`
+}
+
+// StreamCursor is the resource version reached by each watch of a stream, keyed by cluster/kind.
+type StreamCursor map[string]string
+
+// ParseStreamCursor parses the cursor of a ServiceEvent, an invalid cursor starts the stream over.
+func ParseStreamCursor(cursor string) StreamCursor {
+	c := StreamCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return StreamCursor{}
+	}
+	return c
+}
+
+func (c StreamCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func streamKey(cluster string, kind string) string {
+	return cluster + "/" + kind
+}
+
+// ServiceEventStream multiplexes the watches of the Events and Endpoints of a Service in several clusters.
+type ServiceEventStream struct {
+	Namespace string
+	Name      string
+	Watchers  map[string]Watcher
+	// RetryInterval is how long a failed watch waits before it is opened again.
+	RetryInterval time.Duration
+
+	mu     sync.Mutex
+	cursor StreamCursor
+}
+
+// NewServiceEventStream returns a stream of the Service name in namespace resuming at cursor.
+func NewServiceEventStream(namespace string, name string, watchers map[string]Watcher, cursor StreamCursor) *ServiceEventStream {
+	if cursor == nil {
+		cursor = StreamCursor{}
+	}
+	return &ServiceEventStream{
+		Namespace:     namespace,
+		Name:          name,
+		Watchers:      watchers,
+		RetryInterval: WatchRetryInterval,
+		cursor:        cursor,
+	}
+}
+
+// Run watches all clusters until ctx is done and sends their events to the returned channel,
+// which is closed once all watches stopped. Closed or expired watches are opened again, a failure
+// to open one is sent as a WatchKindError event before it is retried.
+func (s *ServiceEventStream) Run(ctx context.Context) <-chan *ServiceEvent {
+	events := make(chan *ServiceEvent)
+	var wg sync.WaitGroup
+	for cluster, watcher := range s.Watchers {
+		for _, kind := range []string{WatchKindEvent, WatchKindEndpoints} {
+			wg.Add(1)
+			go func(cluster string, watcher Watcher, kind string) {
+				defer wg.Done()
+				s.watch(ctx, cluster, watcher, kind, events)
+			}(cluster, watcher, kind)
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events
+}
+
+func (s *ServiceEventStream) watch(ctx context.Context, cluster string, watcher Watcher, kind string, events chan<- *ServiceEvent) {
+	key := streamKey(cluster, kind)
+	var addresses map[string]bool
+	for ctx.Err() == nil {
+		resourceVersion := s.resourceVersion(key)
+		w, err := watcher.Watch(ctx, kind, s.Namespace, s.Name, resourceVersion)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logging.FromContext(ctx).With(logging.FieldCluster, cluster).
+				Warning("watch %s of service %s/%s error, retrying in %s.%v", kind, s.Namespace, s.Name, s.RetryInterval, err)
+			event := &ServiceEvent{
+				Cluster: cluster,
+				Kind:    WatchKindError,
+				Type:    watch.Error,
+				Error:   "watch " + kind + ": " + err.Error(),
+				Time:    time.Now(),
+				Cursor:  s.cursorWith(key, resourceVersion),
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case events <- event:
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.RetryInterval):
+			}
+			continue
+		}
+		addresses = s.drain(ctx, cluster, kind, w, addresses, events)
+	}
+}
+
+// drain sends the events of w until it closes or ctx is done, and returns the last endpoint addresses.
+func (s *ServiceEventStream) drain(ctx context.Context, cluster string, kind string, w watch.Interface,
+	addresses map[string]bool, events chan<- *ServiceEvent) map[string]bool {
+	defer w.Stop()
+	key := streamKey(cluster, kind)
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return addresses
+		case e, ok = <-w.ResultChan():
+			if !ok {
+				return addresses
+			}
+		}
+
+		var event *ServiceEvent
+		var resourceVersion string
+		switch obj := e.Object.(type) {
+		case *metav1.Status:
+			if obj.Code == http.StatusGone {
+				// the resource version is compacted, start over from the current state
+				s.setResourceVersion(key, "")
+			}
+			return addresses
+		case *v1.Event:
+			resourceVersion = obj.ResourceVersion
+			if e.Type == watch.Bookmark {
+				s.setResourceVersion(key, resourceVersion)
+				continue
+			}
+			event = &ServiceEvent{
+				Cluster:   cluster,
+				Kind:      kind,
+				Type:      e.Type,
+				EventType: obj.Type,
+				Reason:    obj.Reason,
+				Message:   obj.Message,
+				Time:      eventTime(obj),
+			}
+		case *v1.Endpoints:
+			resourceVersion = obj.ResourceVersion
+			if e.Type == watch.Bookmark {
+				s.setResourceVersion(key, resourceVersion)
+				continue
+			}
+			current := endpointAddresses(obj)
+			if e.Type == watch.Deleted {
+				current = map[string]bool{}
+			}
+			event = &ServiceEvent{Cluster: cluster, Kind: kind, Type: e.Type, Ready: len(current), Time: time.Now()}
+			event.Added, event.Removed = diffAddresses(addresses, current)
+			addresses = current
+		default:
+			continue
+		}
+		// the positions of the other watches only cover the events they sent, resuming
+		// at the cursor may repeat events but never skips one
+		event.Cursor = s.cursorWith(key, resourceVersion)
+
+		select {
+		case <-ctx.Done():
+			return addresses
+		case events <- event:
+			s.setResourceVersion(key, resourceVersion)
+		}
+	}
+}
+
+func (s *ServiceEventStream) resourceVersion(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor[key]
+}
+
+func (s *ServiceEventStream) setResourceVersion(key string, resourceVersion string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor[key] = resourceVersion
+}
+
+func (s *ServiceEventStream) cursorWith(key string, resourceVersion string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cursor := make(StreamCursor, len(s.cursor)+1)
+	for k, v := range s.cursor {
+		cursor[k] = v
+	}
+	cursor[key] = resourceVersion
+	return cursor.String()
+}
+
+func eventTime(event *v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// endpointAddresses returns the ready addresses of endpoints as ip:port.
+func endpointAddresses(endpoints *v1.Endpoints) map[string]bool {
+	addresses := map[string]bool{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if len(subset.Ports) == 0 {
+				addresses[address.IP] = true
+			}
+			for _, port := range subset.Ports {
+				addresses[net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port)))] = true
+			}
+		}
+	}
+	return addresses
+}
+
+// diffAddresses returns the addresses of current missing from previous and the reverse, sorted.
+// A nil previous, the first state seen by the stream, lists all current addresses as added.
+func diffAddresses(previous map[string]bool, current map[string]bool) (added []string, removed []string) {
+	for address := range current {
+		if !previous[address] {
+			added = append(added, address)
+		}
+	}
+	for address := range previous {
+		if !current[address] {
+			removed = append(removed, address)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
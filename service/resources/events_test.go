@@ -0,0 +1,236 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+type watchCall struct {
+	kind            string
+	resourceVersion string
+}
+
+// fakeWatcher hands out the queued watchers, or errors, of each kind in order, then watchers
+// which never send anything. Every Watch call is sent to calls.
+type fakeWatcher struct {
+	mu       sync.Mutex
+	watchers map[string][]*watch.FakeWatcher
+	errs     map[string][]error
+	calls    chan watchCall
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		watchers: make(map[string][]*watch.FakeWatcher),
+		errs:     make(map[string][]error),
+		calls:    make(chan watchCall, 16),
+	}
+}
+
+// queue returns a watcher served to the next Watch of kind, buffered so that events can be sent before.
+func (f *fakeWatcher) queue(kind string) *watch.FakeWatcher {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := watch.NewFakeWithChanSize(8, false)
+	f.watchers[kind] = append(f.watchers[kind], w)
+	return w
+}
+
+func (f *fakeWatcher) fail(kind string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs[kind] = append(f.errs[kind], err)
+}
+
+func (f *fakeWatcher) Watch(ctx context.Context, kind string, namespace string, name string, resourceVersion string) (watch.Interface, error) {
+	f.calls <- watchCall{kind: kind, resourceVersion: resourceVersion}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if errs := f.errs[kind]; len(errs) > 0 {
+		f.errs[kind] = errs[1:]
+		return nil, errs[0]
+	}
+	if watchers := f.watchers[kind]; len(watchers) > 0 {
+		f.watchers[kind] = watchers[1:]
+		return watchers[0], nil
+	}
+	return watch.NewFake(), nil
+}
+
+// nextCall returns the next Watch call of kind, skipping the calls of other kinds.
+func (f *fakeWatcher) nextCall(t *testing.T, kind string) watchCall {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case call := <-f.calls:
+			if call.kind == kind {
+				return call
+			}
+		case <-timeout:
+			t.Fatalf("no watch of %s", kind)
+		}
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan *ServiceEvent) *ServiceEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return nil
+}
+
+func testEvent(resourceVersion string, reason string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web." + resourceVersion, ResourceVersion: resourceVersion},
+		Type:       v1.EventTypeNormal,
+		Reason:     reason,
+	}
+}
+
+func testEndpoints(resourceVersion string, ips ...string) *v1.Endpoints {
+	addresses := make([]v1.EndpointAddress, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, v1.EndpointAddress{IP: ip})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", ResourceVersion: resourceVersion},
+		Subsets:    []v1.EndpointSubset{{Addresses: addresses, Ports: []v1.EndpointPort{{Port: 8080}}}},
+	}
+}
+
+func runStream(t *testing.T, watchers map[string]Watcher, cursor StreamCursor) (<-chan *ServiceEvent, func()) {
+	stream := NewServiceEventStream("default", "web", watchers, cursor)
+	stream.RetryInterval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	events := stream.Run(ctx)
+	return events, func() {
+		cancel()
+		for range events {
+		}
+	}
+}
+
+func TestServiceEventStreamMultiplexesClusters(t *testing.T) {
+	a, b := newFakeWatcher(), newFakeWatcher()
+	a.queue(WatchKindEvent).Add(testEvent("3", "EnsuringLoadBalancer"))
+	b.queue(WatchKindEndpoints).Add(testEndpoints("9", "10.0.0.1"))
+
+	events, stop := runStream(t, map[string]Watcher{"a": a, "b": b}, nil)
+	defer stop()
+
+	got := map[string]*ServiceEvent{}
+	for i := 0; i < 2; i++ {
+		event := nextEvent(t, events)
+		got[streamKey(event.Cluster, event.Kind)] = event
+	}
+	if event := got["a/Event"]; event == nil || event.Reason != "EnsuringLoadBalancer" || event.Type != watch.Added {
+		t.Errorf("got %+v, want the Event of cluster a", event)
+	}
+	if event := got["b/Endpoints"]; event == nil || event.Ready != 1 {
+		t.Errorf("got %+v, want the Endpoints of cluster b", event)
+	}
+}
+
+func TestServiceEventStreamResumesFromCursor(t *testing.T) {
+	a := newFakeWatcher()
+	a.queue(WatchKindEvent).Add(testEvent("6", "Created"))
+
+	cursor := ParseStreamCursor(StreamCursor{"a/Event": "5", "a/Endpoints": "40"}.String())
+	events, stop := runStream(t, map[string]Watcher{"a": a}, cursor)
+	defer stop()
+
+	event := nextEvent(t, events)
+	calls := map[string]string{}
+	for len(calls) < 2 {
+		call := <-a.calls
+		calls[call.kind] = call.resourceVersion
+	}
+	if calls[WatchKindEvent] != "5" || calls[WatchKindEndpoints] != "40" {
+		t.Errorf("watched from %v, want the versions of the cursor", calls)
+	}
+	want := StreamCursor{"a/Event": "6", "a/Endpoints": "40"}
+	if got := ParseStreamCursor(event.Cursor); !reflect.DeepEqual(got, want) {
+		t.Errorf("event cursor %v, want %v", got, want)
+	}
+}
+
+func TestServiceEventStreamRestartsAfterGone(t *testing.T) {
+	a := newFakeWatcher()
+	a.queue(WatchKindEvent).Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+	a.queue(WatchKindEvent).Add(testEvent("100", "Created"))
+
+	events, stop := runStream(t, map[string]Watcher{"a": a}, StreamCursor{"a/Event": "5"})
+	defer stop()
+
+	if call := a.nextCall(t, WatchKindEvent); call.resourceVersion != "5" {
+		t.Fatalf("first watch from %q, want 5", call.resourceVersion)
+	}
+	if call := a.nextCall(t, WatchKindEvent); call.resourceVersion != "" {
+		t.Fatalf("watch after 410 Gone from %q, want the current state", call.resourceVersion)
+	}
+	if event := nextEvent(t, events); event.Reason != "Created" {
+		t.Fatalf("got %+v after the restart", event)
+	}
+}
+
+func TestServiceEventStreamDiffsEndpoints(t *testing.T) {
+	a := newFakeWatcher()
+	w := a.queue(WatchKindEndpoints)
+	w.Add(testEndpoints("1", "10.0.0.1", "10.0.0.2"))
+	w.Modify(testEndpoints("2", "10.0.0.2", "10.0.0.3"))
+	w.Delete(testEndpoints("3", "10.0.0.2", "10.0.0.3"))
+
+	events, stop := runStream(t, map[string]Watcher{"a": a}, nil)
+	defer stop()
+
+	tests := []struct {
+		added   []string
+		removed []string
+		ready   int
+	}{
+		{added: []string{"10.0.0.1:8080", "10.0.0.2:8080"}, ready: 2},
+		{added: []string{"10.0.0.3:8080"}, removed: []string{"10.0.0.1:8080"}, ready: 2},
+		{removed: []string{"10.0.0.2:8080", "10.0.0.3:8080"}},
+	}
+	for i, test := range tests {
+		event := nextEvent(t, events)
+		if !reflect.DeepEqual(event.Added, test.added) || !reflect.DeepEqual(event.Removed, test.removed) || event.Ready != test.ready {
+			t.Errorf("%d: got added %v removed %v ready %d, want %v %v %d",
+				i, event.Added, event.Removed, event.Ready, test.added, test.removed, test.ready)
+		}
+	}
+}
+
+func TestServiceEventStreamReportsWatchFailures(t *testing.T) {
+	a := newFakeWatcher()
+	a.fail(WatchKindEvent, errors.New("connection refused"))
+	a.queue(WatchKindEvent).Add(testEvent("7", "Created"))
+
+	events, stop := runStream(t, map[string]Watcher{"a": a}, nil)
+	defer stop()
+
+	event := nextEvent(t, events)
+	if event.Kind != WatchKindError || event.Cluster != "a" || event.Error != "watch Event: connection refused" {
+		t.Fatalf("got %+v, want the failure of the watch", event)
+	}
+	if event := nextEvent(t, events); event.Reason != "Created" {
+		t.Fatalf("got %+v after the retry", event)
+	}
+}
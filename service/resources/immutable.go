@@ -0,0 +1,110 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const recreateTimeout = 60 * time.Second
+
+// ImmutableChange is a change of a live Service the API server rejects on update,
+// the Service has to be deleted and created again to apply it.
+type ImmutableChange struct {
+	Field   string `json:"field"`
+	Live    string `json:"live"`
+	Desired string `json:"desired"`
+}
+
+func (c ImmutableChange) String() string {
+	return fmt.Sprintf("%s can not be changed from %q to %q without recreating the service", c.Field, c.Live, c.Desired)
+}
+
+// ImmutableChanges returns the changes from live to desired which can not be applied by an update.
+// Only fields set in desired are considered, empty ones keep the live value, except the clusterIP
+// of a headless Service.
+func ImmutableChanges(live *v1.Service, desired *v1.Service) []ImmutableChange {
+	if live == nil {
+		return nil
+	}
+	changes := []ImmutableChange{}
+	desiredType := desired.Spec.Type
+	if desiredType == "" {
+		desiredType = v1.ServiceTypeClusterIP
+	}
+
+	// a headless Service stays headless: an empty clusterIP does not keep the live None, it asks
+	// for an allocated IP, and an allocated IP can not become None
+	headlessChange := (live.Spec.ClusterIP == v1.ClusterIPNone) != (desired.Spec.ClusterIP == v1.ClusterIPNone)
+	ipChange := desired.Spec.ClusterIP != "" && desired.Spec.ClusterIP != live.Spec.ClusterIP
+	if desiredType != v1.ServiceTypeExternalName && live.Spec.ClusterIP != "" && (headlessChange || ipChange) {
+		changes = append(changes, ImmutableChange{
+			Field:   "spec.clusterIP",
+			Live:    live.Spec.ClusterIP,
+			Desired: desired.Spec.ClusterIP,
+		})
+	}
+
+	// the primary family can not change, adding or dropping the secondary one is allowed
+	if len(desired.Spec.IPFamilies) > 0 && len(live.Spec.IPFamilies) > 0 &&
+		desired.Spec.IPFamilies[0] != live.Spec.IPFamilies[0] {
+		changes = append(changes, ImmutableChange{
+			Field:   "spec.ipFamilies[0]",
+			Live:    string(live.Spec.IPFamilies[0]),
+			Desired: string(desired.Spec.IPFamilies[0]),
+		})
+	}
+
+	// moving to or from ExternalName releases or allocates the cluster IP
+	if desiredType != live.Spec.Type &&
+		(desiredType == v1.ServiceTypeExternalName || live.Spec.Type == v1.ServiceTypeExternalName) {
+		changes = append(changes, ImmutableChange{
+			Field:   "spec.type",
+			Live:    string(live.Spec.Type),
+			Desired: string(desiredType),
+		})
+	}
+	return changes
+}
+
+const (
+	// StrategyUpdate updates the live Service in place, the default.
+	StrategyUpdate = "update"
+	// StrategyRecreate deletes the live Service and creates it again, the Service is unavailable in between.
+	StrategyRecreate = "recreate"
+)
+
+// RecreateRequiredError is returned when publishing with StrategyUpdate would change immutable fields.
+type RecreateRequiredError struct {
+	Cluster string
+	Changes []ImmutableChange
+}
+
+func (e *RecreateRequiredError) Error() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, change.String())
+	}
+	return fmt.Sprintf("service in cluster %s must be recreated: %s, publish with the %s strategy",
+		e.Cluster, strings.Join(changes, "; "), StrategyRecreate)
+}
+
+// RecreateService deletes the live Service, waits until it is gone and applies service.
+func RecreateService(ctx context.Context, publisher Publisher, service *v1.Service, force bool) (*v1.Service, error) {
+	if err := publisher.Delete(ctx, service.Namespace, service.Name); err != nil {
+		return nil, err
+	}
+	// load balancer finalizers keep the Service until the cloud resources are released
+	err := wait.PollImmediate(time.Second, recreateTimeout, func() (bool, error) {
+		live, err := publisher.Get(ctx, service.Namespace, service.Name)
+		return live == nil, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("wait for service %s to be deleted error.%v", service.Name, err)
+	}
+	return publisher.Apply(ctx, service, force)
+}
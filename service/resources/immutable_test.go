@@ -0,0 +1,41 @@
+package resources
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestImmutableClusterIPChanges(t *testing.T) {
+	service := func(serviceType v1.ServiceType, clusterIP string) *v1.Service {
+		return &v1.Service{Spec: v1.ServiceSpec{Type: serviceType, ClusterIP: clusterIP}}
+	}
+	tests := []struct {
+		name    string
+		live    *v1.Service
+		desired *v1.Service
+		change  bool
+	}{
+		{name: "allocated ip kept", live: service(v1.ServiceTypeClusterIP, "10.0.0.1"), desired: service("", "")},
+		{name: "same ip", live: service(v1.ServiceTypeClusterIP, "10.0.0.1"), desired: service("", "10.0.0.1")},
+		{name: "other ip", live: service(v1.ServiceTypeClusterIP, "10.0.0.1"), desired: service("", "10.0.0.2"), change: true},
+		{name: "headless kept", live: service(v1.ServiceTypeClusterIP, v1.ClusterIPNone), desired: service("", v1.ClusterIPNone)},
+		{name: "headless to allocated", live: service(v1.ServiceTypeClusterIP, v1.ClusterIPNone), desired: service("", ""), change: true},
+		{name: "headless to ip", live: service(v1.ServiceTypeClusterIP, v1.ClusterIPNone), desired: service("", "10.0.0.2"), change: true},
+		{name: "allocated to headless", live: service(v1.ServiceTypeClusterIP, "10.0.0.1"), desired: service("", v1.ClusterIPNone), change: true},
+		{name: "external name to headless", live: service(v1.ServiceTypeExternalName, ""), desired: service("", v1.ClusterIPNone)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			change := false
+			for _, c := range ImmutableChanges(test.live, test.desired) {
+				if c.Field == "spec.clusterIP" {
+					change = true
+				}
+			}
+			if change != test.change {
+				t.Errorf("clusterIP change %v, want %v", change, test.change)
+			}
+		})
+	}
+}
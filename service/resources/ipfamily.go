@@ -0,0 +1,83 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+// clusterNetwork is the part of the cluster metadata describing its service network, e.g.
+// {"ipFamilies": ["IPv4", "IPv6"]} for a dual-stack cluster.
+type clusterNetwork struct {
+	IPFamilies []v1.IPFamily `json:"ipFamilies,omitempty"`
+}
+
+// ClusterIPFamilies returns the IP families of the service network of a cluster from its metadata,
+// clusters without ipFamilies are IPv4 single-stack.
+func ClusterIPFamilies(metaData string) ([]v1.IPFamily, error) {
+	network := clusterNetwork{}
+	if metaData != "" {
+		if err := json.Unmarshal(hack.Slice(metaData), &network); err != nil {
+			return nil, fmt.Errorf("cluster metadata format error.%v", err.Error())
+		}
+	}
+	if len(network.IPFamilies) == 0 {
+		return []v1.IPFamily{v1.IPv4Protocol}, nil
+	}
+	return network.IPFamilies, nil
+}
+
+// CheckIPFamilies checks the ipFamilies and ipFamilyPolicy of service against the IP families of
+// a cluster. Requests the cluster can not serve are errors, dual-stack preferred on a
+// single-stack cluster only a warning as the Service falls back to single-stack.
+func CheckIPFamilies(service *v1.Service, clusterFamilies []v1.IPFamily) (errs []string, warnings []string) {
+	if service.Spec.Type == v1.ServiceTypeExternalName {
+		return nil, nil
+	}
+	supported := map[v1.IPFamily]bool{}
+	for _, family := range clusterFamilies {
+		supported[family] = true
+	}
+	for _, family := range service.Spec.IPFamilies {
+		if !supported[family] {
+			errs = append(errs, fmt.Sprintf("ipFamilies: the cluster does not support %s services", family))
+		}
+	}
+
+	if service.Spec.IPFamilyPolicy == nil || len(clusterFamilies) > 1 {
+		return errs, warnings
+	}
+	switch *service.Spec.IPFamilyPolicy {
+	case v1.IPFamilyPolicyRequireDualStack:
+		errs = append(errs, "ipFamilyPolicy: dual-stack is required but the cluster is single-stack")
+	case v1.IPFamilyPolicyPreferDualStack:
+		warnings = append(warnings, fmt.Sprintf("ipFamilyPolicy: dual-stack is preferred but the cluster is single-stack, only %s is assigned",
+			clusterFamilies[0]))
+	}
+	return errs, warnings
+}
+
+// ClusterIPsByFamily groups the assigned cluster IPs of a live Service by IP family.
+func ClusterIPsByFamily(service *v1.Service) map[v1.IPFamily]string {
+	ips := map[v1.IPFamily]string{}
+	clusterIPs := service.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && service.Spec.ClusterIP != "" {
+		clusterIPs = []string{service.Spec.ClusterIP}
+	}
+	for _, clusterIP := range clusterIPs {
+		ip := net.ParseIP(clusterIP)
+		switch {
+		case ip == nil:
+			// headless, clusterIP None
+		case ip.To4() != nil:
+			ips[v1.IPv4Protocol] = clusterIP
+		default:
+			ips[v1.IPv6Protocol] = clusterIP
+		}
+	}
+	return ips
+}
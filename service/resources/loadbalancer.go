@@ -0,0 +1,78 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LoadBalancerCheckInterval is how often WatchLoadBalancer checks the Service.
+const LoadBalancerCheckInterval = 5 * time.Second
+
+// LoadBalancerIngress returns the IPs and hostnames of the provisioned load balancer of service.
+func LoadBalancerIngress(service *v1.Service) []string {
+	ingress := []string{}
+	for _, i := range service.Status.LoadBalancer.Ingress {
+		switch {
+		case i.IP != "":
+			ingress = append(ingress, i.IP)
+		case i.Hostname != "":
+			ingress = append(ingress, i.Hostname)
+		}
+	}
+	return ingress
+}
+
+// LatestWarning returns the message of the latest warning event of the Service, e.g. a quota error
+// of the cloud provider, empty if there is none.
+func LatestWarning(ctx context.Context, cli kubernetes.Interface, namespace string, name string) (string, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "Service",
+		"involvedObject.name": name,
+		"type":                v1.EventTypeWarning,
+	}.AsSelector().String()
+	events, err := cli.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil || len(events.Items) == 0 {
+		return "", err
+	}
+	sort.Slice(events.Items, func(i, j int) bool {
+		return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+	})
+	latest := events.Items[len(events.Items)-1]
+	return fmt.Sprintf("%s: %s", latest.Reason, latest.Message), nil
+}
+
+// WatchLoadBalancer checks the Service until its load balancer is provisioned, timeout elapses or ctx
+// is done, and returns the ingress of the load balancer, empty if it was not provisioned in time.
+// Warning events of the Service are passed to warned as they show up. Failed checks are retried on
+// the next interval.
+func WatchLoadBalancer(ctx context.Context, cli kubernetes.Interface, namespace string, name string,
+	timeout time.Duration, warned func(message string)) []string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ingress := []string{}
+	lastWarning := ""
+	wait.Until(func() {
+		service, err := GetService(ctx, cli, namespace, name)
+		if err != nil || service == nil {
+			return
+		}
+		if ingress = LoadBalancerIngress(service); len(ingress) > 0 {
+			cancel()
+			return
+		}
+		if warning, err := LatestWarning(ctx, cli, namespace, name); err == nil && warning != "" && warning != lastWarning {
+			lastWarning = warning
+			warned(warning)
+		}
+	}, LoadBalancerCheckInterval, ctx.Done())
+	return ingress
+}
@@ -0,0 +1,57 @@
+package resources
+
+import (
+	"context"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LiveNodePort is a node port allocated to a Service in a cluster.
+type LiveNodePort struct {
+	Port      int32  `json:"port"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// NodePorts returns the node ports set in the ports of service, the ones left empty
+// are allocated by the cluster.
+func NodePorts(service *v1.Service) []int32 {
+	ports := []int32{}
+	seen := map[int32]bool{}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 && !seen[port.NodePort] {
+			seen[port.NodePort] = true
+			ports = append(ports, port.NodePort)
+		}
+	}
+	return ports
+}
+
+// FreeNodePorts returns up to count ports of [min, max] not in used, lowest first.
+func FreeNodePorts(min int32, max int32, used map[int32]bool, count int) []int32 {
+	free := []int32{}
+	for port := min; port <= max && len(free) < count; port++ {
+		if !used[port] {
+			free = append(free, port)
+		}
+	}
+	return free
+}
+
+// ListNodePorts returns the node ports allocated to the Services of all namespaces of a cluster.
+func ListNodePorts(ctx context.Context, cli kubernetes.Interface) ([]LiveNodePort, error) {
+	services, err := cli.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ports := []LiveNodePort{}
+	for i := range services.Items {
+		service := &services.Items[i]
+		for _, port := range NodePorts(service) {
+			ports = append(ports, LiveNodePort{Port: port, Namespace: service.Namespace, Name: service.Name})
+		}
+	}
+	return ports, nil
+}
@@ -0,0 +1,105 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ProbeModeHTTP = "http"
+	ProbeModeTCP  = "tcp"
+)
+
+// ServiceProxy sends GET requests to a Service port through the service proxy of the API server.
+// It is backed by the kubernetes client of a cluster, tests can stand in a local HTTP server.
+type ServiceProxy interface {
+	// Get requests path of the port of the Service name and returns the status code of the response.
+	Get(ctx context.Context, namespace string, name string, port string, path string) (int, error)
+}
+
+type clientServiceProxy struct {
+	cli kubernetes.Interface
+}
+
+// NewServiceProxy returns a ServiceProxy backed by cli.
+func NewServiceProxy(cli kubernetes.Interface) ServiceProxy {
+	return &clientServiceProxy{cli: cli}
+}
+
+func (p *clientServiceProxy) Get(ctx context.Context, namespace string, name string, port string, path string) (int, error) {
+	statusCode := 0
+	err := p.cli.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("services").
+		SubResource("proxy").
+		Name(utilnet.JoinSchemeNamePort("", name, port)).
+		Suffix(path).
+		Do(ctx).
+		StatusCode(&statusCode).
+		Error()
+	if status, ok := err.(errors.APIStatus); ok && statusCode == 0 {
+		// the client drops the status code of error responses it has no decoder for, e.g. text/plain
+		statusCode = int(status.Status().Code)
+	}
+	return statusCode, err
+}
+
+// ProbeResult is the outcome of a probe of a Service port in a cluster.
+type ProbeResult struct {
+	Cluster string `json:"cluster"`
+	Port    string `json:"port"`
+	Mode    string `json:"mode"`
+	// Reachable is whether the proxy connected to an endpoint of the port.
+	Reachable bool `json:"reachable"`
+	// StatusCode is the status of the HTTP response of the endpoint, http probes only.
+	StatusCode int   `json:"statusCode,omitempty"`
+	LatencyMs  int64 `json:"latencyMs"`
+	// Reason is the reason of the API server failing the probe, e.g. Forbidden when RBAC denies
+	// services/proxy or ServiceUnavailable without endpoints.
+	Reason metav1.StatusReason `json:"reason,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// Probe probes the port of the Service name through proxy. An http probe succeeds with a 2xx or 3xx
+// response. The service proxy only speaks HTTP, so a tcp probe succeeds as soon as the proxy reached
+// an endpoint, whatever the endpoint answered.
+func Probe(ctx context.Context, proxy ServiceProxy, namespace string, name string, port string, mode string,
+	path string, timeout time.Duration) *ProbeResult {
+	result := &ProbeResult{Port: port, Mode: mode}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	statusCode, err := proxy.Get(ctx, namespace, name, port, path)
+	result.LatencyMs = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+
+	if err != nil && !isEndpointResponse(err) {
+		result.Reason = errors.ReasonForError(err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Reachable = true
+	if mode == ProbeModeHTTP {
+		result.StatusCode = statusCode
+		if statusCode < http.StatusOK || statusCode >= http.StatusBadRequest {
+			result.Error = fmt.Sprintf("unexpected status %d", statusCode)
+		}
+	}
+	return result
+}
+
+// isEndpointResponse reports whether err is an error response relayed from an endpoint rather than a
+// failure of the API server. The API server answers its own failures with a Status, e.g. Forbidden when
+// RBAC denies services/proxy, NotFound for a missing Service, ServiceUnavailable without endpoints or
+// InternalError when dialing the endpoint failed. Relayed responses are no Status and the client
+// returns them as unexpected server responses.
+func isEndpointResponse(err error) bool {
+	return errors.IsUnexpectedServerError(err)
+}
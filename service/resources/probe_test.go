@@ -0,0 +1,153 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// apiServerStatus answers like the API server failing the proxy request itself.
+func apiServerStatus(code int, reason metav1.StatusReason, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure","message":%q,"reason":%q,"code":%d}`,
+			message, reason, code)
+	}
+}
+
+// endpointResponse answers like an endpoint whose response the service proxy relays.
+func endpointResponse(code int, contentType string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}
+}
+
+// newStandInProxy returns a ServiceProxy whose API server is a local HTTP server answering the
+// service proxy requests of the Service web with handler.
+func newStandInProxy(t *testing.T, handler http.HandlerFunc) ServiceProxy {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/services/web:http/proxy/healthz" {
+			t.Errorf("proxied %s", r.URL.Path)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	cli, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServiceProxy(cli)
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		handler    http.HandlerFunc
+		reachable  bool
+		statusCode int
+		reason     metav1.StatusReason
+		failed     bool
+	}{
+		{
+			name:       "http ok",
+			mode:       ProbeModeHTTP,
+			handler:    endpointResponse(http.StatusOK, "text/plain", "ok"),
+			reachable:  true,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "http endpoint error",
+			mode:       ProbeModeHTTP,
+			handler:    endpointResponse(http.StatusInternalServerError, "text/plain", "boom"),
+			reachable:  true,
+			statusCode: http.StatusInternalServerError,
+			failed:     true,
+		},
+		{
+			name:       "http endpoint not found json",
+			mode:       ProbeModeHTTP,
+			handler:    endpointResponse(http.StatusNotFound, "application/json", `{"error":"no route"}`),
+			reachable:  true,
+			statusCode: http.StatusNotFound,
+			failed:     true,
+		},
+		{
+			name:      "tcp endpoint not found",
+			mode:      ProbeModeTCP,
+			handler:   endpointResponse(http.StatusNotFound, "text/html", "<h1>not found</h1>"),
+			reachable: true,
+		},
+		{
+			name:    "services/proxy forbidden",
+			mode:    ProbeModeTCP,
+			handler: apiServerStatus(http.StatusForbidden, metav1.StatusReasonForbidden, `services "web" is forbidden: User "wayne" cannot get resource "services/proxy"`),
+			reason:  metav1.StatusReasonForbidden,
+			failed:  true,
+		},
+		{
+			name:    "unauthorized",
+			mode:    ProbeModeHTTP,
+			handler: apiServerStatus(http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "Unauthorized"),
+			reason:  metav1.StatusReasonUnauthorized,
+			failed:  true,
+		},
+		{
+			name:    "service missing",
+			mode:    ProbeModeHTTP,
+			handler: apiServerStatus(http.StatusNotFound, metav1.StatusReasonNotFound, `services "web" not found`),
+			reason:  metav1.StatusReasonNotFound,
+			failed:  true,
+		},
+		{
+			name:    "no endpoints",
+			mode:    ProbeModeTCP,
+			handler: apiServerStatus(http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, `no endpoints available for service "web:http"`),
+			reason:  metav1.StatusReasonServiceUnavailable,
+			failed:  true,
+		},
+		{
+			name:    "dial failure",
+			mode:    ProbeModeTCP,
+			handler: apiServerStatus(http.StatusInternalServerError, metav1.StatusReasonInternalError, "error trying to reach service: dial tcp 10.0.0.1:8080: connect: connection refused"),
+			reason:  metav1.StatusReasonInternalError,
+			failed:  true,
+		},
+		{
+			name: "timeout",
+			mode: ProbeModeTCP,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			failed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := newStandInProxy(t, test.handler)
+			result := Probe(context.Background(), proxy, "default", "web", "http", test.mode, "/healthz", time.Second)
+			if result.Reachable != test.reachable {
+				t.Errorf("reachable %v, want %v (%s)", result.Reachable, test.reachable, result.Error)
+			}
+			if result.StatusCode != test.statusCode {
+				t.Errorf("status code %d, want %d", result.StatusCode, test.statusCode)
+			}
+			if result.Reason != test.reason {
+				t.Errorf("reason %q, want %q", result.Reason, test.reason)
+			}
+			if (result.Error != "") != test.failed {
+				t.Errorf("error %q, want failed %v", result.Error, test.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package resources
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EndpointsCheckInterval is how often WatchReadyEndpoints checks the endpoints.
+const EndpointsCheckInterval = 5 * time.Second
+
+// ReadyPods returns the number of ready pods in namespace matching selector.
+func ReadyPods(ctx context.Context, cli kubernetes.Interface, namespace string, selector map[string]string) (int, error) {
+	pods, err := cli.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil && podReady(&pod) {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+func podReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ReadyEndpoints returns the number of ready addresses of the Service, 0 if it has no Endpoints.
+func ReadyEndpoints(ctx context.Context, cli kubernetes.Interface, namespace string, name string) (int, error) {
+	endpoints, err := cli.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+	}
+	return ready, nil
+}
+
+// EndpointCounts returns the number of ready and not ready addresses of the Service, 0 if it has no Endpoints.
+func EndpointCounts(ctx context.Context, cli kubernetes.Interface, namespace string, name string) (ready int, notReady int, err error) {
+	endpoints, err := cli.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+		notReady += len(subset.NotReadyAddresses)
+	}
+	return ready, notReady, nil
+}
+
+// WatchReadyEndpoints checks the ready endpoints of the Service until grace elapses or ctx is done,
+// and reports whether they dropped to zero meanwhile. Failed checks are retried on the next interval.
+func WatchReadyEndpoints(ctx context.Context, cli kubernetes.Interface, namespace string, name string, grace time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+
+	dropped := false
+	wait.Until(func() {
+		if ready, err := ReadyEndpoints(ctx, cli, namespace, name); err == nil && ready == 0 {
+			dropped = true
+			cancel()
+		}
+	}, EndpointsCheckInterval, ctx.Done())
+	return dropped
+}
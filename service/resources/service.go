@@ -0,0 +1,191 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/Qihoo360/wayne/src/backend/client"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/apierror"
+	"github.com/Qihoo360/wayne/src/backend/plugins/service/logging"
+	"github.com/Qihoo360/wayne/src/backend/util/hack"
+)
+
+// clusterClients are the clients of a cluster sending the request id of the request context.
+type clusterClients struct {
+	// config is the config of the cluster manager the clients were built from.
+	config  *rest.Config
+	kube    kubernetes.Interface
+	dynamic dynamic.Interface
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*clusterClients)
+)
+
+// clientsOf returns the clients of the cluster, built again when the cluster manager was.
+// They are cached as wrapped transports are not shared between clients by client-go.
+func clientsOf(cluster string) (*clusterClients, error) {
+	manager, err := client.Manager(cluster)
+	if err != nil {
+		return nil, apierror.Upstream(cluster, err)
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, ok := clients[cluster]; ok && c.config == manager.Config {
+		return c, nil
+	}
+
+	config := rest.CopyConfig(manager.Config)
+	config.Wrap(logging.Transport)
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c := &clusterClients{config: manager.Config, kube: kube, dynamic: dyn}
+	clients[cluster] = c
+	return c, nil
+}
+
+// Client returns the kubernetes client of the cluster.
+func Client(cluster string) (kubernetes.Interface, error) {
+	c, err := clientsOf(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return c.kube, nil
+}
+
+// ServiceFromTemplate renders a ServiceTemplate into the Service to publish in namespace.
+func ServiceFromTemplate(tpl string, namespace string) (*v1.Service, error) {
+	service := &v1.Service{}
+	if err := json.Unmarshal(hack.Slice(tpl), service); err != nil {
+		return nil, fmt.Errorf("service template format error.%v", err.Error())
+	}
+	service.Namespace = namespace
+	return service, nil
+}
+
+// PatchServiceTemplate applies patch to the template as an unstructured object, so fields the
+// template leaves out, e.g. status and creationTimestamp, are not written into the result.
+func PatchServiceTemplate(tpl string, patch func(obj *unstructured.Unstructured) error) (string, error) {
+	content := make(map[string]interface{})
+	if err := json.Unmarshal(hack.Slice(tpl), &content); err != nil {
+		return "", fmt.Errorf("service template format error.%v", err.Error())
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	if err := patch(obj); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SetServiceSelector sets the keys of selector in spec.selector of the template, keeping the others.
+func SetServiceSelector(tpl string, selector map[string]string) (string, error) {
+	return PatchServiceTemplate(tpl, func(obj *unstructured.Unstructured) error {
+		current, _, err := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+		if err != nil {
+			return fmt.Errorf("service template format error.%v", err.Error())
+		}
+		if current == nil {
+			current = make(map[string]string)
+		}
+		for key, value := range selector {
+			current[key] = value
+		}
+		return unstructured.SetNestedStringMap(obj.Object, current, "spec", "selector")
+	})
+}
+
+// ClearNodePorts removes the node ports set in spec.ports of the template, leaving them to the cluster.
+func ClearNodePorts(tpl string) (string, error) {
+	return PatchServiceTemplate(tpl, func(obj *unstructured.Unstructured) error {
+		ports, found, err := unstructured.NestedSlice(obj.Object, "spec", "ports")
+		if err != nil {
+			return fmt.Errorf("service template format error.%v", err.Error())
+		}
+		if !found {
+			return nil
+		}
+		for _, port := range ports {
+			if port, ok := port.(map[string]interface{}); ok {
+				delete(port, "nodePort")
+			}
+		}
+		return unstructured.SetNestedSlice(obj.Object, ports, "spec", "ports")
+	})
+}
+
+// GetService returns the live Service, or nil if it does not exist.
+func GetService(ctx context.Context, cli kubernetes.Interface, namespace string, name string) (*v1.Service, error) {
+	service, err := cli.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return service, err
+}
+
+// Drift returns the fields declared in desired whose live value differs.
+// Fields left empty in desired are allocated by the cluster and ignored.
+func Drift(live *v1.Service, desired *v1.Service) []string {
+	if live == nil {
+		return []string{"metadata.name"}
+	}
+	drifted := []string{}
+	for key, value := range desired.Labels {
+		if live.Labels[key] != value {
+			drifted = append(drifted, fmt.Sprintf("metadata.labels.%s", key))
+		}
+	}
+	for key, value := range desired.Annotations {
+		if live.Annotations[key] != value {
+			drifted = append(drifted, fmt.Sprintf("metadata.annotations.%s", key))
+		}
+	}
+	if desired.Spec.Type != "" && desired.Spec.Type != live.Spec.Type {
+		drifted = append(drifted, "spec.type")
+	}
+	if !reflect.DeepEqual(desired.Spec.Selector, live.Spec.Selector) &&
+		!(len(desired.Spec.Selector) == 0 && len(live.Spec.Selector) == 0) {
+		drifted = append(drifted, "spec.selector")
+	}
+	if len(desired.Spec.Ports) != len(live.Spec.Ports) {
+		drifted = append(drifted, "spec.ports")
+		return drifted
+	}
+	for i, port := range desired.Spec.Ports {
+		livePort := live.Spec.Ports[i]
+		if port.NodePort == 0 {
+			port.NodePort = livePort.NodePort
+		}
+		if port.Protocol == "" {
+			port.Protocol = livePort.Protocol
+		}
+		if port.TargetPort.String() == "0" {
+			port.TargetPort = livePort.TargetPort
+		}
+		if !reflect.DeepEqual(port, livePort) {
+			drifted = append(drifted, fmt.Sprintf("spec.ports[%d]", i))
+		}
+	}
+	return drifted
+}
@@ -0,0 +1,24 @@
+package resources
+
+import (
+	"testing"
+)
+
+func TestClearNodePorts(t *testing.T) {
+	tpl := `{"apiVersion":"v1","kind":"Service","metadata":{"name":"web"},"spec":{"type":"NodePort",` +
+		`"ports":[{"port":80,"nodePort":30080},{"port":443}]}}`
+	cleared, err := ClearNodePorts(tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := ServiceFromTemplate(cleared, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports := NodePorts(service); len(ports) != 0 {
+		t.Errorf("node ports %v left", ports)
+	}
+	if len(service.Spec.Ports) != 2 || service.Spec.Ports[0].Port != 80 {
+		t.Errorf("ports %+v", service.Spec.Ports)
+	}
+}
@@ -0,0 +1,42 @@
+package resources
+
+import (
+	"k8s.io/api/core/v1"
+)
+
+// ServiceStatus is the state of a live Service in one cluster.
+type ServiceStatus struct {
+	Cluster string `json:"cluster"`
+	// TemplateId is the template last published to the cluster.
+	TemplateId int64 `json:"templateId"`
+	// Exists is false if the Service was deleted from the cluster outside of wayne.
+	Exists         bool                   `json:"exists"`
+	Type           v1.ServiceType         `json:"type,omitempty"`
+	Headless       bool                   `json:"headless,omitempty"`
+	IPFamilies     []v1.IPFamily          `json:"ipFamilies,omitempty"`
+	IPFamilyPolicy *v1.IPFamilyPolicy     `json:"ipFamilyPolicy,omitempty"`
+	ClusterIPs     map[v1.IPFamily]string `json:"clusterIPs,omitempty"`
+	ExternalName   string                 `json:"externalName,omitempty"`
+	Ports          []v1.ServicePort       `json:"ports,omitempty"`
+	// LoadBalancerIngress are the external IPs and hostnames of the load balancer once provisioned.
+	LoadBalancerIngress []string `json:"loadBalancerIngress,omitempty"`
+	Error               string   `json:"error,omitempty"`
+}
+
+// NewServiceStatus returns the status of live, nil live is a Service missing from the cluster.
+func NewServiceStatus(cluster string, templateId int64, live *v1.Service) *ServiceStatus {
+	status := &ServiceStatus{Cluster: cluster, TemplateId: templateId}
+	if live == nil {
+		return status
+	}
+	status.Exists = true
+	status.Type = live.Spec.Type
+	status.Headless = live.Spec.ClusterIP == v1.ClusterIPNone
+	status.IPFamilies = live.Spec.IPFamilies
+	status.IPFamilyPolicy = live.Spec.IPFamilyPolicy
+	status.ClusterIPs = ClusterIPsByFamily(live)
+	status.ExternalName = live.Spec.ExternalName
+	status.Ports = live.Spec.Ports
+	status.LoadBalancerIngress = LoadBalancerIngress(live)
+	return status
+}
@@ -103,4 +103,388 @@ func init() {
 			MethodParams:     param.Make(),
 			Params:           nil})
 
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"],
+		beego.ControllerComments{
+			Method:           "Create",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTokenController"],
+		beego.ControllerComments{
+			Method:           "Revoke",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Dependencies",
+			Router:           `/:id([0-9]+)/dependencies`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Dependents",
+			Router:           `/:id([0-9]+)/dependents`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceSearchController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceSearchController"],
+		beego.ControllerComments{
+			Method:           "Search",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Clone",
+			Router:           `/:id([0-9]+)/clone`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"],
+		beego.ControllerComments{
+			Method:           "Promote",
+			Router:           `/:id([0-9]+)/promote`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"],
+		beego.ControllerComments{
+			Method:           "Lineage",
+			Router:           `/:id([0-9]+)/lineage`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"],
+		beego.ControllerComments{
+			Method:           "Create",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"],
+		beego.ControllerComments{
+			Method:           "Update",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEnvironmentController"],
+		beego.ControllerComments{
+			Method:           "Delete",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"],
+		beego.ControllerComments{
+			Method:           "Lint",
+			Router:           `/lint`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"],
+		beego.ControllerComments{
+			Method:           "Rules",
+			Router:           `/rules`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"],
+		beego.ControllerComments{
+			Method:           "Create",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"],
+		beego.ControllerComments{
+			Method:           "Update",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceLintRuleSetController"],
+		beego.ControllerComments{
+			Method:           "Delete",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "Create",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "Update",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "Versions",
+			Router:           `/:id([0-9]+)/versions`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "Delete",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServicePolicyController"],
+		beego.ControllerComments{
+			Method:           "Evaluate",
+			Router:           `/evaluate`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceTplController"],
+		beego.ControllerComments{
+			Method:           "Publish",
+			Router:           `/:id([0-9]+)/publish`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Switch",
+			Router:           `/:id([0-9]+)/switch`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Canaries",
+			Router:           `/:id([0-9]+)/canary`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "StartCanary",
+			Router:           `/:id([0-9]+)/canary`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "CanaryWeight",
+			Router:           `/:id([0-9]+)/canary/weight`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "PromoteCanary",
+			Router:           `/:id([0-9]+)/canary/promote`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "AbortCanary",
+			Router:           `/:id([0-9]+)/canary/abort`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "CreateExternal",
+			Router:           `/external`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "CreateHeadless",
+			Router:           `/headless`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "Create",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "Get",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "Update",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "Delete",
+			Router:           `/:id([0-9]+)`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceEndpointsController"],
+		beego.ControllerComments{
+			Method:           "Publish",
+			Router:           `/:id([0-9]+)/publish`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Status",
+			Router:           `/:id([0-9]+)/status`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "SuggestNodePorts",
+			Router:           `/nodeports/suggest`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceMetricsController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceMetricsController"],
+		beego.ControllerComments{
+			Method:           "Get",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceNodePortController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceNodePortController"],
+		beego.ControllerComments{
+			Method:           "List",
+			Router:           `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceNodePortController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceNodePortController"],
+		beego.ControllerComments{
+			Method:           "Reconcile",
+			Router:           `/reconcile`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Events",
+			Router:           `/:id([0-9]+)/events`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Health",
+			Router:           `/:id([0-9]+)/health`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
+	beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"] = append(beego.GlobalControllerRouter["github.com/Qihoo360/wayne/src/backend/plugins/service/controller:ServiceController"],
+		beego.ControllerComments{
+			Method:           "Probe",
+			Router:           `/:id([0-9]+)/probe`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams:     param.Make(),
+			Params:           nil})
+
 }
@@ -16,7 +16,52 @@ func init() {
 			beego.NSInclude(
 				&controller.ServiceTplController{},
 			)),
+		beego.NSNamespace("/apps/:appid([0-9]+)/services/:serviceid([0-9]+)/endpoints",
+			beego.NSInclude(
+				&controller.ServiceEndpointsController{},
+			)),
+		beego.NSNamespace("/apps/:appid([0-9]+)/services/tokens",
+			beego.NSInclude(
+				&controller.ServiceTokenController{},
+			)),
+		beego.NSNamespace("/services/search",
+			beego.NSInclude(
+				&controller.ServiceSearchController{},
+			)),
+		beego.NSNamespace("/services/environments",
+			beego.NSInclude(
+				&controller.ServiceEnvironmentController{},
+			)),
+		beego.NSNamespace("/services/lintrulesets",
+			beego.NSInclude(
+				&controller.ServiceLintRuleSetController{},
+			)),
+		beego.NSNamespace("/services/policies",
+			beego.NSInclude(
+				&controller.ServicePolicyController{},
+			)),
+		beego.NSNamespace("/services/nodeports",
+			beego.NSInclude(
+				&controller.ServiceNodePortController{},
+			)),
+		beego.NSNamespace("/services/metrics",
+			beego.NSInclude(
+				&controller.ServiceMetricsController{},
+			)),
 	)
 
 	beego.AddNamespace(nsWithApp)
+
+	// memberships, groups and permissions are managed by wayne, see controller.PermissionsChanged
+	for _, pattern := range []string{
+		"/api/v1/apps/:appid/users",
+		"/api/v1/apps/:appid/users/*",
+		"/api/v1/groups",
+		"/api/v1/groups/*",
+		"/api/v1/permissions",
+		"/api/v1/permissions/*",
+		"/api/v1/users/*",
+	} {
+		beego.InsertFilter(pattern, beego.FinishRouter, controller.PermissionsChanged, false)
+	}
 }